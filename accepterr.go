@@ -0,0 +1,70 @@
+package turnstile
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+)
+
+// AcceptError is the net.Error returned by Accept and AcceptContext for
+// anything but the listener being closed, which is reported as a plain
+// net.ErrClosed. Whether it is Temporary is up to the listener's
+// AcceptErrorPolicy; http.Server and similar Serve loops back off and
+// retry after a temporary error and return after any other.
+type AcceptError struct {
+	Err       error
+	temporary bool
+}
+
+func (e *AcceptError) Error() string { return e.Err.Error() }
+func (e *AcceptError) Unwrap() error { return e.Err }
+
+// Timeout reports whether the error is a timeout, e.g. AcceptContext
+// running out of time.
+func (e *AcceptError) Timeout() bool {
+	return errors.Is(e.Err, context.DeadlineExceeded) || errors.Is(e.Err, os.ErrDeadlineExceeded)
+}
+
+// Temporary reports whether the AcceptErrorPolicy deemed the error
+// temporary, so a later Accept may succeed.
+func (e *AcceptError) Temporary() bool { return e.temporary }
+
+// AcceptErrorPolicy reports whether an error from Accept is temporary.
+type AcceptErrorPolicy func(err error) (temporary bool)
+
+// DefaultAcceptErrorPolicy treats ErrPaused and timeouts as temporary
+// and everything else, such as a failed open under WithFailFast, as
+// permanent.
+func DefaultAcceptErrorPolicy(err error) bool {
+	if errors.Is(err, ErrPaused) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() || errors.Is(err, context.DeadlineExceeded)
+}
+
+// RetryAcceptErrors treats every error as temporary, so a Serve loop
+// keeps going until the listener is closed.
+func RetryAcceptErrors(error) bool { return true }
+
+// WithAcceptErrorPolicy decides which errors from Accept are
+// temporary; see AcceptError. Without it, DefaultAcceptErrorPolicy is
+// used. Dialers ignore this option.
+func WithAcceptErrorPolicy(p AcceptErrorPolicy) Option {
+	return func(o *options) {
+		o.acceptErrs = p
+	}
+}
+
+// acceptError wraps err, from accepting a session, in an AcceptError.
+func (o options) acceptError(err error) error {
+	if errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	p := o.acceptErrs
+	if p == nil {
+		p = DefaultAcceptErrorPolicy
+	}
+	return &AcceptError{Err: err, temporary: p(err)}
+}
@@ -0,0 +1,108 @@
+package turnstile
+
+import (
+	"errors"
+	"net"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ErrAddressNotAllowed is the error, inside a *net.OpError, returned
+// by a dialer created WithAllowedAddresses for any other address.
+var ErrAddressNotAllowed = errors.New("turnstile: address not allowed")
+
+// WithAllowedAddresses restricts a dialer to the addresses matching
+// one of patterns, which use path.Match syntax against the normalized
+// "host:port" form of the address, e.g. "device:*" or "*:80". Dials to
+// other addresses fail with ErrAddressNotAllowed, so a program with
+// several dialers can't send traffic to the wrong device by mistake.
+// Without it, every address reaches the device. Listeners ignore this
+// option.
+func WithAllowedAddresses(patterns ...string) Option {
+	return func(o *options) {
+		o.allowAddrs = append(o.allowAddrs, patterns...)
+	}
+}
+
+// networks holds the network names registered with RegisterNetwork.
+var networks = struct {
+	sync.RWMutex
+	m map[string]bool
+}{m: map[string]bool{"pty": true, "rfcomm": true, "rfc2217": true}}
+
+// RegisterNetwork registers network names, so listeners and dialers
+// whose device name starts with one of them and a colon, such as
+// "rfcomm:00:11:22:33:44:55", report it from their addresses' Network
+// method instead of "serial". Gateways serving several kinds of link
+// can then tell them apart in handlers and metrics by
+// c.LocalAddr().Network(). The address's String is the full name,
+// scheme included. "pty", "rfcomm", and "rfc2217" are registered
+// already. Names take effect for listeners and dialers created after
+// they are registered.
+func RegisterNetwork(names ...string) {
+	networks.Lock()
+	defer networks.Unlock()
+	for _, n := range names {
+		networks.m[n] = true
+	}
+}
+
+// newSerialAddr returns the address of the device called name.
+func newSerialAddr(name string) serialAddr {
+	network := "serial"
+	if scheme, _, ok := strings.Cut(name, ":"); ok {
+		networks.RLock()
+		if networks.m[scheme] {
+			network = scheme
+		}
+		networks.RUnlock()
+	}
+	return serialAddr{network, name}
+}
+
+// dialAddr is the remote address of a dialed conn: whatever the caller
+// dialed, normalized, since it all leads to the same device.
+type dialAddr struct {
+	network, address string
+}
+
+func (a dialAddr) Network() string { return a.network }
+func (a dialAddr) String() string  { return a.address }
+
+// normalizeAddress puts address in "host:port" form, so code that
+// splits a conn's RemoteAddr works whatever was dialed. The host is
+// lower-cased and loses any trailing dot, and a missing port becomes
+// "0". An empty host becomes def.
+func normalizeAddress(address, def string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, ""
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		host = def
+	}
+	if port == "" {
+		port = "0"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// remoteAddr maps a dialed address to the remote address of its conn,
+// failing if WithAllowedAddresses doesn't allow it.
+func (d *ReopenDialer) remoteAddr(network, address string) (net.Addr, error) {
+	if network == "" {
+		network = d.addr.Network()
+	}
+	a := dialAddr{network, normalizeAddress(address, d.addr.String())}
+	if len(d.opts.allowAddrs) == 0 {
+		return a, nil
+	}
+	for _, p := range d.opts.allowAddrs {
+		if ok, _ := path.Match(p, a.address); ok {
+			return a, nil
+		}
+	}
+	return nil, &net.OpError{Op: "dial", Net: network, Addr: a, Err: ErrAddressNotAllowed}
+}
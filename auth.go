@@ -0,0 +1,162 @@
+package turnstile
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrAuthFailed is returned by the authenticators when the peer runs out
+// of attempts.
+var ErrAuthFailed = errors.New("turnstile: authentication failed")
+
+// AuthenticatedKey is the metadata key under which a conn that passed
+// PasswordAuth or ChallengeAuth records true.
+const AuthenticatedKey metaKey = "authenticated"
+
+// AuthConfig tunes PasswordAuth and ChallengeAuth. Zero fields take the
+// defaults noted.
+type AuthConfig struct {
+	// Prompt is sent before each password attempt. Default "Password: ".
+	Prompt string
+
+	// MaxAttempts is how many tries the peer gets per session.
+	// Default 3.
+	MaxAttempts int
+
+	// Timeout bounds how long each attempt waits for a reply.
+	// Default one minute.
+	Timeout time.Duration
+
+	// Lockout is how long the listener refuses everyone after a
+	// session runs out of attempts. It is spent holding the failed
+	// session, so nothing else is accepted in the meantime. Default
+	// none.
+	Lockout time.Duration
+}
+
+func (c AuthConfig) withDefaults() AuthConfig {
+	if c.Prompt == "" {
+		c.Prompt = "Password: "
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 3
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Minute
+	}
+	return c
+}
+
+// authLine matches a non-empty line and its CR, LF, or CRLF ending,
+// skipping the stray LF of a CRLF split across reads of the previous
+// one.
+var authLine = regexp.MustCompile(`[\r\n]*([^\r\n]+)(?:\r\n?|\n)`)
+
+// PasswordAuth returns an AcceptInterceptor that prompts for password
+// before handing the session out, for debug consoles exposed through a
+// bridge:
+//
+//	l := turnstile.NewReopenListener(open, "/dev/ttyS0",
+//		turnstile.WithAcceptInterceptor(turnstile.PasswordAuth(pw, turnstile.AuthConfig{})))
+//
+// The password isn't echoed. Input typed past the end of the password
+// line is discarded.
+func PasswordAuth(password string, cfg AuthConfig) AcceptInterceptor {
+	cfg = cfg.withDefaults()
+	return authenticator(cfg, func(e *Expecter) (bool, error) {
+		if err := e.Send(cfg.Prompt); err != nil {
+			return false, err
+		}
+		m, err := e.ExpectRegex(authLine, cfg.Timeout)
+		if err != nil {
+			return false, err
+		}
+		e.Send("\r\n")
+		return subtle.ConstantTimeCompare([]byte(m[1]), []byte(password)) == 1, nil
+	})
+}
+
+// ChallengeAuth returns an AcceptInterceptor that authenticates the
+// peer by proving knowledge of secret, without the secret crossing the
+// wire. It sends "challenge: " and a random hex nonce on a line of its
+// own, and expects back a line holding the hex HMAC-SHA256 of the
+// nonce, keyed with secret; see RespondChallenge.
+func ChallengeAuth(secret []byte, cfg AuthConfig) AcceptInterceptor {
+	cfg = cfg.withDefaults()
+	return authenticator(cfg, func(e *Expecter) (bool, error) {
+		nonce := make([]byte, 16)
+		rand.Read(nonce)
+		if err := e.Send(fmt.Sprintf("challenge: %x\r\n", nonce)); err != nil {
+			return false, err
+		}
+		m, err := e.ExpectRegex(authLine, cfg.Timeout)
+		if err != nil {
+			return false, err
+		}
+		got, err := hex.DecodeString(strings.TrimSpace(m[1]))
+		return err == nil && hmac.Equal(got, challengeMAC(secret, nonce)), nil
+	})
+}
+
+// RespondChallenge answers a ChallengeAuth challenge read from rw,
+// waiting up to timeout for it. It suits a dialer's WithHealthCheck
+// when the other end of the link is a turnstile listener.
+func RespondChallenge(rw io.ReadWriter, secret []byte, timeout time.Duration) error {
+	e := NewExpecter(rw)
+	m, err := e.ExpectRegex(regexp.MustCompile(`challenge: ([0-9a-f]+)\r?\n`), timeout)
+	if err != nil {
+		return err
+	}
+	nonce, err := hex.DecodeString(m[1])
+	if err != nil {
+		return err
+	}
+	if err := e.Send(fmt.Sprintf("%x\r\n", challengeMAC(secret, nonce))); err != nil {
+		return err
+	}
+	if _, err := e.ExpectString("OK\r\n", timeout); err != nil {
+		return err
+	}
+	e.Release()
+	return nil
+}
+
+func challengeMAC(secret, nonce []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(nonce)
+	return h.Sum(nil)
+}
+
+// authenticator runs attempt up to cfg.MaxAttempts times.
+func authenticator(cfg AuthConfig, attempt func(*Expecter) (bool, error)) AcceptInterceptor {
+	return func(c *Conn) error {
+		e := NewExpecter(c)
+		for range cfg.MaxAttempts {
+			ok, err := attempt(e)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err := e.Send("OK\r\n"); err != nil {
+					return err
+				}
+				// Input after the credentials belongs to the session.
+				e.Release()
+				c.SetValue(AuthenticatedKey, true)
+				return nil
+			}
+			e.Send("denied\r\n")
+		}
+		time.Sleep(cfg.Lockout)
+		return ErrAuthFailed
+	}
+}
@@ -0,0 +1,99 @@
+package turnstile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrNoBaudRate is returned by an AutoBaud OpenFunc when the device
+// didn't answer the probe at any of the rates tried.
+var ErrNoBaudRate = errors.New("turnstile: no baud rate answered the probe")
+
+// AutoBaud returns an OpenFunc for devices of unknown speed. Each open
+// tries the rates in turn, opening the device with openAt and running
+// probe on it, and keeps the first RWC the probe succeeds on. Once a
+// rate has worked it is tried first on later opens.
+//
+// The RWC returned reports the rate through a BaudRate() int method,
+// and conns from listeners and dialers include it in their LocalAddr,
+// as in "/dev/ttyUSB0@115200".
+func AutoBaud(openAt func(baud int) (io.ReadWriteCloser, error), rates []int, probe func(io.ReadWriter) error) OpenFunc {
+	var mu sync.Mutex
+	last := 0
+	return func() (io.ReadWriteCloser, error) {
+		mu.Lock()
+		order := make([]int, 0, len(rates)+1)
+		if last != 0 {
+			order = append(order, last)
+		}
+		for _, r := range rates {
+			if r != last {
+				order = append(order, r)
+			}
+		}
+		mu.Unlock()
+
+		errs := []error{ErrNoBaudRate}
+		for _, rate := range order {
+			c, err := openAt(rate)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%d: %w", rate, err))
+				continue
+			}
+			// Let the probe hand back input it read past the reply.
+			pc := &prefixRWC{ReadWriteCloser: c}
+			if err := probe(pc); err != nil {
+				c.Close()
+				errs = append(errs, fmt.Errorf("%d: %w", rate, err))
+				continue
+			}
+			mu.Lock()
+			last = rate
+			mu.Unlock()
+			if !pc.back.empty() {
+				c = pc
+			}
+			return &baudRWC{ReadWriteCloser: c, rate: rate}, nil
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// Probe returns a probe for AutoBaud or WithHealthCheck that sends send
+// and waits up to timeout for a reply matching expect. Input after the
+// reply is handed back with Expecter.Release, so the session sees it.
+func Probe(send string, expect *regexp.Regexp, timeout time.Duration) func(io.ReadWriter) error {
+	return func(rw io.ReadWriter) error {
+		e := NewExpecter(rw)
+		if err := e.Send(send); err != nil {
+			return err
+		}
+		if _, err := e.ExpectRegex(expect, timeout); err != nil {
+			return err
+		}
+		e.Release()
+		return nil
+	}
+}
+
+// baudRWC is an RWC opened at a known baud rate.
+type baudRWC struct {
+	io.ReadWriteCloser
+	rate int
+}
+
+func (b *baudRWC) BaudRate() int              { return b.rate }
+func (b *baudRWC) Unwrap() io.ReadWriteCloser { return b.ReadWriteCloser }
+
+// localAddr returns addr, annotated with the baud rate if rwc reports one.
+func localAddr(addr net.Addr, rwc io.ReadWriteCloser) net.Addr {
+	if b, ok := unwrapTo[interface{ BaudRate() int }](rwc); ok {
+		return serialAddr{addr.Network(), fmt.Sprintf("%s@%d", addr, b.BaudRate())}
+	}
+	return addr
+}
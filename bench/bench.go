@@ -0,0 +1,267 @@
+// Package bench measures the quality of a serial link by echoing
+// traffic through it: goodput, round trip times, and how much data
+// came back corrupted or not at all. The far end must echo everything
+// it receives, e.g. a loopback plug or a device in echo mode.
+//
+//	res, err := bench.Run(open, bench.Config{})
+//	fmt.Println(res)
+//
+// It is meant for validating cabling and adapters before putting a
+// protocol on top; see cmd/turnstile-bench for a command line front end.
+package bench
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/sparques/turnstile"
+)
+
+// Config tunes a benchmark. Zero fields take the defaults noted.
+type Config struct {
+	// Bytes is how much data the throughput test sends. Default 64 KiB.
+	Bytes int
+
+	// ChunkSize is the size of each write in the throughput test.
+	// Default 256.
+	ChunkSize int
+
+	// Pings is how many round trips the latency test times.
+	// Default 100.
+	Pings int
+
+	// PingSize is the size of each ping, at least 8. Default 16.
+	PingSize int
+
+	// Timeout is how long to wait for echoed data before counting it
+	// as lost. Default 2s.
+	Timeout time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.Bytes <= 0 {
+		c.Bytes = 64 << 10
+	}
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = 256
+	}
+	if c.Pings <= 0 {
+		c.Pings = 100
+	}
+	if c.PingSize < 8 {
+		c.PingSize = 16
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 2 * time.Second
+	}
+	return c
+}
+
+// Result holds the outcome of a benchmark.
+type Result struct {
+	// Sent and Received count the throughput test's bytes; Corrupt
+	// counts received bytes that didn't match what was sent.
+	Sent, Received, Corrupt int
+	// Elapsed is how long the throughput test took.
+	Elapsed time.Duration
+
+	// RTT holds the round trip time of every ping that came back
+	// intact, sorted ascending. Lost counts pings that didn't.
+	RTT  []time.Duration
+	Lost int
+}
+
+// Goodput returns the rate of intact data echoed back, in bytes per
+// second.
+func (r *Result) Goodput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Received-r.Corrupt) / r.Elapsed.Seconds()
+}
+
+// ErrorRate returns the fraction of throughput bytes that came back
+// corrupted or not at all.
+func (r *Result) ErrorRate() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	bad := r.Corrupt + max(r.Sent-r.Received, 0)
+	return float64(bad) / float64(r.Sent)
+}
+
+// Percentile returns the pth percentile (0-100) round trip time, or 0
+// if no ping came back.
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.RTT) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(r.RTT)-1))
+	return r.RTT[min(max(i, 0), len(r.RTT)-1)]
+}
+
+func (r *Result) String() string {
+	return fmt.Sprintf("goodput %.0f B/s, errors %.4f%% (%d corrupt, %d missing of %d), rtt min/p50/p99/max %v/%v/%v/%v, %d/%d pings lost",
+		r.Goodput(), 100*r.ErrorRate(), r.Corrupt, max(r.Sent-r.Received, 0), r.Sent,
+		r.Percentile(0), r.Percentile(50), r.Percentile(99), r.Percentile(100),
+		r.Lost, r.Lost+len(r.RTT))
+}
+
+// Run opens the device with open, runs the latency and throughput
+// tests on it, and closes it.
+func Run(open turnstile.OpenFunc, cfg Config) (*Result, error) {
+	rwc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rwc.Close()
+	return Test(rwc, cfg)
+}
+
+// Test runs the latency and throughput tests on rw. It leaves a
+// goroutine reading rw until the read fails, so rw should be closed
+// once Test returns.
+func Test(rw io.ReadWriter, cfg Config) (*Result, error) {
+	cfg = cfg.withDefaults()
+	e := newEchoReader(rw)
+	res := new(Result)
+	if err := latency(rw, e, cfg, res); err != nil {
+		return res, err
+	}
+	if err := throughput(rw, e, cfg, res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func latency(w io.Writer, e *echoReader, cfg Config, res *Result) error {
+	ping := make([]byte, cfg.PingSize)
+	for i := range cfg.Pings {
+		e.drain()
+		binary.BigEndian.PutUint64(ping, uint64(i))
+		for j := 8; j < len(ping); j++ {
+			ping[j] = byte(i + j)
+		}
+		start := time.Now()
+		if _, err := w.Write(ping); err != nil {
+			return err
+		}
+		got, err := e.collect(len(ping), cfg.Timeout)
+		if err != nil {
+			return err
+		}
+		if string(got) != string(ping) {
+			res.Lost++
+			continue
+		}
+		res.RTT = append(res.RTT, time.Since(start))
+	}
+	slices.Sort(res.RTT)
+	return nil
+}
+
+func throughput(w io.Writer, e *echoReader, cfg Config, res *Result) error {
+	e.drain()
+	pattern := func(i int) byte { return byte(i % 251) }
+	werr := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		buf := make([]byte, cfg.ChunkSize)
+		for off := 0; off < cfg.Bytes; off += len(buf) {
+			buf = buf[:min(cfg.ChunkSize, cfg.Bytes-off)]
+			for i := range buf {
+				buf[i] = pattern(off + i)
+			}
+			if _, err := w.Write(buf); err != nil {
+				werr <- err
+				return
+			}
+		}
+		werr <- nil
+	}()
+
+	got, err := e.collect(cfg.Bytes, cfg.Timeout)
+	res.Elapsed = time.Since(start)
+	res.Sent = cfg.Bytes
+	res.Received = len(got)
+	for i, b := range got {
+		if b != pattern(i) {
+			res.Corrupt++
+		}
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-werr:
+		return err
+	case <-time.After(cfg.Timeout):
+		// The writer is stuck; the data it couldn't send is already
+		// counted as missing.
+		return nil
+	}
+}
+
+// echoReader reads in the background so that waiting for echoed data
+// can time out.
+type echoReader struct {
+	data chan []byte
+	err  error // valid once data is closed
+}
+
+func newEchoReader(r io.Reader) *echoReader {
+	e := &echoReader{data: make(chan []byte, 64)}
+	go func() {
+		for {
+			buf := make([]byte, 4096)
+			n, err := r.Read(buf)
+			if n > 0 {
+				e.data <- buf[:n]
+			}
+			if err != nil {
+				e.err = err
+				close(e.data)
+				return
+			}
+		}
+	}()
+	return e
+}
+
+// drain discards data that has already arrived.
+func (e *echoReader) drain() {
+	for {
+		select {
+		case _, ok := <-e.data:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// collect gathers n bytes, stopping early if no data arrives for
+// timeout. It only returns an error if the stream fails.
+func (e *echoReader) collect(n int, timeout time.Duration) ([]byte, error) {
+	got := make([]byte, 0, n)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for len(got) < n {
+		select {
+		case b, ok := <-e.data:
+			if !ok {
+				return got, e.err
+			}
+			got = append(got, b...)
+			timer.Reset(timeout)
+		case <-timer.C:
+			return got, nil
+		}
+	}
+	return got[:n], nil
+}
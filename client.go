@@ -5,146 +5,195 @@ import (
 	"io"
 	"net"
 	"sync"
-	"time"
 )
 
-// OpenFunc, serialAddr, rwConn, rwNilCloser, and reopenListener definitions
-// are exactly as in your existing code.
-
 // --- Client-side: one-at-a-time dialer over an io.ReadWriteCloser ---
 
-type reopenDialer struct {
-	open OpenFunc
-	addr net.Addr
+// ReopenDialer hands out a single active net.Conn at a time. Callers
+// blocked in Dial/DialContext are served in the order they arrived.
+type ReopenDialer struct {
+	*core
 
-	mu       sync.Mutex
-	closed   bool
-	closedCh chan struct{} // non-nil while a conn is active; closed when that conn closes
+	// For WithReuseActive.
+	dialing chan struct{} // held while dialing a conn to share
+	smu     sync.Mutex
+	active  *sharedConn
 }
 
-func NewReopenDialer(open OpenFunc, name string) *reopenDialer {
-	return &reopenDialer{
-		open: open,
-		addr: serialAddr(name),
+func NewReopenDialer(open OpenFunc, name string, opts ...Option) *ReopenDialer {
+	return &ReopenDialer{
+		core:    newCore(open, name, newOptions(opts)),
+		dialing: make(chan struct{}, 1),
 	}
 }
 
-func NewReadWriterDialer(rw io.ReadWriter, name string) *reopenDialer {
-	return &reopenDialer{
-		open: func() (io.ReadWriteCloser, error) {
-			return rwNilCloser{rw}, nil
-		},
-		addr: serialAddr(name),
-	}
+func NewReadWriterDialer(rw io.ReadWriter, name string, opts ...Option) *ReopenDialer {
+	return NewReopenDialer(func() (io.ReadWriteCloser, error) {
+		return rwNilCloser{rw}, nil
+	}, name, opts...)
+}
+
+// Reconfigure replaces the OpenFunc, e.g. to change the device path or
+// baud rate at runtime. The new function is used from the next open
+// on; if closeActive is set, the active conn (if any) is closed so
+// that happens right away. This pairs well with a signal handler:
+//
+//	sig := make(chan os.Signal, 1)
+//	signal.Notify(sig, syscall.SIGHUP)
+//	go func() {
+//		for range sig {
+//			d.Reconfigure(loadOpenFunc(), true)
+//		}
+//	}()
+func (d *ReopenDialer) Reconfigure(open OpenFunc, closeActive bool) {
+	d.reconfigure(open, closeActive)
+}
+
+// QueueLength returns the number of Dial calls currently waiting
+// for the active conn to close.
+func (d *ReopenDialer) QueueLength() int { return d.gate.queueLength() }
+
+// Pause stops the dialer from handing out new sessions, e.g. while
+// an operator flashes firmware over the same port out-of-band. While
+// paused, Dial calls block (or fail with ErrPaused, WithPauseError) and
+// the device isn't opened. An active conn is left alone; close it or
+// wait for State().Active to turn false before touching the device.
+func (d *ReopenDialer) Pause() { d.pause() }
+
+// Resume undoes Pause.
+func (d *ReopenDialer) Resume() { d.resume() }
+
+// Exclusive waits its turn like Dial, then opens the device and runs
+// fn with raw access to it, e.g. to flash firmware or talk to a
+// bootloader between regular sessions. The device is closed when fn
+// returns and normal service carries on; fn's error is returned. No
+// health check, middleware, or session accounting applies, and
+// Exclusive works even while paused, so Pause followed by Exclusive
+// keeps regular sessions out until Resume.
+func (d *ReopenDialer) Exclusive(ctx context.Context, fn func(io.ReadWriteCloser) error) error {
+	return d.exclusive(ctx, fn)
 }
 
+// WaitFree blocks until no session is active and nobody is waiting
+// for one, e.g. to schedule maintenance for the moment the port goes
+// idle. It returns ctx's error if ctx is done first, or net.ErrClosed
+// if the dialer is closed. The slot may be taken again right away; use
+// Exclusive to hold on to it.
+func (d *ReopenDialer) WaitFree(ctx context.Context) error { return d.gate.waitFree(ctx) }
+
+// Notify sends EventBusy and EventFree to ch as the dialer goes in and
+// out of use. Sends don't block, so events are dropped if ch isn't
+// ready; give it a buffer. Call StopNotify to stop.
+func (d *ReopenDialer) Notify(ch chan<- Event) { d.gate.addNotify(ch) }
+
+// StopNotify stops sending events to ch.
+func (d *ReopenDialer) StopNotify(ch chan<- Event) { d.gate.removeNotify(ch) }
+
+// State returns a snapshot of the dialer's state.
+func (d *ReopenDialer) State() State { return d.state() }
+
+// String describes the dialer for logs: its device, whether it is
+// idle, active, paused, or closed, and how many Dial calls are waiting
+// and sessions have been handed out.
+func (d *ReopenDialer) String() string { return d.describe("dialer") }
+
+// Sessions returns the summaries of the latest sessions, oldest first,
+// as kept WithSessionHistory.
+func (d *ReopenDialer) Sessions() []SessionSummary { return d.history.list() }
+
 // Close prevents future Dial calls from succeeding and wakes any blocked callers.
-func (d *reopenDialer) Close() error {
-	d.mu.Lock()
-	d.closed = true
-	ch := d.closedCh
-	d.mu.Unlock()
-
-	if ch != nil {
-		// Wake any Dial blocked waiting for the current conn to finish.
-		select {
-		case <-ch:
-		default:
-			close(ch)
-		}
-	}
-	return nil
+func (d *ReopenDialer) Close() error {
+	return d.close()
 }
 
 // DialContext returns a single active net.Conn at a time, blocking until
 // the previous conn (if any) is closed, or until ctx is cancelled.
-func (d *reopenDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	// Fast-fail if context already cancelled.
-	if err := ctx.Err(); err != nil {
+//
+// Every address leads to the device, and none is resolved, so clients
+// can dial names like "device:80". The conn's RemoteAddr reports the
+// network and address dialed, in "host:port" form.
+func (d *ReopenDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.DialPriority(ctx, network, address, 0)
+}
+
+// DialConnContext is like DialContext, but the returned conn is also
+// closed automatically once connCtx is done. Pass the same context for
+// both to tie the conn to a single request.
+func (d *ReopenDialer) DialConnContext(ctx, connCtx context.Context, network, address string) (net.Conn, error) {
+	remote, err := d.remoteAddr(network, address)
+	if err != nil {
 		return nil, err
 	}
-
-	// Ensure only one active connection at a time.
-	for {
-		d.mu.Lock()
-		if d.closed {
-			d.mu.Unlock()
-			return nil, net.ErrClosed
-		}
-		// If a connection is already active, wait for it to close.
-		if ch := d.closedCh; ch != nil {
-			d.mu.Unlock()
-			select {
-			case <-ch:
-				// Try again; the conn is now closed.
-				continue
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			}
+	if d.opts.reuseActive {
+		r, err := d.shared(ctx, remote, 0)
+		if err != nil {
+			return nil, err
 		}
-		// No active conn; we're the one that gets to open it.
-		d.mu.Unlock()
-		break
+		r.closeOnDone(connCtx)
+		return d.opts.wrap(r), nil
 	}
+	c, err := d.session(ctx, 0, remote, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.closeOnDone(connCtx)
+	return d.opts.wrap(c), nil
+}
 
-	// Retry loop to open the underlying RWC with backoff.
-	backoff := 100 * time.Millisecond
-	for {
-		if err := ctx.Err(); err != nil {
-			return nil, err
-		}
+// DialPriority is like DialContext, but callers with a higher priority
+// are served before those with a lower one. DialContext uses priority 0.
+//
+// If the dialer was created WithPreemption, a waiting caller that
+// outranks the active conn's priority closes that conn once the grace
+// period has passed.
+func (d *ReopenDialer) DialPriority(ctx context.Context, network, address string, priority int) (net.Conn, error) {
+	remote, err := d.remoteAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return d.dialTo(ctx, remote, priority)
+}
 
-		c, err := d.open()
-		if err == nil {
-			d.mu.Lock()
-			if d.closed {
-				d.mu.Unlock()
-				c.Close()
-				return nil, net.ErrClosed
-			}
-			ch := make(chan struct{})
-			d.closedCh = ch
-			d.mu.Unlock()
-
-			rc := &rwConn{
-				ReadWriteCloser: c,
-				local:           d.addr,
-				// The "remote" here is largely cosmetic; HTTP clients don't care.
-				remote: serialAddr(address),
-				onClose: func() {
-					d.mu.Lock()
-					if d.closedCh != nil {
-						close(d.closedCh)
-						d.closedCh = nil
-					}
-					d.mu.Unlock()
-				},
-			}
-			return rc, nil
+// dialTo dials a conn whose RemoteAddr is remote.
+func (d *ReopenDialer) dialTo(ctx context.Context, remote net.Addr, priority int) (net.Conn, error) {
+	if d.opts.reuseActive {
+		r, err := d.shared(ctx, remote, priority)
+		if err != nil {
+			return nil, err
 		}
+		return d.opts.wrap(r), nil
+	}
+	c, err := d.session(ctx, priority, remote, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.opts.wrap(c), nil
+}
 
-		// If the dialer has been closed, stop retrying.
-		d.mu.Lock()
-		closed := d.closed
-		d.mu.Unlock()
-		if closed {
-			return nil, net.ErrClosed
-		}
+// Session is Dial for callers that don't need a net.Conn, such as code
+// driving its own protocol over the device: the session is the same,
+// with the same one-at-a-time hand-off and middleware, but is returned
+// as a plain io.ReadWriteCloser with no address to dial. Closing it ends
+// the session. It is the conn Dial would return, so with
+// WithConnMiddleware it is whatever the middleware returned, not the
+// *Conn underneath. WithAllowedAddresses and WithReuseActive don't
+// apply.
+func (d *ReopenDialer) Session() (io.ReadWriteCloser, error) {
+	return d.SessionContext(context.Background())
+}
 
-		// Backoff, but remain cancellable by ctx.
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-time.After(backoff):
-		}
-		if backoff < 2*time.Second {
-			backoff *= 2
-		}
+// SessionContext is like Session, but stops waiting when ctx is
+// cancelled.
+func (d *ReopenDialer) SessionContext(ctx context.Context) (io.ReadWriteCloser, error) {
+	c, err := d.session(ctx, 0, d.addr, nil, nil)
+	if err != nil {
+		return nil, err
 	}
+	return d.opts.wrap(c), nil
 }
 
 // Dial is a convenience wrapper for DialContext with a background context.
 // This makes it plug in nicely anywhere a plain Dial func is accepted.
-func (d *reopenDialer) Dial(network, address string) (net.Conn, error) {
+func (d *ReopenDialer) Dial(network, address string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, address)
 }
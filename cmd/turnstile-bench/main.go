@@ -0,0 +1,62 @@
+// Command turnstile-bench checks a serial link by echoing traffic
+// through it and reporting goodput, round trip times, and error rate.
+// The far end must echo what it receives, e.g. a loopback plug.
+//
+//	turnstile-bench [flags] /dev/ttyUSB0
+//	turnstile-bench [flags] tcp:gateway:7000
+//
+// Device files are opened as they are, so set the line up beforehand,
+// e.g. with stty.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/sparques/turnstile/bench"
+)
+
+func main() {
+	var cfg bench.Config
+	flag.IntVar(&cfg.Bytes, "bytes", 64<<10, "bytes to send in the throughput test")
+	flag.IntVar(&cfg.ChunkSize, "chunk", 256, "write size in the throughput test")
+	flag.IntVar(&cfg.Pings, "pings", 100, "round trips to time")
+	flag.IntVar(&cfg.PingSize, "ping-size", 16, "size of each ping")
+	flag.DurationVar(&cfg.Timeout, "timeout", 0, "how long to wait for echoed data (default 2s)")
+	runs := flag.Int("n", 1, "number of runs")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] device|tcp:host:port\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	target := flag.Arg(0)
+	open := func() (io.ReadWriteCloser, error) {
+		if addr, ok := strings.CutPrefix(target, "tcp:"); ok {
+			return net.Dial("tcp", addr)
+		}
+		return os.OpenFile(target, os.O_RDWR, 0)
+	}
+
+	failed := false
+	for i := range *runs {
+		res, err := bench.Run(open, cfg)
+		if res != nil {
+			fmt.Printf("run %d: %v\n", i+1, res)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "run %d: %v\n", i+1, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,101 @@
+// Command turnstile-httpd serves HTTP over a serial link: a directory,
+// or a reverse proxy to another server, on whatever is at the far end.
+// The link is reopened whenever it fails, so the server survives the
+// adapter being unplugged.
+//
+//	turnstile-httpd [flags] /dev/ttyUSB0
+//	turnstile-httpd -proxy http://localhost:8080 usb:0403:6001
+//
+// The device can also be given as unix:PATH, e.g. for an emulator's
+// serial socket. Device files are opened as they are, so set the line
+// up beforehand, e.g. with stty. On the other end, anything that
+// speaks HTTP over the link will do, such as cmd/turnstile bridging
+// the line to a TCP port for a browser.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sparques/turnstile"
+	"github.com/sparques/turnstile/serialopen"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory to serve")
+	proxy := flag.String("proxy", "", "reverse proxy to `url` instead of serving a directory")
+	verbose := flag.Bool("v", false, "log requests")
+	reopenDelay := flag.Duration("reopen-delay", 0, "minimum time between closing the device and reopening it")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] device|usb:VID:PID[:SERIAL]|unix:path\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	device := flag.Arg(0)
+	open, err := openFunc(device)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var h http.Handler = http.FileServer(http.Dir(*dir))
+	if *proxy != "" {
+		u, err := url.Parse(*proxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h = httputil.NewSingleHostReverseProxy(u)
+	}
+	if *verbose {
+		next := h
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("%s %s", r.Method, r.URL)
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	l := turnstile.NewReopenListener(open, device,
+		turnstile.WithReopenDelay(*reopenDelay),
+		// Let the last response out before a session is torn down.
+		turnstile.WithDrainOnClose(0),
+	)
+	// Each session is a single connection that lives as long as the
+	// link does, so keep-alives stay on and there is no idle timeout.
+	srv := &http.Server{Handler: h}
+	log.Printf("serving on %s", device)
+	log.Fatal(srv.Serve(l))
+}
+
+func openFunc(device string) (turnstile.OpenFunc, error) {
+	kind, arg, _ := strings.Cut(device, ":")
+	switch kind {
+	case "usb":
+		parts := strings.SplitN(arg, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("%s: want usb:VID:PID[:SERIAL]", device)
+		}
+		vid, err1 := strconv.ParseUint(parts[0], 16, 16)
+		pid, err2 := strconv.ParseUint(parts[1], 16, 16)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%s: bad USB IDs", device)
+		}
+		u := serialopen.USB{VID: uint16(vid), PID: uint16(pid)}
+		if len(parts) == 3 {
+			u.Serial = parts[2]
+		}
+		return serialopen.ByUSB(u, nil), nil
+	case "unix":
+		return serialopen.Unix(arg, 0), nil
+	}
+	return serialopen.ByPath(device, nil), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sparques/turnstile"
+	"github.com/sparques/turnstile/serialopen"
+)
+
+// endpoint is one side of the bridge.
+type endpoint interface {
+	get(ctx context.Context) (net.Conn, error)
+	Close() error
+}
+
+// dialEndpoint is an endpoint turnstile opens, and reopens, itself.
+type dialEndpoint struct {
+	*turnstile.ReopenDialer
+	name string
+}
+
+func (e dialEndpoint) get(ctx context.Context) (net.Conn, error) {
+	return e.DialContext(ctx, "serial", e.name)
+}
+
+// listenEndpoint is an endpoint that accepts connections.
+type listenEndpoint struct {
+	net.Listener
+}
+
+func (e listenEndpoint) get(ctx context.Context) (net.Conn, error) {
+	stop := context.AfterFunc(ctx, func() { e.Listener.Close() })
+	defer stop()
+	return e.Accept()
+}
+
+// parseEndpoint sets up the endpoint described by spec. Options apply
+// to endpoints turnstile opens; accepted connections get them through
+// a listener wrapping each one.
+func parseEndpoint(spec string, opts []turnstile.Option, quit func()) (endpoint, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	dial := func(open turnstile.OpenFunc) (endpoint, error) {
+		return dialEndpoint{turnstile.NewReopenDialer(open, spec, opts...), spec}, nil
+	}
+	switch kind {
+	case "serial":
+		return dial(serialopen.ByPath(arg, nil))
+	case "usb":
+		u, err := parseUSB(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", spec, err)
+		}
+		return dial(serialopen.ByUSB(u, nil))
+	case "tcp":
+		return dial(func() (io.ReadWriteCloser, error) { return net.Dial("tcp", arg) })
+	case "unix":
+		return dial(serialopen.Unix(arg, 0))
+	case "tcp-listen", "unix-listen":
+		l, err := net.Listen(strings.TrimSuffix(kind, "-listen"), arg)
+		if err != nil {
+			return nil, err
+		}
+		return listenEndpoint{listenerWithOptions(l, opts)}, nil
+	case "stdio":
+		opts = append(opts, turnstile.WithConnMiddleware(func(c net.Conn) net.Conn {
+			return &escapeConn{c, quit}
+		}))
+		return dialEndpoint{turnstile.NewStdioDialer(opts...), spec}, nil
+	case "pty":
+		p, err := openPTY(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", spec, err)
+		}
+		d := turnstile.NewReopenDialer(func() (io.ReadWriteCloser, error) {
+			p.SetReadDeadline(time.Time{})
+			return ptySession{p}, nil
+		}, spec, opts...)
+		return ptyEndpoint{dialEndpoint{d, spec}, p}, nil
+	}
+	if strings.HasPrefix(spec, "/") {
+		return dial(serialopen.ByPath(spec, nil))
+	}
+	return nil, fmt.Errorf("unknown endpoint %q", spec)
+}
+
+// listenerWithOptions runs each conn l accepts through a one-session
+// turnstile listener, so options such as middleware apply to it too.
+func listenerWithOptions(l net.Listener, opts []turnstile.Option) net.Listener {
+	return &optionListener{l, opts}
+}
+
+type optionListener struct {
+	net.Listener
+	opts []turnstile.Option
+}
+
+func (l *optionListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return turnstile.NewOneShotListener(func() (io.ReadWriteCloser, error) {
+		return c, nil
+	}, c.RemoteAddr().String(), l.opts...).Accept()
+}
+
+// parseUSB parses VID:PID[:SERIAL], with the IDs in hex.
+func parseUSB(s string) (serialopen.USB, error) {
+	var u serialopen.USB
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 {
+		return u, fmt.Errorf("want VID:PID[:SERIAL]")
+	}
+	vid, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return u, fmt.Errorf("bad vendor ID %q", parts[0])
+	}
+	pid, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return u, fmt.Errorf("bad product ID %q", parts[1])
+	}
+	u.VID, u.PID = uint16(vid), uint16(pid)
+	if len(parts) == 3 {
+		u.Serial = parts[2]
+	}
+	return u, nil
+}
+
+// escapeChar, Ctrl-], quits from a raw terminal, where Ctrl-C is
+// passed through like any other key.
+const escapeChar = 0x1d
+
+type escapeConn struct {
+	net.Conn
+	quit func()
+}
+
+func (c *escapeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if i := bytes.IndexByte(p[:n], escapeChar); i >= 0 {
+		c.quit()
+		return i, io.EOF
+	}
+	return n, err
+}
+
+// pty is a pseudo-terminal's master side. The slave is held open as
+// well, so the master doesn't see EIO every time the program using it
+// closes it.
+type pty struct {
+	*os.File
+	slave *os.File
+}
+
+func (p *pty) Close() error {
+	return errors.Join(p.File.Close(), p.slave.Close())
+}
+
+// ptyEndpoint is the dialEndpoint for a pty, which it closes along
+// with the dialer.
+type ptyEndpoint struct {
+	dialEndpoint
+	pty *pty
+}
+
+func (e ptyEndpoint) Close() error {
+	return errors.Join(e.dialEndpoint.Close(), e.pty.Close())
+}
+
+// ptySession is a session on the pty master, which outlives it.
+// Closing it interrupts a pending Read, so the data that Read would
+// have taken goes to the next session instead.
+type ptySession struct {
+	*pty
+}
+
+func (s ptySession) Close() error {
+	return s.SetReadDeadline(time.Now())
+}
+
+// stderrf reports a note to the user.
+func stderrf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
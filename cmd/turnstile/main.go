@@ -0,0 +1,132 @@
+// Command turnstile bridges two endpoints, reopening either side when
+// it fails: a flaky USB serial adapter to a TCP port, a serial console
+// to the terminal, an emulator's unix socket to a pseudo-terminal.
+//
+//	turnstile [flags] A B
+//
+// Endpoints are:
+//
+//	/dev/ttyUSB0, serial:PATH   a serial device
+//	usb:VID:PID[:SERIAL]        a USB serial adapter, found again after a replug
+//	tcp:HOST:PORT               a TCP connection
+//	tcp-listen:[HOST]:PORT      TCP connections accepted on a port
+//	unix:PATH                   a unix socket connection
+//	unix-listen:PATH            unix socket connections accepted on PATH
+//	stdio                       the terminal (stdin and stdout)
+//	pty[:LINK]                  a new pseudo-terminal, optionally linked to LINK (Linux)
+//
+// With -raw, the default, Ctrl-C on a stdio endpoint goes to the far
+// end; press Ctrl-] to quit.
+//
+// A session starts with A and then B; whenever either side of a
+// session closes or fails, the other is closed too and a new session
+// begins. Serial devices are opened as they are, so set the line up
+// beforehand, e.g. with stty. Framing and capture apply to A.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sparques/turnstile"
+	"github.com/sparques/turnstile/transcript"
+)
+
+func main() {
+	framing := flag.String("framing", "raw", "line endings for A: raw, crlf, cr, or lf")
+	capture := flag.String("capture", "", "record A's sessions in `dir`")
+	raw := flag.Bool("raw", true, "put the terminal into raw mode for stdio")
+	verbose := flag.Bool("v", false, "log sessions")
+	jitter := flag.String("jitter", "none", "randomize reopen backoff: none, full, or equal")
+	reopenDelay := flag.Duration("reopen-delay", 0, "minimum time between closing a device and reopening it")
+	failFast := flag.Bool("fail-fast", false, "exit if an endpoint can't be opened the first time")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] A B\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	logf := func(string, ...any) {}
+	if *verbose {
+		logf = log.Printf
+	}
+
+	opts := []turnstile.Option{turnstile.WithReopenDelay(*reopenDelay)}
+	switch *jitter {
+	case "none":
+	case "full":
+		opts = append(opts, turnstile.WithJitter(turnstile.FullJitter))
+	case "equal":
+		opts = append(opts, turnstile.WithJitter(turnstile.EqualJitter))
+	default:
+		log.Fatalf("unknown jitter %q", *jitter)
+	}
+	if *failFast {
+		opts = append(opts, turnstile.WithFailFast())
+	}
+	if *raw {
+		opts = append(opts, turnstile.WithRawTerminal())
+	}
+
+	var aOpts []turnstile.Option
+	switch *framing {
+	case "raw":
+	case "crlf", "cr", "lf":
+		nl := map[string]turnstile.Newline{"crlf": turnstile.NewlineCRLF, "cr": turnstile.NewlineCR, "lf": turnstile.NewlineLF}[*framing]
+		aOpts = append(aOpts, turnstile.WithConnMiddleware(func(c net.Conn) net.Conn {
+			return turnstile.NewLineConn(c, turnstile.LineDiscipline{InputNewline: turnstile.NewlineLF, OutputNewline: nl})
+		}))
+	default:
+		log.Fatalf("unknown framing %q", *framing)
+	}
+	if *capture != "" {
+		r := &transcript.Recorder{Dir: *capture, OnError: func(err error) { log.Print(err) }}
+		aOpts = append(aOpts, turnstile.WithConnMiddleware(r.Middleware()))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	a, err := parseEndpoint(flag.Arg(0), append(opts, aOpts...), stop)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := parseEndpoint(flag.Arg(1), opts, stop)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer a.Close()
+	defer b.Close()
+	for {
+		ca, err := a.get(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("%s: %v", flag.Arg(0), err)
+			}
+			return
+		}
+		logf("%s: connected", flag.Arg(0))
+		cb, err := b.get(ctx)
+		if err != nil {
+			ca.Close()
+			if ctx.Err() == nil {
+				log.Printf("%s: %v", flag.Arg(1), err)
+			}
+			return
+		}
+		logf("%s: connected", flag.Arg(1))
+		st, err := turnstile.Proxy(ctx, ca, cb, nil)
+		if err != nil {
+			logf("session ended: %v", err)
+		}
+		logf("session over: %d bytes A to B, %d bytes B to A", st.AToB, st.BToA)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestPTYSlaveHeld checks that the slave stays open after a GC, so a
+// read on the master waits for data rather than failing with EIO.
+func TestPTYSlaveHeld(t *testing.T) {
+	p, err := openPTY("")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer p.Close()
+	for range 3 {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := p.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read on master: %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// openPTY is only implemented on Linux.
+func openPTY(link string) (*pty, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,129 @@
+package turnstile
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceKey is the metadata key under which a CombinedListener records
+// the name of the listener a conn came from, e.g. "/dev/ttyUSB1".
+const SourceKey metaKey = "source"
+
+// CombinedListener accepts sessions from several listeners, e.g. one
+// per UART, and hands them out through a single net.Listener, so one
+// http.Server or grpc.Server can serve them all. Each listener's
+// sessions are accepted as soon as they can start, and wait for an
+// Accept call.
+type CombinedListener struct {
+	ls    []*ReopenListener
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+// NewCombinedListener returns a listener combining ls. Each conn it
+// accepts records its listener's name under SourceKey, if there is a
+// *Conn behind it (see ConnContext). A listener that fails with a
+// permanent error drops out; Accept returns net.ErrClosed once all
+// have.
+func NewCombinedListener(ls ...*ReopenListener) *CombinedListener {
+	cl := &CombinedListener{
+		ls:    ls,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	cl.wg.Add(len(ls))
+	for _, l := range ls {
+		go cl.run(l)
+	}
+	go func() {
+		cl.wg.Wait()
+		close(cl.conns)
+	}()
+	return cl
+}
+
+// run accepts sessions from l until it fails for good or cl is closed.
+func (cl *CombinedListener) run(l *ReopenListener) {
+	defer cl.wg.Done()
+	var backoff time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			var ae *AcceptError
+			if !errors.As(err, &ae) || !ae.Temporary() {
+				return
+			}
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			select {
+			case <-time.After(backoff):
+			case <-cl.done:
+				return
+			}
+			continue
+		}
+		backoff = 0
+		if tc, ok := unwrapConn(c); ok {
+			tc.SetValue(SourceKey, l.Addr().String())
+		}
+		select {
+		case cl.conns <- c:
+		case <-cl.done:
+			c.Close()
+			return
+		}
+	}
+}
+
+// Accept returns the next session from any of the listeners.
+func (cl *CombinedListener) Accept() (net.Conn, error) {
+	select {
+	case c, ok := <-cl.conns:
+		if !ok {
+			return nil, net.ErrClosed
+		}
+		return c, nil
+	case <-cl.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all the listeners.
+func (cl *CombinedListener) Close() error {
+	cl.once.Do(func() {
+		close(cl.done)
+		for _, l := range cl.ls {
+			l.Close()
+		}
+	})
+	return nil
+}
+
+// Addr returns an address naming all the listeners. Its network is
+// theirs if they all share one, and "serial" otherwise.
+func (cl *CombinedListener) Addr() net.Addr {
+	names := make([]string, len(cl.ls))
+	network := ""
+	for i, l := range cl.ls {
+		a := l.Addr()
+		names[i] = a.String()
+		switch network {
+		case "":
+			network = a.Network()
+		case a.Network():
+		default:
+			network = "serial"
+		}
+	}
+	if network == "" {
+		network = "serial"
+	}
+	return serialAddr{network, strings.Join(names, ",")}
+}
+
+// Listeners returns the listeners cl combines.
+func (cl *CombinedListener) Listeners() []*ReopenListener { return cl.ls }
@@ -1,42 +1,457 @@
 package turnstile
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // serialAddr implements net.Addr
-// Network() always returns "serial"
-// String() returns serialAddr itself (cast to a string).
-type serialAddr string
+// Network() returns the network registered for the name's scheme, or
+// "serial" (see RegisterNetwork).
+// String() returns the name as given.
+type serialAddr struct {
+	network, name string
+}
 
-func (a serialAddr) Network() string { return "serial" }
-func (a serialAddr) String() string  { return string(a) }
+func (a serialAddr) Network() string { return a.network }
+func (a serialAddr) String() string  { return a.name }
 
-// rwConn implements net.Conn
+// Conn is the net.Conn handed out by ReopenListener and ReopenDialer.
 // net.Conn is an interface that includes an io.ReadWriteCloser()
 // so to use an io.ReadWriterCloser as a net.Conn, only the remaining
 // methods of net.Conn need to be implemented.
 //
-// All the Deadline methods (SetDeadline, SetReadDeadline,
-// SetWriteDeadline) are nil operations.
-type rwConn struct {
-	io.ReadWriteCloser
+// The Deadline methods (SetDeadline, SetReadDeadline,
+// SetWriteDeadline) are passed on to the RWC when it supports
+// deadlines, and are otherwise nil operations.
+type Conn struct {
+	rwc           io.ReadWriteCloser
 	local, remote net.Addr
 	onClose       func()
+	vals          *values
+
+	serialWrites bool
+	wmu          sync.Mutex
+
+	readTimeout     time.Duration
+	strictDeadlines bool
+	rmu             sync.Mutex
+	rpending        bool // a background read is outstanding
+	rresults        chan readResult
+	rbuf            []byte  // data from a background read not yet returned
+	rpooled         *[]byte // pooled buffer backing rbuf, if any
+	rerr            error   // error to return once rbuf is drained
+
+	back putBack // input handed back by an Expecter; Read returns it first
+
+	watched  bool         // WithWatchdog is on
+	lastRead atomic.Int64 // when Read last returned data, in Unix nanoseconds
+
+	// Statistics for the SessionSummary.
+	id                uint64
+	start             time.Time
+	bytesIn, bytesOut atomic.Int64
+	readErr, writeErr atomic.Pointer[error] // first error seen each way
+	peerEOF           atomic.Bool           // Read has returned io.EOF
+	reason            atomic.Int32          // a CloseReason; the first one recorded wins
+	span              Span                  // WithTracer's session span, or nil
+
+	drain        bool
+	drainTimeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	stops  []func() bool // cancel the close triggers set up by closeOnDone and closeAt
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+// String describes the session for logs, e.g.
+// "/dev/ttyUSB0 session 3 (open 1.5s, in=120 out=48)".
+func (c *Conn) String() string {
+	state := "open " + time.Since(c.start).Round(time.Millisecond).String()
+	if c.isClosed() {
+		state = "closed: " + CloseReason(c.reason.Load()).String()
+	}
+	return fmt.Sprintf("%v session %d (%s, in=%d out=%d)", c.local, c.id, state, c.bytesIn.Load(), c.bytesOut.Load())
 }
 
-func (c *rwConn) LocalAddr() net.Addr              { return c.local }
-func (c *rwConn) RemoteAddr() net.Addr             { return c.remote }
-func (c *rwConn) SetDeadline(time.Time) error      { return nil }
-func (c *rwConn) SetReadDeadline(time.Time) error  { return nil }
-func (c *rwConn) SetWriteDeadline(time.Time) error { return nil }
-func (c *rwConn) Close() error {
+type readResult struct {
+	b   []byte
+	err error
+}
+
+// Read reads from the underlying RWC. If the conn was created
+// WithReadTimeout, a Read that sees no data within the timeout returns
+// os.ErrDeadlineExceeded, a net.Error whose Timeout method reports
+// true. The read it was waiting on stays outstanding, and its data is
+// returned by the next Read, so nothing is lost.
+func (c *Conn) Read(p []byte) (int, error) {
+	if !c.back.empty() {
+		// Counted when first read.
+		return c.back.read(p)
+	}
+	n, err := c.read(p)
+	c.noteRead(int64(n), err)
+	return n, err
+}
+
+// unread and reclaim let an Expecter, such as an authenticator's, hand
+// input it read past its last match back to the conn.
+func (c *Conn) unread(pb putBack) { c.back = pb }
+
+func (c *Conn) reclaim() (putBack, io.Reader) {
+	pb := c.back
+	c.back = putBack{}
+	return pb, connReader{c}
+}
+
+// connReader reads from a conn, bypassing whatever was put back.
+type connReader struct{ c *Conn }
+
+func (r connReader) Read(p []byte) (int, error) {
+	n, err := r.c.read(p)
+	r.c.noteRead(int64(n), err)
+	return n, err
+}
+
+// noteRead and noteWrite update the session's statistics.
+func (c *Conn) noteRead(n int64, err error) {
+	if n > 0 {
+		c.bytesIn.Add(n)
+		if c.watched {
+			c.lastRead.Store(time.Now().UnixNano())
+		}
+	}
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		c.readErr.CompareAndSwap(nil, &err)
+		if err == io.EOF {
+			c.peerEOF.Store(true)
+		}
+		if err == ErrPeerRestarted {
+			go c.closeFor(ReasonPeerRestarted)
+		}
+	}
+}
+
+func (c *Conn) noteWrite(n int64, err error) {
+	c.bytesOut.Add(n)
+	if err != nil {
+		c.writeErr.CompareAndSwap(nil, &err)
+	}
+}
+
+func (c *Conn) read(p []byte) (int, error) {
+	if c.readTimeout <= 0 {
+		return c.rwc.Read(p)
+	}
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if len(c.rbuf) == 0 && c.rerr == nil {
+		if !c.rpending {
+			c.rpending = true
+			if c.rresults == nil {
+				c.rresults = make(chan readResult, 1)
+			}
+			var buf []byte
+			if len(p) <= copyBufSize {
+				c.rpooled = getBuf()
+				buf = (*c.rpooled)[:len(p)]
+			} else {
+				buf = make([]byte, len(p))
+			}
+			go func() {
+				n, err := c.rwc.Read(buf)
+				c.rresults <- readResult{buf[:n], err}
+			}()
+		}
+		t := time.NewTimer(c.readTimeout)
+		defer t.Stop()
+		select {
+		case r := <-c.rresults:
+			c.rpending = false
+			c.rbuf, c.rerr = r.b, r.err
+		case <-t.C:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	if len(c.rbuf) > 0 {
+		return n, nil
+	}
+	if c.rpooled != nil {
+		putBuf(c.rpooled)
+		c.rpooled = nil
+	}
+	err := c.rerr
+	c.rerr = nil
+	return n, err
+}
+
+// Write writes p to the underlying RWC. If the conn was created
+// WithSerializedWrites, concurrent Writes are serialized and each one
+// is written out in full before the next begins, so their bytes never
+// interleave on the wire.
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.write(p)
+	c.noteWrite(int64(n), err)
+	return n, err
+}
+
+func (c *Conn) write(p []byte) (int, error) {
+	if !c.serialWrites {
+		return c.rwc.Write(p)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var n int
+	for n < len(p) {
+		m, err := c.rwc.Write(p[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// Close closes the underlying RWC and frees the slot for the next
+// session. It is safe to call concurrently and more than once; only
+// the first call does anything, and later ones return net.ErrClosed.
+func (c *Conn) Close() error {
+	return c.closeFor(ReasonLocal)
+}
+
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// closeFor closes c, recording r as the reason unless one has been
+// recorded already.
+func (c *Conn) closeFor(r CloseReason) error {
+	if c.peerEOF.Load() {
+		r = ReasonPeerEOF
+	}
+	c.reason.CompareAndSwap(0, int32(r))
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	for _, stop := range c.stops {
+		stop()
+	}
+	c.stops = nil
+	c.mu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.drain {
+		c.flush()
+	}
+	// Close the underlying RWC before onClose lets anyone re-open it.
+	err := c.rwc.Close()
 	if c.onClose != nil {
 		c.onClose()
 	}
-	return c.ReadWriteCloser.Close()
+	return err
+}
+
+// Drainer is implemented by RWCs that can wait for the data written to
+// them to actually leave, e.g. with tcdrain on a tty. Drain blocks until
+// the transmit buffer is empty.
+type Drainer interface {
+	Drain() error
+}
+
+// flush pushes out whatever the RWC still holds before it is closed,
+// for WithDrainOnClose.
+func (c *Conn) flush() {
+	if f, ok := c.rwc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	d, ok := c.rwc.(Drainer)
+	if !ok {
+		return
+	}
+	if c.drainTimeout <= 0 {
+		d.Drain()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		d.Drain()
+		close(done)
+	}()
+	t := time.NewTimer(c.drainTimeout)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+		// Give up; closing the RWC should unblock the Drain.
+	}
+}
+
+// ReadFrom implements io.ReaderFrom. When both r and the underlying
+// RWC are backed by file descriptors (e.g. a TCP conn and a tty), the
+// data is spliced in the kernel rather than copied through user space.
+// Otherwise, if the RWC implements io.ReaderFrom itself, the copy is
+// handed to it so device-specific fast paths still get used.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if c.serialWrites {
+		// Every chunk has to take the write lock; Write counts it.
+		return io.Copy(writerOnly{c}, r)
+	}
+	n, err := c.readFrom(r)
+	c.bytesOut.Add(n)
+	return n, err
+}
+
+func (c *Conn) readFrom(r io.Reader) (int64, error) {
+	if n, handled, err := splice(c.rwc, r, c.isClosed); handled {
+		return n, err
+	}
+	if rf, ok := c.rwc.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return copyPooled(writerOnly{c.rwc}, r)
+}
+
+// WriteTo implements io.WriterTo, splicing like ReadFrom when possible
+// and otherwise deferring to the RWC's own io.WriterTo, if any.
+func (c *Conn) WriteTo(w io.Writer) (int64, error) {
+	if c.readTimeout > 0 || c.watched || !c.back.empty() {
+		// Reads have to go through the timeout machinery, be seen by
+		// the watchdog, or start with input put back; Read counts
+		// them.
+		return copyPooled(w, readerOnly{c})
+	}
+	n, err := c.writeTo(w)
+	c.bytesIn.Add(n)
+	return n, err
+}
+
+func (c *Conn) writeTo(w io.Writer) (int64, error) {
+	if n, handled, err := splice(w, c.rwc, c.isClosed); handled {
+		return n, err
+	}
+	if wt, ok := c.rwc.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return copyPooled(w, readerOnly{c.rwc})
+}
+
+// copyPooled is io.Copy with a pooled buffer.
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	b := getBuf()
+	defer putBuf(b)
+	return io.CopyBuffer(dst, src, *b)
+}
+
+// writerOnly and readerOnly hide any ReadFrom/WriteTo methods, so
+// io.Copy doesn't loop back into Conn.
+type writerOnly struct{ io.Writer }
+type readerOnly struct{ io.Reader }
+
+// Unwrap returns the io.ReadWriteCloser underlying c, so callers can
+// reach device-specific methods such as line settings.
+func (c *Conn) Unwrap() io.ReadWriteCloser { return c.rwc }
+
+// SyscallConn returns a raw connection to the file descriptor behind
+// the RWC, for ioctls such as custom baud rates or latency timer tuning
+// on the live session. The RWC, or one it unwraps to, must implement
+// syscall.Conn or have an Fd() uintptr method; otherwise SyscallConn
+// returns errors.ErrUnsupported. I/O done through the RawConn bypasses
+// WithReadTimeout and WithSerializedWrites.
+func (c *Conn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := device[syscall.Conn](c); ok {
+		return sc.SyscallConn()
+	}
+	if f, ok := device[interface{ Fd() uintptr }](c); ok {
+		return fdRawConn{f.Fd(), c.isClosed}, nil
+	}
+	return nil, errors.ErrUnsupported
+}
+
+// fdRawConn is a syscall.RawConn for a bare file descriptor, which the
+// runtime poller knows nothing about. Read and Write call fn until it
+// reports done, waiting for the descriptor to be ready in between;
+// where that isn't supported, they give up with errors.ErrUnsupported
+// rather than spin. Nothing can wake a wait when the descriptor is
+// closed, so each one is bounded by fdPollInterval, after which closed
+// is checked and, once it reports true, net.ErrClosed returned.
+type fdRawConn struct {
+	fd     uintptr
+	closed func() bool
+}
+
+// fdPollInterval bounds how long fdRawConn waits for readiness before
+// checking whether the conn was closed.
+const fdPollInterval = 100 * time.Millisecond
+
+func (f fdRawConn) Control(fn func(fd uintptr)) error {
+	fn(f.fd)
+	return nil
+}
+
+func (f fdRawConn) Read(fn func(fd uintptr) bool) error { return f.retry(fn, false) }
+
+func (f fdRawConn) Write(fn func(fd uintptr) bool) error { return f.retry(fn, true) }
+
+func (f fdRawConn) retry(fn func(fd uintptr) bool, write bool) error {
+	for {
+		if f.closed() {
+			return net.ErrClosed
+		}
+		if fn(f.fd) {
+			return nil
+		}
+		if err := waitFD(f.fd, write, fdPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// closeOnDone arranges for c to be closed once ctx is done.
+func (c *Conn) closeOnDone(ctx context.Context) {
+	if ctx.Done() == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.stops = append(c.stops, context.AfterFunc(ctx, func() { c.closeFor(ReasonContext) }))
+	}
+}
+
+// closeAt arranges for c to be closed at t, for reason r.
+func (c *Conn) closeAt(t time.Time, r CloseReason) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.stops = append(c.stops, time.AfterFunc(time.Until(t), func() { c.closeFor(r) }).Stop)
+	}
 }
 
 // rwNilCloser is a small utility type. It has a nil-operation
@@ -0,0 +1,277 @@
+package turnstile
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// devices counts how often devices were opened and closed.
+type devices struct {
+	opens, closes atomic.Int32
+}
+
+// open returns an OpenFunc for countingRWCs counted in d.
+func (d *devices) open() OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		d.opens.Add(1)
+		return countingRWC{&d.closes}, nil
+	}
+}
+
+// check fails the test unless every device opened was closed once.
+func (d *devices) check(t *testing.T) {
+	t.Helper()
+	if o, c := d.opens.Load(), d.closes.Load(); o != c {
+		t.Fatalf("%d devices opened, %d closes", o, c)
+	}
+}
+
+// countingRWC is a device that reads EOF, swallows writes, and counts
+// how often it is closed.
+type countingRWC struct {
+	closes *atomic.Int32
+}
+
+func (countingRWC) Read(p []byte) (int, error)  { return 0, io.EOF }
+func (countingRWC) Write(p []byte) (int, error) { return len(p), nil }
+
+func (r countingRWC) Close() error {
+	r.closes.Add(1)
+	return nil
+}
+
+// waitOrFail waits for wg, failing the test if it takes too long.
+func waitOrFail(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("goroutines still blocked")
+	}
+}
+
+// closeAll calls c.Close from n goroutines at once, and also, if it
+// isn't nil, at the same time. It returns how many of the Close calls
+// returned nil; the rest must return net.ErrClosed. It may be called
+// from any goroutine.
+func closeAll(t *testing.T, c net.Conn, n int, also func() error) int {
+	t.Helper()
+	var ok atomic.Int32
+	var wg sync.WaitGroup
+	if also != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			also()
+		}()
+	}
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch err := c.Close(); {
+			case err == nil:
+				ok.Add(1)
+			case !errors.Is(err, net.ErrClosed):
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	return int(ok.Load())
+}
+
+func TestConnCloseConcurrent(t *testing.T) {
+	var devs devices
+	l := NewReopenListener(devs.open(), "test")
+	defer l.Close()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := closeAll(t, c, 8, nil); n != 1 {
+		t.Fatalf("%d Close calls succeeded, want 1", n)
+	}
+	devs.check(t)
+	if r := c.(*Conn).CloseReason(); r != ReasonLocal {
+		t.Fatalf("close reason %v, want %v", r, ReasonLocal)
+	}
+
+	// The slot was freed exactly once, so one more session fits.
+	c, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.QueueLength() != 0 {
+		t.Fatal("caller still queued")
+	}
+	c.Close()
+}
+
+func TestConnCloseRacesAccept(t *testing.T) {
+	for range 50 {
+		var devs devices
+		l := NewReopenListener(devs.open(), "test")
+		var served atomic.Int32
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					c, err := l.Accept()
+					if err != nil {
+						if !errors.Is(err, net.ErrClosed) {
+							t.Error(err)
+						}
+						return
+					}
+					// Close the listener as one conn closes, with the
+					// others waiting for the slot.
+					var also func() error
+					if served.Add(1) == 20 {
+						also = l.Close
+					}
+					if n := closeAll(t, c, 2, also); n != 1 {
+						t.Errorf("%d Close calls succeeded, want 1", n)
+					}
+				}
+			}()
+		}
+		waitOrFail(t, &wg)
+		devs.check(t)
+		if l.State().Active {
+			t.Fatal("session active after Close")
+		}
+	}
+}
+
+func TestConnCloseRacesDial(t *testing.T) {
+	for range 50 {
+		var devs devices
+		d := NewReopenDialer(devs.open(), "test")
+		var served atomic.Int32
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					c, err := d.Dial("serial", "test")
+					if err != nil {
+						if !errors.Is(err, net.ErrClosed) {
+							t.Error(err)
+						}
+						return
+					}
+					// Close the dialer as one conn closes, with the
+					// others waiting for the slot.
+					var also func() error
+					if served.Add(1) == 20 {
+						also = d.Close
+					}
+					if n := closeAll(t, c, 2, also); n != 1 {
+						t.Errorf("%d Close calls succeeded, want 1", n)
+					}
+				}
+			}()
+		}
+		waitOrFail(t, &wg)
+		devs.check(t)
+	}
+}
+
+func TestConnCloseAfterListenerClose(t *testing.T) {
+	var devs devices
+	l := NewReopenListener(devs.open(), "test")
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := closeAll(t, c, 4, l.Close); n != 1 {
+		t.Fatalf("%d Close calls succeeded, want 1", n)
+	}
+	devs.check(t)
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Accept after Close: %v", err)
+	}
+}
+
+// streamRWC is a device that reads size bytes and then EOF, over and
+// over, and swallows writes.
+type streamRWC struct {
+	size, left int
+}
+
+func (s *streamRWC) Read(p []byte) (int, error) {
+	if s.left == 0 {
+		s.left = s.size
+		return 0, io.EOF
+	}
+	n := min(len(p), s.left)
+	s.left -= n
+	return n, nil
+}
+
+func (s *streamRWC) Write(p []byte) (int, error) { return len(p), nil }
+func (s *streamRWC) Close() error                { return nil }
+
+// benchConn returns a conn on a streamRWC reading size bytes at a time.
+func benchConn(b *testing.B, size int, opts ...Option) net.Conn {
+	b.Helper()
+	l := NewReopenListener(func() (io.ReadWriteCloser, error) {
+		return &streamRWC{size, size}, nil
+	}, "bench", opts...)
+	b.Cleanup(func() { l.Close() })
+	c, err := l.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { c.Close() })
+	return c
+}
+
+func BenchmarkConnCopy(b *testing.B) {
+	const size = 1 << 20
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Direct", nil},
+		{"ReadTimeout", []Option{WithReadTimeout(time.Minute)}},
+	} {
+		b.Run(bc.name+"/WriteTo", func(b *testing.B) {
+			c := benchConn(b, size, bc.opts...)
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := io.Copy(io.Discard, c); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(bc.name+"/ReadFrom", func(b *testing.B) {
+			c := benchConn(b, size, bc.opts...)
+			src := &streamRWC{size, size}
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for b.Loop() {
+				// Hide src's methods, so the copy goes through
+				// Conn.ReadFrom.
+				if _, err := io.Copy(c, struct{ io.Reader }{src}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package turnstile
+
+import (
+	"context"
+	"net"
+)
+
+// connKey is the context key for the *Conn a context belongs to.
+type connKey struct{}
+
+// WithConnContext lets fn add values to each conn's context, such as a
+// session ID or the device path, much like http.Server.ConnContext.
+// fn is called once per session, before Accept/Dial return the conn,
+// with a context that already carries the conn and its metadata.
+func WithConnContext(fn func(ctx context.Context, c *Conn) context.Context) Option {
+	return func(o *options) {
+		o.connContext = fn
+	}
+}
+
+// initContext sets up c's context, based on base.
+func (c *Conn) initContext(base context.Context, fn func(context.Context, *Conn) context.Context) {
+	ctx, cancel := context.WithCancel(context.WithValue(base, connKey{}, c))
+	c.cancel = cancel
+	c.ctx = valuesContext{ctx, c.vals}
+	if fn != nil {
+		c.ctx = fn(c.ctx, c)
+	}
+}
+
+// Context returns the conn's context. It carries the conn itself (see
+// ConnFromContext), the conn's metadata (see Value), and whatever
+// WithConnContext added, and is cancelled when the conn is closed.
+func (c *Conn) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// ConnFromContext returns the conn whose context ctx is, or was derived
+// from.
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	c, ok := ctx.Value(connKey{}).(*Conn)
+	return c, ok
+}
+
+// ConnContext adds the values of the turnstile conn behind c to ctx. It
+// has the signature of http.Server.ConnContext, so handlers serving
+// HTTP over a serial link can reach its metadata:
+//
+//	srv := &http.Server{Handler: h, ConnContext: turnstile.ConnContext}
+//	...
+//	baud := r.Context().Value(turnstile.BaudRateKey)
+//
+// c may be wrapped by ConnMiddleware, as long as each wrapper has an
+// Unwrap() net.Conn method. If there's no turnstile conn behind c, ctx
+// is returned as it is. Values in ctx take precedence, and ctx's
+// deadline and cancellation are kept.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := unwrapConn(c); ok {
+		return mergedContext{ctx, tc.Context()}
+	}
+	return ctx
+}
+
+// unwrapConn returns the turnstile conn behind c, following Unwrap()
+// net.Conn methods.
+func unwrapConn(c net.Conn) (*Conn, bool) {
+	for {
+		if tc, ok := c.(*Conn); ok {
+			return tc, true
+		}
+		u, ok := c.(interface{ Unwrap() net.Conn })
+		if !ok {
+			return nil, false
+		}
+		c = u.Unwrap()
+	}
+}
+
+// valuesContext falls back on a conn's metadata for values.
+type valuesContext struct {
+	context.Context
+	vals *values
+}
+
+func (v valuesContext) Value(key any) any {
+	if val := v.Context.Value(key); val != nil {
+		return val
+	}
+	if v.vals == nil {
+		return nil
+	}
+	return v.vals.Value(key)
+}
+
+// mergedContext is a context with the values of a second one added.
+type mergedContext struct {
+	context.Context
+	extra context.Context
+}
+
+func (m mergedContext) Value(key any) any {
+	if val := m.Context.Value(key); val != nil {
+		return val
+	}
+	return m.extra.Value(key)
+}
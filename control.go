@@ -0,0 +1,103 @@
+package turnstile
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// BreakSender is implemented by RWCs that can send a break, holding
+// the line in the spacing state for d. Serial consoles use it for
+// things like the Linux magic SysRq key.
+type BreakSender interface {
+	Break(d time.Duration) error
+}
+
+// ControlLineSetter is implemented by RWCs that can drive the DTR and
+// RTS modem control lines.
+type ControlLineSetter interface {
+	SetDTR(on bool) error
+	SetRTS(on bool) error
+}
+
+// ControlLines holds the state of the modem status lines.
+type ControlLines struct {
+	CTS, DSR, RI, DCD bool
+}
+
+// ControlLineReader is implemented by RWCs that can report the state
+// of the CTS, DSR, RI, and DCD modem status lines.
+type ControlLineReader interface {
+	ControlLines() (ControlLines, error)
+}
+
+// device returns the first RWC along the chain of Unwrap methods,
+// starting with c's own, that implements T.
+func device[T any](c *Conn) (T, bool) {
+	return unwrapTo[T](c.rwc)
+}
+
+// unwrapTo returns the first RWC along the chain of Unwrap methods,
+// starting with rw, that implements T.
+func unwrapTo[T any](rw io.ReadWriteCloser) (T, bool) {
+	for {
+		if t, ok := rw.(T); ok {
+			return t, true
+		}
+		u, ok := rw.(interface{ Unwrap() io.ReadWriteCloser })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		rw = u.Unwrap()
+	}
+}
+
+// SendBreak sends a break lasting d. It uses the RWC's BreakSender
+// implementation, or failing that a SetBreak(on bool) error method,
+// as offered by rfc2217.Client. It returns errors.ErrUnsupported if
+// the RWC can do neither.
+func (c *Conn) SendBreak(d time.Duration) error {
+	if b, ok := device[BreakSender](c); ok {
+		return b.Break(d)
+	}
+	b, ok := device[interface{ SetBreak(on bool) error }](c)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if err := b.SetBreak(true); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return b.SetBreak(false)
+}
+
+// SetDTR raises or lowers DTR, or returns errors.ErrUnsupported if the
+// RWC doesn't implement ControlLineSetter.
+func (c *Conn) SetDTR(on bool) error {
+	s, ok := device[ControlLineSetter](c)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return s.SetDTR(on)
+}
+
+// SetRTS raises or lowers RTS, or returns errors.ErrUnsupported if the
+// RWC doesn't implement ControlLineSetter.
+func (c *Conn) SetRTS(on bool) error {
+	s, ok := device[ControlLineSetter](c)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return s.SetRTS(on)
+}
+
+// ControlLines reports the modem status lines, or returns
+// errors.ErrUnsupported if the RWC doesn't implement ControlLineReader.
+func (c *Conn) ControlLines() (ControlLines, error) {
+	r, ok := device[ControlLineReader](c)
+	if !ok {
+		return ControlLines{}, errors.ErrUnsupported
+	}
+	return r.ControlLines()
+}
@@ -0,0 +1,345 @@
+package turnstile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrPaused is returned by Accept and Dial while a listener or dialer
+// created WithPauseError is paused.
+var ErrPaused = errors.New("turnstile: paused")
+
+// core is the one-session-at-a-time machinery shared by ReopenListener
+// and ReopenDialer, so both sides queue, open, back off, and enforce
+// the session policy the same way.
+type core struct {
+	addr   net.Addr
+	gate   *gate
+	policy *sessionPolicy
+	opts   options
+
+	history *history // nil unless WithSessionHistory
+
+	onData bool // hold sessions back until the device sends data; see WithAcceptOnData
+
+	smu   sync.Mutex
+	stray putBack // input abandoned on a stream whose Close doesn't interrupt reads
+
+	pmu      sync.Mutex
+	resumeCh chan struct{} // non-nil while paused; closed by resume
+}
+
+func newCore(open OpenFunc, name string, o options) *core {
+	addr := newSerialAddr(name)
+	if o.network != "" {
+		addr.network = o.network
+	}
+	c := &core{
+		addr:    addr,
+		gate:    o.newGate(),
+		policy:  o.newPolicy(open),
+		opts:    o,
+		history: newHistory(o.history),
+	}
+	if o.eager {
+		go c.warmUp()
+	}
+	return c
+}
+
+// session waits for the slot, opens the underlying RWC, and returns
+// the conn for the new session. ready, if set, is called once the slot
+// is held, before any policy checks, and may refuse the session by
+// returning an error. ended, if set, is called when the conn is closed,
+// before the slot is handed on.
+func (c *core) session(ctx context.Context, prio int, remote net.Addr, ready func(context.Context) error, ended func()) (*Conn, error) {
+	if c.opts.pauseErr && c.paused() {
+		return nil, ErrPaused
+	}
+	// Wait our turn; only one conn is active at a time.
+	if err := c.gate.acquire(ctx, prio); err != nil {
+		return nil, err
+	}
+	if ready != nil {
+		if err := ready(ctx); err != nil {
+			c.gate.release()
+			return nil, err
+		}
+	}
+	if c.policy.exhausted() {
+		// We only get here once the last session has closed.
+		c.gate.close()
+		c.gate.release()
+		return nil, net.ErrClosed
+	}
+	if err := c.policy.waitCooldown(ctx, c.gate.done); err != nil {
+		c.gate.release()
+		return nil, err
+	}
+
+	// Retry loop to open the underlying RWC with backoff.
+	backoff := 100 * time.Millisecond
+	device := slog.String("device", c.addr.String())
+	for attempt := 1; ; attempt++ {
+		if err := c.waitResume(ctx); err != nil {
+			c.gate.release()
+			return nil, err
+		}
+		if err := c.policy.waitReopen(ctx, c.gate.done); err != nil {
+			c.gate.release()
+			return nil, err
+		}
+		_, span := c.opts.startSpan(ctx, "turnstile.open", device, slog.Int("attempt", attempt))
+		rwc, vals, err := c.policy.open()
+		if err == nil && c.opts.sessionToken {
+			rwc, err = c.exchangeTokens(ctx, rwc, vals)
+		}
+		if err == nil && c.onData {
+			rwc, err = c.waitData(ctx, rwc)
+		}
+		c.opts.health.opened(err)
+		if err != nil {
+			span.RecordError(err)
+			c.opts.journal.write(JournalEntry{Event: "open_error", Device: c.addr.String(), Attempt: attempt, Error: err.Error()})
+		}
+		if c.gate.isClosed() {
+			span.End()
+			if err == nil {
+				c.policy.closeRWC(rwc)
+			}
+			c.gate.release()
+			return nil, net.ErrClosed
+		}
+		if err == nil {
+			span.End()
+			if c.opts.writeQueue > 0 {
+				rwc = newWriteQueue(rwc, c.opts.writeQueue)
+			}
+			rc := &Conn{
+				rwc:             rwc,
+				vals:            vals,
+				serialWrites:    c.opts.serializeWrites,
+				readTimeout:     c.opts.readTimeout,
+				strictDeadlines: c.opts.strictDeadlines,
+				drain:           c.opts.drain,
+				drainTimeout:    c.opts.drainTimeout,
+				local:           localAddr(c.addr, rwc),
+				remote:          remote,
+				start:           time.Now(),
+			}
+			rc.onClose = func() {
+				c.policy.ended()
+				c.recordSession(rc)
+				if ended != nil {
+					ended()
+				}
+				c.gate.release()
+				if c.opts.eager {
+					go c.warmUp()
+				}
+			}
+			base := context.Background()
+			if c.opts.tracer != nil {
+				var sctx context.Context
+				sctx, rc.span = c.opts.startSpan(ctx, "turnstile.session", device)
+				base = context.WithoutCancel(sctx)
+			}
+			rc.initContext(base, c.opts.connContext)
+			rc.id = c.policy.started(rc)
+			if rc.span != nil {
+				rc.span.SetAttributes(slog.Uint64("session.id", rc.id))
+			}
+			c.opts.journal.write(JournalEntry{Event: "session_start", Device: c.addr.String(), Session: rc.id})
+			if w := c.opts.watchdog; w != nil {
+				rc.watched = true
+				rc.watch(w)
+			}
+			c.gate.setPreempt(func(r CloseReason) { rc.closeFor(r) })
+			return rc, nil
+		}
+		if c.opts.failFast && !c.policy.everOpened() {
+			span.End()
+			c.gate.release()
+			return nil, err
+		}
+		delay := c.opts.jitter.apply(backoff)
+		if c.opts.retry != nil {
+			retry, d := c.opts.retry(err)
+			if !retry {
+				span.End()
+				c.gate.release()
+				return nil, err
+			}
+			if d > 0 {
+				delay = d
+			}
+		}
+		span.SetAttributes(slog.Duration("backoff", delay))
+		span.End()
+
+		// Backoff, but wake up early if closed or cancelled.
+		select {
+		case <-ctx.Done():
+			c.gate.release()
+			return nil, ctx.Err()
+		case <-c.gate.done:
+			c.gate.release()
+			return nil, net.ErrClosed
+		case <-time.After(delay):
+		}
+		if backoff < 2*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// State is a snapshot of a listener or dialer, e.g. for a dashboard or
+// to tell a user the console is in use by another session.
+type State struct {
+	Active       bool          // a conn is currently handed out
+	Waiters      int           // Accept/Dial calls waiting their turn
+	LastOpenErr  error         // error from the latest open, nil if it succeeded
+	SessionCount uint64        // conns handed out so far
+	Uptime       time.Duration // time since the listener or dialer was created
+}
+
+func (c *core) state() State {
+	s := State{Waiters: c.gate.queueLength()}
+	c.policy.state(&s)
+	return s
+}
+
+// describe is String for a listener or dialer, e.g.
+// "listener /dev/ttyUSB0 (active, 2 waiting, 5 sessions)".
+func (c *core) describe(kind string) string {
+	s := c.state()
+	return fmt.Sprintf("%s %v (%s, %d waiting, %d sessions)", kind, c.addr, c.status(s), s.Waiters, s.SessionCount)
+}
+
+// status sums up s, the core's state, in a word: idle, active, paused,
+// or closed.
+func (c *core) status(s State) string {
+	switch {
+	case c.gate.isClosed():
+		return "closed"
+	case c.paused():
+		return "paused"
+	case s.Active:
+		return "active"
+	}
+	return "idle"
+}
+
+// exclusive waits for the slot, then opens the device and runs fn on
+// the raw RWC, closing it afterwards.
+func (c *core) exclusive(ctx context.Context, fn func(io.ReadWriteCloser) error) error {
+	if err := c.gate.acquire(ctx, 0); err != nil {
+		return err
+	}
+	defer c.gate.release()
+	c.policy.dropWarm()
+	if err := c.policy.waitReopen(ctx, c.gate.done); err != nil {
+		return err
+	}
+	rwc, err := c.policy.rawOpen()
+	if err != nil {
+		return err
+	}
+	defer c.policy.closeRWC(rwc)
+	return fn(rwc)
+}
+
+// Event reports a change in whether a listener or dialer is in use.
+type Event int
+
+const (
+	// EventBusy means a session or Exclusive call took the slot.
+	EventBusy Event = iota + 1
+
+	// EventFree means the slot was released with nobody waiting for
+	// it, so the device is idle.
+	EventFree
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventBusy:
+		return "busy"
+	case EventFree:
+		return "free"
+	}
+	return fmt.Sprintf("Event(%d)", int(e))
+}
+
+func (c *core) pause() {
+	c.pmu.Lock()
+	if c.resumeCh == nil {
+		c.resumeCh = make(chan struct{})
+	}
+	c.pmu.Unlock()
+	// Leave the device alone while paused.
+	c.policy.dropWarm()
+}
+
+func (c *core) resume() {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	if c.resumeCh != nil {
+		close(c.resumeCh)
+		c.resumeCh = nil
+		if c.opts.eager {
+			go c.warmUp()
+		}
+	}
+}
+
+func (c *core) paused() bool {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	return c.resumeCh != nil
+}
+
+// waitResume blocks while paused, or fails with ErrPaused if
+// configured to.
+func (c *core) waitResume(ctx context.Context) error {
+	for {
+		c.pmu.Lock()
+		ch := c.resumeCh
+		c.pmu.Unlock()
+		if ch == nil {
+			return nil
+		}
+		if c.opts.pauseErr {
+			return ErrPaused
+		}
+		select {
+		case <-ch:
+		case <-c.gate.done:
+			return net.ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *core) reconfigure(open OpenFunc, closeActive bool) {
+	c.policy.setOpen(open)
+	if closeActive {
+		c.gate.evict(ReasonShutdown)
+	}
+	if c.opts.eager {
+		go c.warmUp()
+	}
+}
+
+func (c *core) close() error {
+	c.gate.close()
+	c.policy.dropWarm()
+	return nil
+}
@@ -0,0 +1,69 @@
+package turnstile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrDeadlineNotSupported is returned by a conn's deadline methods,
+// under WithStrictDeadlines, when its RWC doesn't support deadlines.
+// It wraps errors.ErrUnsupported.
+var ErrDeadlineNotSupported = fmt.Errorf("turnstile: deadlines not supported: %w", errors.ErrUnsupported)
+
+// WithStrictDeadlines makes setting a deadline on a conn whose RWC
+// doesn't support them fail with ErrDeadlineNotSupported, instead of
+// silently doing nothing, so code that relies on deadlines finds out
+// when it is wired up. Clearing a deadline, by passing the zero time,
+// always succeeds.
+func WithStrictDeadlines() Option {
+	return func(o *options) {
+		o.strictDeadlines = true
+	}
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// SetDeadline sets both the read and write deadlines; see
+// SetReadDeadline and SetWriteDeadline.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return errors.Join(c.SetReadDeadline(t), c.SetWriteDeadline(t))
+}
+
+// SetReadDeadline sets the read deadline on the RWC, or one it unwraps
+// to, if it supports deadlines, as a net.Conn or a pollable *os.File
+// does. Otherwise it does nothing, or fails WithStrictDeadlines.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if d, ok := device[readDeadliner](c); ok {
+		return c.deadlineErr(t, d.SetReadDeadline(t))
+	}
+	return c.deadlineErr(t, ErrDeadlineNotSupported)
+}
+
+// SetWriteDeadline is like SetReadDeadline, for writes.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if d, ok := device[writeDeadliner](c); ok {
+		return c.deadlineErr(t, d.SetWriteDeadline(t))
+	}
+	return c.deadlineErr(t, ErrDeadlineNotSupported)
+}
+
+// deadlineErr decides what setting a deadline of t reports, given the
+// RWC's answer. An *os.File for a descriptor that can't take deadlines
+// is treated like any other RWC without them.
+func (c *Conn) deadlineErr(t time.Time, err error) error {
+	if errors.Is(err, os.ErrNoDeadline) {
+		err = ErrDeadlineNotSupported
+	}
+	if err == ErrDeadlineNotSupported && (!c.strictDeadlines || t.IsZero()) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package turnstile
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+// Match is the verdict of a Matcher.
+type Match int
+
+const (
+	MatchMore Match = iota // can't tell yet; need more bytes
+	MatchYes
+	MatchNo
+)
+
+// Matcher inspects the first bytes of a session to decide whether it
+// belongs to a route.
+type Matcher func(prefix []byte) Match
+
+// MatchAny matches every session. Register it last as a fallback.
+func MatchAny() Matcher {
+	return func([]byte) Match { return MatchYes }
+}
+
+// MatchPrefix matches sessions that start with any of prefixes.
+func MatchPrefix(prefixes ...string) Matcher {
+	return func(b []byte) Match {
+		verdict := MatchNo
+		for _, p := range prefixes {
+			switch {
+			case bytes.HasPrefix(b, []byte(p)):
+				return MatchYes
+			case bytes.HasPrefix([]byte(p), b):
+				verdict = MatchMore
+			}
+		}
+		return verdict
+	}
+}
+
+// MatchHTTP matches sessions that start with an HTTP/1 request line or
+// the HTTP/2 connection preface.
+func MatchHTTP() Matcher {
+	return MatchPrefix("GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "OPTIONS ",
+		"PATCH ", "CONNECT ", "TRACE ", "PRI * HTTP/2.0")
+}
+
+// MatchSSH matches sessions that start with an SSH version banner.
+func MatchSSH() Matcher {
+	return MatchPrefix("SSH-")
+}
+
+// MatchTLS matches sessions that start with a TLS handshake record.
+func MatchTLS() Matcher {
+	return func(b []byte) Match {
+		switch {
+		case len(b) < 2:
+			if len(b) == 1 && b[0] != 0x16 {
+				return MatchNo
+			}
+			return MatchMore
+		case b[0] == 0x16 && b[1] == 0x03:
+			return MatchYes
+		}
+		return MatchNo
+	}
+}
+
+// DefaultPeekTimeout is how long a Demux waits for the first bytes of a
+// session before giving up on it.
+const DefaultPeekTimeout = 10 * time.Second
+
+// maxPeek is how many bytes a Demux reads before giving up on a session
+// that no matcher could decide on.
+const maxPeek = 4096
+
+// Demux shares one listener between several protocols. Each session
+// from the underlying listener is peeked at and routed to the first
+// listener returned by Match whose matchers accept it; the bytes peeked
+// at are still there for that listener's consumer to read. Sessions no
+// route wants are closed, which on a turnstile listener frees the slot
+// for the next session.
+//
+//	d := turnstile.NewDemux(l)
+//	httpL := d.Match(turnstile.MatchHTTP())
+//	other := d.Match(turnstile.MatchAny())
+//	go http.Serve(httpL, h)
+//	go serveConsole(other)
+//	d.Serve()
+type Demux struct {
+	l net.Listener
+
+	// PeekTimeout bounds how long to wait for a session's first bytes.
+	// Zero means DefaultPeekTimeout.
+	PeekTimeout time.Duration
+
+	mu     sync.Mutex
+	routes []*route
+	done   chan struct{}
+	err    error
+}
+
+// NewDemux returns a Demux for l. Register routes with Match, then run
+// Serve.
+func NewDemux(l net.Listener) *Demux {
+	return &Demux{l: l, done: make(chan struct{})}
+}
+
+// Match registers a route for sessions accepted by any of matchers and
+// returns the listener those sessions are delivered to. Routes are
+// tried in the order they were registered.
+func (d *Demux) Match(matchers ...Matcher) net.Listener {
+	r := &route{d: d, matchers: matchers, conns: make(chan net.Conn), closed: make(chan struct{})}
+	d.mu.Lock()
+	d.routes = append(d.routes, r)
+	d.mu.Unlock()
+	return r
+}
+
+// Serve accepts sessions from the underlying listener and routes them
+// until it fails, returning that error. Route listeners then return
+// the error from Accept as well.
+func (d *Demux) Serve() error {
+	for {
+		c, err := d.l.Accept()
+		if err != nil {
+			d.mu.Lock()
+			d.err = err
+			d.mu.Unlock()
+			close(d.done)
+			return err
+		}
+		d.dispatch(c)
+	}
+}
+
+// Close closes the underlying listener, which stops Serve.
+func (d *Demux) Close() error {
+	return d.l.Close()
+}
+
+func (d *Demux) dispatch(c net.Conn) {
+	timeout := d.PeekTimeout
+	if timeout <= 0 {
+		timeout = DefaultPeekTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	d.mu.Lock()
+	routes := append([]*route(nil), d.routes...)
+	d.mu.Unlock()
+
+	e := NewExpecter(c)
+	for {
+		r, decided := pick(routes, e.buf, len(e.buf) >= maxPeek)
+		if decided {
+			if r == nil || !r.deliver(e.Conn()) {
+				c.Close()
+			}
+			return
+		}
+		if err := e.fill(timer.C); err != nil {
+			c.Close()
+			return
+		}
+	}
+}
+
+// pick finds the route for a session starting with prefix. It reports
+// false if a route that takes precedence still needs more bytes, unless
+// final is set, in which case undecided matchers count as no match.
+func pick(routes []*route, prefix []byte, final bool) (*route, bool) {
+	for _, r := range routes {
+		verdict := MatchNo
+		for _, m := range r.matchers {
+			v := m(prefix)
+			if v == MatchYes {
+				verdict = MatchYes
+				break
+			}
+			if v == MatchMore {
+				verdict = MatchMore
+			}
+		}
+		switch {
+		case verdict == MatchYes:
+			return r, true
+		case verdict == MatchMore && !final:
+			return nil, false
+		}
+	}
+	return nil, true
+}
+
+// route is the listener for one set of matchers.
+type route struct {
+	d         *Demux
+	matchers  []Matcher
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// deliver hands c to the route's Accept, reporting false if the route
+// or the Demux was closed first.
+func (r *route) deliver(c net.Conn) bool {
+	select {
+	case r.conns <- c:
+		return true
+	case <-r.closed:
+	case <-r.d.done:
+	}
+	return false
+}
+
+func (r *route) Accept() (net.Conn, error) {
+	select {
+	case c := <-r.conns:
+		return c, nil
+	case <-r.closed:
+		return nil, net.ErrClosed
+	case <-r.d.done:
+		r.d.mu.Lock()
+		defer r.d.mu.Unlock()
+		return nil, r.d.err
+	}
+}
+
+// Close stops the route from accepting sessions; sessions that match
+// it are closed from then on. The Demux keeps running.
+func (r *route) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	return nil
+}
+
+func (r *route) Addr() net.Addr { return r.d.l.Addr() }
@@ -0,0 +1,89 @@
+package turnstile
+
+import (
+	"context"
+	"io"
+)
+
+// WithEagerOpen opens the device as soon as the listener or dialer is
+// created, and again as soon as each session has ended, so the next
+// Accept or Dial finds it open and doesn't wait for the open; this
+// matters for links such as modems where opening takes seconds. The
+// device is opened in the background, after any reopen delay, and not
+// while paused, once no more sessions may be handed out, or once
+// closed; while it is being opened, the listener or dialer counts as
+// busy (see Notify). If the early open fails, the next session opens
+// the device as usual. Exclusive and Reconfigure close a device opened
+// early before doing anything else.
+func WithEagerOpen() Option {
+	return func(o *options) {
+		o.eager = true
+	}
+}
+
+// warmRWC is a device opened ahead of the session that will use it.
+type warmRWC struct {
+	rwc  io.ReadWriteCloser
+	vals *values
+}
+
+// warmUp opens the device ahead of the next session, if it should. It
+// holds the slot while opening, so a session arriving meanwhile waits
+// for the device rather than opening it too.
+func (c *core) warmUp() {
+	if err := c.policy.waitReopen(context.Background(), c.gate.done); err != nil {
+		return
+	}
+	if c.paused() || c.policy.exhausted() || !c.gate.tryAcquire() {
+		return
+	}
+	c.policy.prewarm()
+	if c.gate.isClosed() {
+		c.policy.dropWarm()
+	}
+	c.gate.release()
+}
+
+// prewarm opens the device and keeps it for the next open, unless
+// that has been done already.
+func (p *sessionPolicy) prewarm() {
+	p.mu.Lock()
+	if p.warming != nil || p.warm != nil {
+		p.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	p.warming = ch
+	p.mu.Unlock()
+
+	rwc, vals, err := p.tryOpen()
+	p.mu.Lock()
+	if err == nil {
+		p.warm = &warmRWC{rwc, vals}
+	}
+	p.warming = nil
+	p.mu.Unlock()
+	close(ch)
+}
+
+// takeWarm returns the device opened by prewarm, if any, waiting for
+// one in progress.
+func (p *sessionPolicy) takeWarm() *warmRWC {
+	for {
+		p.mu.Lock()
+		ch, w := p.warming, p.warm
+		p.warm = nil
+		p.mu.Unlock()
+		if ch == nil {
+			return w
+		}
+		<-ch
+	}
+}
+
+// dropWarm closes the device opened by prewarm, if any.
+func (p *sessionPolicy) dropWarm() {
+	if w := p.takeWarm(); w != nil {
+		p.closeRWC(w.rwc)
+	}
+}
@@ -0,0 +1,108 @@
+package turnstile
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// EchoCanceler wraps an RWC whose transmitted bytes come back on the
+// receive side, as on half-duplex RS-485 adapters, and strips that
+// echo from what Read returns. Written bytes are expected back, in
+// order, within a window after the Write finishes; bytes that don't
+// come back in time are forgotten, and so is the rest of the expected
+// echo when an unexpected byte turns up instead, so real input is
+// never swallowed for long. Unlike LineDiscipline's StripEcho, it works
+// on the RWC, below any framing, and suits binary protocols.
+type EchoCanceler struct {
+	rwc    io.ReadWriteCloser
+	window time.Duration
+
+	wmu sync.Mutex // one Write at a time, so runs are in wire order
+
+	mu      sync.Mutex
+	pending []*echoRun
+}
+
+// echoRun is one Write's worth of expected echo.
+type echoRun struct {
+	b        []byte
+	deadline time.Time // zero while the Write is still in progress
+}
+
+// NewEchoCanceler returns rwc with its echo stripped, expecting each
+// write back within window.
+func NewEchoCanceler(rwc io.ReadWriteCloser, window time.Duration) *EchoCanceler {
+	return &EchoCanceler{rwc: rwc, window: window}
+}
+
+// EchoCancel returns an OpenFunc that wraps every RWC opened by open
+// in an EchoCanceler.
+func EchoCancel(open OpenFunc, window time.Duration) OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		rwc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return NewEchoCanceler(rwc, window), nil
+	}
+}
+
+func (e *EchoCanceler) Read(p []byte) (int, error) {
+	for {
+		n, err := e.rwc.Read(p)
+		n = e.strip(p[:n])
+		if n > 0 || err != nil || len(p) == 0 {
+			return n, err
+		}
+		// All echo; read again rather than return nothing.
+	}
+}
+
+// strip removes the expected echo from b in place, returning how many
+// bytes are left.
+func (e *EchoCanceler) strip(b []byte) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	out := b[:0]
+	for _, ch := range b {
+		for len(e.pending) > 0 {
+			r := e.pending[0]
+			if len(r.b) > 0 && (r.deadline.IsZero() || now.Before(r.deadline)) {
+				break
+			}
+			e.pending = e.pending[1:]
+		}
+		if len(e.pending) > 0 && e.pending[0].b[0] == ch {
+			e.pending[0].b = e.pending[0].b[1:]
+			continue
+		}
+		// Not our echo: the line isn't echoing what we expected.
+		e.pending = nil
+		out = append(out, ch)
+	}
+	return len(out)
+}
+
+func (e *EchoCanceler) Write(p []byte) (int, error) {
+	e.wmu.Lock()
+	defer e.wmu.Unlock()
+	// The echo may come back before Write returns, so expect it first.
+	run := &echoRun{b: append([]byte(nil), p...)}
+	e.mu.Lock()
+	e.pending = append(e.pending, run)
+	e.mu.Unlock()
+
+	n, err := e.rwc.Write(p)
+
+	e.mu.Lock()
+	run.deadline = time.Now().Add(e.window)
+	e.mu.Unlock()
+	return n, err
+}
+
+func (e *EchoCanceler) Close() error { return e.rwc.Close() }
+
+// Unwrap returns the underlying RWC.
+func (e *EchoCanceler) Unwrap() io.ReadWriteCloser { return e.rwc }
@@ -0,0 +1,259 @@
+package turnstile
+
+import (
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"time"
+)
+
+// ErrExpectTimeout is returned by Expecter when no match was seen in time.
+var ErrExpectTimeout = errors.New("turnstile: timed out waiting for expected input")
+
+// Expecter scripts an interactive session, such as a device login or
+// boot prompt, before handing it over to other code.
+//
+//	e := turnstile.NewExpecter(conn)
+//	e.ExpectString("login: ", 5*time.Second)
+//	e.Send("root\n")
+//	e.ExpectString("# ", 5*time.Second)
+//	handle(e.Conn())
+//
+// Since the underlying stream may not support deadlines, a read can
+// still be outstanding after an Expect times out; its result is kept
+// for the next call rather than lost. Input read past the last match
+// is likewise kept and returned by Read, so once scripting is done
+// keep reading through the Expecter (or the net.Conn from Conn, or the
+// RWC from ReadWriteCloser), or hand the input back with Release.
+type Expecter struct {
+	rw      io.ReadWriter
+	src     io.Reader // rw, or the stream behind it once its put back input is taken over
+	buf     []byte
+	results <-chan readResult
+	pending bool
+}
+
+// NewExpecter returns an Expecter reading from and writing to rw. If
+// rw holds input handed back by an earlier Expecter's Release, the new
+// one takes it over.
+func NewExpecter(rw io.ReadWriter) *Expecter {
+	e := &Expecter{rw: rw, src: rw}
+	if s, ok := rw.(pushbacker); ok {
+		var pb putBack
+		pb, e.src = s.reclaim()
+		e.buf = pb.b
+		switch {
+		case pb.pending != nil:
+			e.results, e.pending = pb.pending, true
+		case pb.err != nil:
+			ch := make(chan readResult, 1)
+			ch <- readResult{nil, pb.err}
+			e.results, e.pending = ch, true
+		}
+	}
+	return e
+}
+
+// Release ends scripting, handing the input read past the last match,
+// and the read left outstanding by a timed out Expect, if any, back to
+// the stream, so that its next Read returns them. A *Conn takes input
+// back, as do the streams turnstile hands to health checks and
+// AutoBaud probes; on others, Release does nothing, and the input is
+// only returned by reading through the Expecter.
+// The Expecter must not be used for reading afterwards.
+func (e *Expecter) Release() {
+	s, ok := e.rw.(pushbacker)
+	if !ok {
+		return
+	}
+	pb := putBack{b: e.buf}
+	if e.pending {
+		pb.pending = e.results
+	}
+	e.buf, e.results, e.pending = nil, nil, false
+	s.unread(pb)
+}
+
+// Send writes s.
+func (e *Expecter) Send(s string) error {
+	_, err := io.WriteString(e.rw, s)
+	return err
+}
+
+// ExpectString waits up to timeout for s to appear in the input. It
+// returns everything up to and including s.
+func (e *Expecter) ExpectString(s string, timeout time.Duration) (string, error) {
+	_, m, err := e.ExpectAny(timeout, regexp.MustCompile(regexp.QuoteMeta(s)))
+	if len(m) == 0 {
+		return "", err
+	}
+	return m[0], err
+}
+
+// ExpectRegex waits up to timeout for re to match the input. It
+// returns the match followed by its submatches, as
+// regexp.FindStringSubmatch does.
+func (e *Expecter) ExpectRegex(re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	_, m, err := e.ExpectAny(timeout, re)
+	return m, err
+}
+
+// ExpectAny waits up to timeout for any of res to match, returning the
+// index of the one whose match starts earliest in the input (the first
+// listed, on a tie) and its submatches. Input up to the end of the
+// match is consumed. On error, the unconsumed input is returned as the
+// only element of the slice, for diagnostics.
+func (e *Expecter) ExpectAny(timeout time.Duration, res ...*regexp.Regexp) (int, []string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		best, bestLoc := -1, []int(nil)
+		for i, re := range res {
+			if loc := re.FindSubmatchIndex(e.buf); loc != nil && (bestLoc == nil || loc[0] < bestLoc[0]) {
+				best, bestLoc = i, loc
+			}
+		}
+		if loc := bestLoc; loc != nil {
+			m := make([]string, len(loc)/2)
+			for j := range m {
+				if loc[2*j] >= 0 {
+					m[j] = string(e.buf[loc[2*j]:loc[2*j+1]])
+				}
+			}
+			m[0] = string(e.buf[:loc[1]])
+			e.buf = e.buf[loc[1]:]
+			return best, m, nil
+		}
+		if err := e.fill(timer.C); err != nil {
+			return -1, []string{string(e.buf)}, err
+		}
+	}
+}
+
+// fill appends the next chunk of input to e.buf, giving up with
+// ErrExpectTimeout when timeout fires.
+func (e *Expecter) fill(timeout <-chan time.Time) error {
+	e.startRead()
+	select {
+	case res := <-e.results:
+		e.results, e.pending = nil, false
+		e.buf = append(e.buf, res.b...)
+		if res.err != nil && len(res.b) == 0 {
+			return res.err
+		}
+		return nil
+	case <-timeout:
+		return ErrExpectTimeout
+	}
+}
+
+// startRead issues a read on the underlying stream unless one is
+// already outstanding.
+func (e *Expecter) startRead() {
+	if e.pending {
+		return
+	}
+	ch := make(chan readResult, 1)
+	e.results, e.pending = ch, true
+	go func() {
+		b := make([]byte, 512)
+		n, err := e.src.Read(b)
+		ch <- readResult{b[:n], err}
+	}()
+}
+
+// Read returns buffered input first, then the result of any read left
+// outstanding by a timed out Expect, then reads from the stream.
+func (e *Expecter) Read(p []byte) (int, error) {
+	if len(e.buf) == 0 && e.pending {
+		res := <-e.results
+		e.results, e.pending = nil, false
+		e.buf = append(e.buf, res.b...)
+		if len(e.buf) == 0 {
+			return 0, res.err
+		}
+	}
+	if len(e.buf) > 0 {
+		n := copy(p, e.buf)
+		e.buf = e.buf[n:]
+		return n, nil
+	}
+	return e.src.Read(p)
+}
+
+// Write writes p to the stream.
+func (e *Expecter) Write(p []byte) (int, error) {
+	return e.rw.Write(p)
+}
+
+// Conn returns a net.Conn that reads through e, so no input seen while
+// scripting is lost. It panics if the Expecter wasn't created over a
+// net.Conn.
+func (e *Expecter) Conn() net.Conn {
+	return &expectConn{Conn: e.rw.(net.Conn), e: e}
+}
+
+type expectConn struct {
+	net.Conn
+	e *Expecter
+}
+
+func (c *expectConn) Read(p []byte) (int, error) { return c.e.Read(p) }
+
+// ReadWriteCloser returns an RWC that reads through e, so no input seen
+// while scripting is lost. It panics if the Expecter wasn't created
+// over an io.ReadWriteCloser.
+func (e *Expecter) ReadWriteCloser() io.ReadWriteCloser {
+	return &expectRWC{ReadWriteCloser: e.rw.(io.ReadWriteCloser), e: e}
+}
+
+type expectRWC struct {
+	io.ReadWriteCloser
+	e *Expecter
+}
+
+func (r *expectRWC) Read(p []byte) (int, error) { return r.e.Read(p) }
+
+// Unwrap returns the underlying RWC.
+func (r *expectRWC) Unwrap() io.ReadWriteCloser { return r.ReadWriteCloser }
+
+// putBack is input handed back to a stream by Expecter.Release: b,
+// then the outcome of the read pending, if any, and then err.
+type putBack struct {
+	b       []byte
+	pending <-chan readResult
+	err     error
+}
+
+func (pb *putBack) empty() bool {
+	return len(pb.b) == 0 && pb.pending == nil && pb.err == nil
+}
+
+// read returns the next of what was put back. pb must not be empty.
+func (pb *putBack) read(p []byte) (int, error) {
+	if len(pb.b) == 0 && pb.pending != nil {
+		r := <-pb.pending
+		pb.b, pb.err, pb.pending = r.b, r.err, nil
+	}
+	if len(pb.b) > 0 {
+		n := copy(p, pb.b)
+		pb.b = pb.b[n:]
+		return n, nil
+	}
+	err := pb.err
+	pb.err = nil
+	return 0, err
+}
+
+// pushbacker is implemented by streams that can take back input read
+// from them; see Expecter.Release.
+type pushbacker interface {
+	// unread puts pb in front of the rest of the stream. Whatever was
+	// put back before must have been reclaimed.
+	unread(pb putBack)
+
+	// reclaim takes back what was put back, and returns the stream
+	// that the rest is read from.
+	reclaim() (putBack, io.Reader)
+}
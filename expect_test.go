@@ -0,0 +1,39 @@
+package turnstile
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExpectAnyEarliest feeds two matches in one read: the pattern
+// matching earlier in the input wins, whatever its place in the list.
+func TestExpectAnyEarliest(t *testing.T) {
+	ok, fail := regexp.MustCompile(`OK\r\n`), regexp.MustCompile(`ERROR\r\n`)
+	for _, tc := range []struct {
+		in   string
+		res  []*regexp.Regexp
+		want int
+		m    string
+	}{
+		{"\r\nERROR\r\n\r\nOK\r\n", []*regexp.Regexp{ok, fail}, 1, "\r\nERROR\r\n"},
+		{"\r\nERROR\r\n\r\nOK\r\n", []*regexp.Regexp{fail, ok}, 0, "\r\nERROR\r\n"},
+		{"\r\nOK\r\nERROR\r\n", []*regexp.Regexp{fail, ok}, 1, "\r\nOK\r\n"},
+		// On a tie, the first listed.
+		{"OK\r\n", []*regexp.Regexp{regexp.MustCompile(`OK`), ok}, 0, "OK"},
+	} {
+		e := NewExpecter(struct {
+			io.Reader
+			io.Writer
+		}{strings.NewReader(tc.in), io.Discard})
+		i, m, err := e.ExpectAny(time.Second, tc.res...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i != tc.want || m[0] != tc.m {
+			t.Fatalf("%q: matched %d with %q, want %d with %q", tc.in, i, m[0], tc.want, tc.m)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package turnstile
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the error returned by a FaultyRW's injected write
+// errors.
+var ErrInjected = errors.New("turnstile: injected fault")
+
+// Faults sets how often a FaultyRW misbehaves. Each probability, from 0
+// to 1, applies independently to every Read or Write call.
+type Faults struct {
+	ShortRead  float64 // a Read returns only part of what it could
+	WriteError float64 // a Write fails with ErrInjected
+	Delay      float64 // a call is delayed by up to MaxDelay
+	BitFlip    float64 // one bit of the data read or written is flipped
+	EOF        float64 // the stream ends: Reads return io.EOF from then on
+
+	MaxDelay time.Duration
+
+	// Seed seeds the random source, so a run can be reproduced.
+	Seed uint64
+}
+
+// FaultyRW wraps an RWC and injects faults into its traffic, for
+// testing how a protocol stack copes with a flaky serial link.
+type FaultyRW struct {
+	rwc io.ReadWriteCloser
+	f   Faults
+
+	mu  sync.Mutex
+	rng *rand.Rand
+	eof bool
+}
+
+// NewFaultyRW returns rwc with the faults in f injected.
+func NewFaultyRW(rwc io.ReadWriteCloser, f Faults) *FaultyRW {
+	return &FaultyRW{rwc: rwc, f: f, rng: rand.New(rand.NewPCG(f.Seed, 0))}
+}
+
+// FaultyOpen returns an OpenFunc that wraps every RWC opened by open in
+// a FaultyRW. Each open seeds its FaultyRW differently but
+// deterministically, so a sequence of sessions can be reproduced too.
+func FaultyOpen(open OpenFunc, f Faults) OpenFunc {
+	var mu sync.Mutex
+	var n uint64
+	return func() (io.ReadWriteCloser, error) {
+		rwc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		g := f
+		g.Seed = f.Seed + n
+		n++
+		mu.Unlock()
+		return NewFaultyRW(rwc, g), nil
+	}
+}
+
+// roll reports whether an event of probability p happens.
+func (r *FaultyRW) roll(p float64) bool {
+	return p > 0 && r.rng.Float64() < p
+}
+
+// plan decides the faults for the next call, holding mu only briefly
+// so concurrent Reads and Writes don't block each other.
+func (r *FaultyRW) plan() (delay time.Duration, short, flip, fail, eof bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.roll(r.f.Delay) && r.f.MaxDelay > 0 {
+		delay = time.Duration(r.rng.Int64N(int64(r.f.MaxDelay)))
+	}
+	short = r.roll(r.f.ShortRead)
+	flip = r.roll(r.f.BitFlip)
+	fail = r.roll(r.f.WriteError)
+	if r.roll(r.f.EOF) {
+		r.eof = true
+	}
+	return delay, short, flip, fail, r.eof
+}
+
+func (r *FaultyRW) intN(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.IntN(n)
+}
+
+func (r *FaultyRW) Read(p []byte) (int, error) {
+	delay, short, flip, _, eof := r.plan()
+	time.Sleep(delay)
+	if eof {
+		return 0, io.EOF
+	}
+	if short && len(p) > 1 {
+		p = p[:1+r.intN(len(p)-1)]
+	}
+	n, err := r.rwc.Read(p)
+	if flip && n > 0 {
+		r.flip(p[:n])
+	}
+	return n, err
+}
+
+func (r *FaultyRW) Write(p []byte) (int, error) {
+	delay, _, flip, fail, eof := r.plan()
+	time.Sleep(delay)
+	if eof {
+		return 0, io.ErrClosedPipe
+	}
+	if fail {
+		return 0, ErrInjected
+	}
+	if flip && len(p) > 0 {
+		p = append([]byte(nil), p...)
+		r.flip(p)
+	}
+	return r.rwc.Write(p)
+}
+
+func (r *FaultyRW) flip(p []byte) {
+	bit := r.intN(8 * len(p))
+	p[bit/8] ^= 1 << (bit % 8)
+}
+
+// Close closes the underlying RWC.
+func (r *FaultyRW) Close() error { return r.rwc.Close() }
+
+// Unwrap returns the underlying RWC.
+func (r *FaultyRW) Unwrap() io.ReadWriteCloser { return r.rwc }
@@ -0,0 +1,33 @@
+package turnstile
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// waitFD blocks until fd is ready for reading, or writing if write is
+// set, or reports an error or hang-up, which the next attempt at the
+// I/O will then see. It returns nil after timeout even if fd isn't
+// ready, so the caller can notice the descriptor was closed meanwhile.
+func waitFD(fd uintptr, write bool, timeout time.Duration) error {
+	pfd := struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{fd: int32(fd), events: 0x1} // POLLIN
+	if write {
+		pfd.events = 0x4 // POLLOUT
+	}
+	ts := syscall.NsecToTimespec(int64(timeout))
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			continue
+		}
+		return errno
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package turnstile
+
+import (
+	"errors"
+	"time"
+)
+
+// waitFD is only implemented on Linux; elsewhere a descriptor that
+// isn't ready can't be waited on without spinning.
+func waitFD(fd uintptr, write bool, timeout time.Duration) error {
+	return errors.ErrUnsupported
+}
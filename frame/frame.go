@@ -0,0 +1,570 @@
+// Package frame carries discrete messages over a byte stream, such as
+// a turnstile session on a serial link, with an optional checksum on
+// each one so corruption is caught without a full ARQ layer.
+//
+//	c := frame.NewConn(conn, &frame.Options{Checksum: frame.CRC32, NAK: true})
+//	err := c.WriteMessage([]byte("hello"))
+//	...
+//	msg, err := c.ReadMessage()
+//
+// Each frame starts with an 8-byte header: the sync bytes 0xF5 0x7E, a
+// kind byte, the payload length as a big-endian uint32, and a CRC-8 of
+// the kind and length. The payload follows, then the checksum, if any,
+// over the header and payload.
+//
+// A Reader resynchronizes after line noise or lost bytes by scanning
+// for the next header that checks out, counting each time it has to in
+// ReaderStats.Resyncs. Garbage between frames costs nothing. Garbage
+// inside a frame costs that frame and, without a checksum, possibly the
+// frames that the damaged frame's length runs into. With a checksum,
+// a frame that fails it is skipped only past its sync bytes, as is a
+// header claiming more than MaxSize, which can't be checked without
+// reading that far; so the Reader never skips an intact frame, even
+// when the garbage holds a bogus header that checks out, and picks up
+// the first intact frame after the garbage.
+package frame
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Frame kinds.
+const (
+	kindData = 0x01
+	kindNAK  = 0x15
+)
+
+const headerLen = 8
+
+// syncBytes start every frame.
+const syncBytes = "\xf5\x7e"
+
+// maxChunk bounds the messages ReadFrom builds.
+const maxChunk = 32 << 10
+
+var (
+	// ErrChecksum is returned by ReadMessage for a message whose
+	// checksum doesn't match. The message is discarded.
+	ErrChecksum = errors.New("frame: checksum mismatch")
+
+	// ErrNAK is returned by ReadMessage when the peer reports that a
+	// message it received from us was corrupt (see Options.NAK).
+	// Resending is up to the caller.
+	ErrNAK = errors.New("frame: peer rejected a corrupt message")
+
+	// ErrTooLarge is returned for a message longer than
+	// Options.MaxSize: by WriteMessage, which doesn't send it, and by
+	// ReadMessage with OverflowError, which discards it.
+	ErrTooLarge = errors.New("frame: message too large")
+)
+
+// Checksum selects the checksum trailing each frame.
+type Checksum int
+
+const (
+	// None adds no checksum.
+	None Checksum = iota
+
+	// CRC16 adds a CRC-16/CCITT-FALSE, two bytes.
+	CRC16
+
+	// CRC32 adds a CRC-32 (IEEE), four bytes.
+	CRC32
+)
+
+func (c Checksum) size() int {
+	switch c {
+	case CRC16:
+		return 2
+	case CRC32:
+		return 4
+	}
+	return 0
+}
+
+// newHash returns a hash computing the checksum, or nil for None.
+func (c Checksum) newHash() hash.Hash {
+	switch c {
+	case CRC16:
+		return new(crc16Hash)
+	case CRC32:
+		return crc32.NewIEEE()
+	}
+	return nil
+}
+
+func (c Checksum) String() string {
+	switch c {
+	case None:
+		return "none"
+	case CRC16:
+		return "CRC16"
+	case CRC32:
+		return "CRC32"
+	}
+	return fmt.Sprintf("Checksum(%d)", int(c))
+}
+
+// crc16Hash is a hash.Hash computing the CRC-16/CCITT-FALSE.
+type crc16Hash struct {
+	c    uint16
+	init bool
+}
+
+func (h *crc16Hash) Write(b []byte) (int, error) {
+	if !h.init {
+		h.c, h.init = 0xffff, true
+	}
+	for _, x := range b {
+		h.c ^= uint16(x) << 8
+		for range 8 {
+			if h.c&0x8000 != 0 {
+				h.c = h.c<<1 ^ 0x1021
+			} else {
+				h.c <<= 1
+			}
+		}
+	}
+	return len(b), nil
+}
+
+func (h *crc16Hash) Sum(b []byte) []byte {
+	if !h.init {
+		return binary.BigEndian.AppendUint16(b, 0xffff)
+	}
+	return binary.BigEndian.AppendUint16(b, h.c)
+}
+
+func (h *crc16Hash) Reset()         { *h = crc16Hash{} }
+func (h *crc16Hash) Size() int      { return 2 }
+func (h *crc16Hash) BlockSize() int { return 1 }
+
+// Overflow says what a Reader does with a message longer than
+// Options.MaxSize. Without a checksum, the rest of an oversized message
+// is read and discarded either way, so the stream stays in step. With
+// one, an oversized header is as likely to be line noise, and reading
+// through gigabytes on its say-so could swallow intact frames behind
+// it, so the Reader resynchronizes from just past its sync bytes
+// instead; OverflowTruncate then drops the message too, since its
+// checksum can't be checked.
+type Overflow int
+
+const (
+	// OverflowError makes ReadMessage return ErrTooLarge.
+	OverflowError Overflow = iota
+
+	// OverflowDrop skips the message and reads the next one.
+	OverflowDrop
+
+	// OverflowTruncate returns the first MaxSize bytes of the message.
+	OverflowTruncate
+)
+
+// DefaultMaxSize is the MaxSize used if Options doesn't set one.
+const DefaultMaxSize = 64 << 10
+
+// Options configures framing. Both ends must agree on the Checksum. A
+// nil *Options uses the defaults: no checksum, DefaultMaxSize, and
+// OverflowError.
+type Options struct {
+	Checksum Checksum
+
+	// NAK makes a Conn tell the peer when a message from it fails its
+	// checksum, so the peer's ReadMessage returns ErrNAK.
+	NAK bool
+
+	// MaxSize is the longest message, in bytes, that is written or
+	// read whole; Overflow says what happens to longer ones read. It
+	// keeps a length corrupted by line noise from allocating
+	// gigabytes. Zero means DefaultMaxSize.
+	MaxSize  int
+	Overflow Overflow
+}
+
+func (o *Options) get() Options {
+	var g Options
+	if o != nil {
+		g = *o
+	}
+	if g.MaxSize <= 0 {
+		g.MaxSize = DefaultMaxSize
+	}
+	return g
+}
+
+// Writer writes framed messages. It is safe for concurrent use.
+type Writer struct {
+	w  io.Writer
+	o  Options
+	mu sync.Mutex
+	b  []byte    // the frame being built
+	h  hash.Hash // nil without a checksum
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer, o *Options) *Writer {
+	g := o.get()
+	return &Writer{w: w, o: g, h: g.Checksum.newHash()}
+}
+
+// WriteMessage writes p as one message, with a single Write.
+func (w *Writer) WriteMessage(p []byte) error {
+	if len(p) > w.o.MaxSize {
+		return ErrTooLarge
+	}
+	return w.write(kindData, p)
+}
+
+// Write writes p as one message, or as several of up to MaxSize bytes
+// if it is longer, so a Writer can stand in for an io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	var n int
+	for {
+		chunk := p[:min(len(p), w.o.MaxSize)]
+		if err := w.WriteMessage(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+		if len(p) == 0 {
+			return n, nil
+		}
+	}
+}
+
+// ReadFrom implements io.ReaderFrom, sending what it reads from r until
+// EOF as messages of up to MaxSize bytes, one per Read. Each is read
+// straight into the frame being built, without an intermediate copy,
+// so io.Copy to a Writer doesn't allocate.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		n, err := w.writeFrom(r)
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// writeFrom does one Read from r into a frame and writes the frame, if
+// the Read returned anything.
+func (w *Writer) writeFrom(r io.Reader) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	size := headerLen + min(w.o.MaxSize, maxChunk) + w.o.Checksum.size()
+	if cap(w.b) < size {
+		w.b = make([]byte, 0, size)
+	}
+	b := w.b[:size]
+	n, rerr := r.Read(b[headerLen : size-w.o.Checksum.size()])
+	if n == 0 {
+		return 0, rerr
+	}
+	putHeader(b, kindData, n)
+	b = b[:headerLen+n]
+	b = w.sum(b)
+	if _, err := w.w.Write(b); err != nil {
+		return 0, err
+	}
+	return n, rerr
+}
+
+// WriteNAK tells the peer a message from it was corrupt.
+func (w *Writer) WriteNAK() error {
+	return w.write(kindNAK, nil)
+}
+
+func (w *Writer) write(kind byte, p []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := slices.Grow(w.b[:0], headerLen+len(p)+w.o.Checksum.size())[:headerLen]
+	putHeader(b, kind, len(p))
+	b = append(b, p...)
+	b = w.sum(b)
+	w.b = b
+	_, err := w.w.Write(b)
+	return err
+}
+
+// putHeader fills in the frame header at the start of b.
+func putHeader(b []byte, kind byte, n int) {
+	copy(b, syncBytes)
+	b[2] = kind
+	binary.BigEndian.PutUint32(b[3:], uint32(n))
+	b[7] = crc8(b[2:7])
+}
+
+// checkHeader reports whether b starts with a valid frame header.
+func checkHeader(b []byte) bool {
+	return string(b[:2]) == syncBytes &&
+		(b[2] == kindData || b[2] == kindNAK) &&
+		b[7] == crc8(b[2:7])
+}
+
+// crc8 is the CRC-8 (polynomial 0x07) of b.
+func crc8(b []byte) byte {
+	var c byte
+	for _, x := range b {
+		c ^= x
+		for range 8 {
+			if c&0x80 != 0 {
+				c = c<<1 ^ 0x07
+			} else {
+				c <<= 1
+			}
+		}
+	}
+	return c
+}
+
+// sum appends the checksum of b, if any, to b.
+func (w *Writer) sum(b []byte) []byte {
+	if w.h == nil {
+		return b
+	}
+	w.h.Reset()
+	w.h.Write(b)
+	return w.h.Sum(b)
+}
+
+// Reader reads framed messages.
+type Reader struct {
+	r   *bufio.Reader
+	o   Options
+	nak *Writer   // where to send NAKs, if anywhere
+	h   hash.Hash // nil without a checksum
+
+	// Scratch space for readFrame, here so it doesn't escape per call.
+	hdr [headerLen]byte
+	sum [8]byte
+
+	messages  atomic.Uint64
+	dropped   atomic.Uint64
+	truncated atomic.Uint64
+	corrupt   atomic.Uint64
+	resyncs   atomic.Uint64
+	skipped   atomic.Uint64
+}
+
+// ReaderStats counts what a Reader has read.
+type ReaderStats struct {
+	Messages  uint64 // messages returned, including truncated ones
+	Dropped   uint64 // oversized messages discarded
+	Truncated uint64 // oversized messages returned truncated
+	Corrupt   uint64 // messages that failed their checksum
+	Resyncs   uint64 // times bytes had to be skipped to find a frame
+	Skipped   uint64 // bytes skipped while resynchronizing
+}
+
+// NewReader returns a Reader reading from r. It buffers up to a whole
+// frame, MaxSize and a few bytes.
+func NewReader(r io.Reader, o *Options) *Reader {
+	g := o.get()
+	size := max(4096, headerLen+g.MaxSize+g.Checksum.size())
+	return &Reader{r: bufio.NewReaderSize(r, size), o: g, h: g.Checksum.newHash()}
+}
+
+// Stats returns the Reader's counters. It is safe to call while
+// another goroutine reads.
+func (r *Reader) Stats() ReaderStats {
+	return ReaderStats{
+		Messages:  r.messages.Load(),
+		Dropped:   r.dropped.Load(),
+		Truncated: r.truncated.Load(),
+		Corrupt:   r.corrupt.Load(),
+		Resyncs:   r.resyncs.Load(),
+		Skipped:   r.skipped.Load(),
+	}
+}
+
+// ReadMessage reads the next message. A message that fails its
+// checksum is discarded and reported as ErrChecksum, after which the
+// next message can be read. Messages longer than Options.MaxSize are
+// handled according to Options.Overflow.
+func (r *Reader) ReadMessage() ([]byte, error) {
+	return r.AppendMessage(nil)
+}
+
+// AppendMessage is like ReadMessage, but appends the message to b and
+// returns the extended slice, so a caller can reuse one buffer for
+// every message instead of allocating each. On error, b is returned
+// unchanged.
+func (r *Reader) AppendMessage(b []byte) ([]byte, error) {
+	for {
+		msg, err := r.readFrame(b)
+		switch err {
+		case nil:
+			return msg, nil
+		case errDropped:
+			continue
+		}
+		return b, err
+	}
+}
+
+// errDropped is returned by readFrame for a message dropped under
+// OverflowDrop.
+var errDropped = errors.New("frame: message dropped")
+
+func (r *Reader) readFrame(dst []byte) ([]byte, error) {
+	hdr, err := r.sync()
+	if err != nil {
+		return nil, err
+	}
+	kind := hdr[2]
+	n := int64(binary.BigEndian.Uint32(hdr[3:]))
+	if n > int64(r.o.MaxSize) {
+		if r.h != nil {
+			return nil, r.suspectOversized()
+		}
+		return r.readOversized(dst, hdr, n)
+	}
+
+	k := r.o.Checksum.size()
+	f, err := r.r.Peek(headerLen + int(n) + k)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	if h := r.h; h != nil {
+		h.Reset()
+		h.Write(f[:len(f)-k])
+		if !bytes.Equal(h.Sum(r.sum[:0]), f[len(f)-k:]) {
+			// The header may be bogus, so look for the next frame
+			// from just past its sync bytes.
+			r.r.Discard(len(syncBytes))
+			return nil, r.corruptFrame(kind)
+		}
+	}
+	if kind == kindNAK {
+		r.r.Discard(len(f))
+		return nil, ErrNAK
+	}
+	dst = append(dst, f[headerLen:len(f)-k]...)
+	r.r.Discard(len(f))
+	r.messages.Add(1)
+	return dst, nil
+}
+
+// sync skips to the next valid frame header and returns it, without
+// consuming it.
+func (r *Reader) sync() ([]byte, error) {
+	var skipped uint64
+	defer func() {
+		if skipped > 0 {
+			r.resyncs.Add(1)
+			r.skipped.Add(skipped)
+		}
+	}()
+	for {
+		hdr, err := r.r.Peek(headerLen)
+		if err != nil {
+			if len(hdr) > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if checkHeader(hdr) {
+			copy(r.hdr[:], hdr)
+			return r.hdr[:], nil
+		}
+		// Skip to the next byte that could start a frame.
+		i := bytes.IndexByte(hdr[1:], syncBytes[0]) + 1
+		if i == 0 {
+			i = headerLen
+		}
+		r.r.Discard(i)
+		skipped += uint64(i)
+	}
+}
+
+// corruptFrame counts a frame that failed its checksum and NAKs it if
+// configured to.
+func (r *Reader) corruptFrame(kind byte) error {
+	r.corrupt.Add(1)
+	if r.nak != nil && kind == kindData {
+		r.nak.WriteNAK()
+	}
+	return ErrChecksum
+}
+
+// suspectOversized drops a frame longer than MaxSize whose checksum
+// can't be checked, skipping only its sync bytes in case the header is
+// noise.
+func (r *Reader) suspectOversized() error {
+	r.r.Discard(len(syncBytes))
+	r.dropped.Add(1)
+	if r.o.Overflow == OverflowError {
+		return ErrTooLarge
+	}
+	return errDropped
+}
+
+// readOversized reads a frame longer than MaxSize, with no checksum,
+// streaming through what doesn't fit, and handles it as
+// Options.Overflow says.
+func (r *Reader) readOversized(dst, hdr []byte, n int64) ([]byte, error) {
+	r.r.Discard(headerLen)
+	keep := r.o.MaxSize
+	dst = slices.Grow(dst, keep)
+	b := dst[len(dst) : len(dst)+keep]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, noEOF(err)
+	}
+	// Read through the rest, so the next frame lines up.
+	if _, err := io.CopyN(io.Discard, r.r, n-int64(keep)); err != nil {
+		return nil, noEOF(err)
+	}
+	if hdr[2] == kindNAK {
+		return nil, ErrNAK
+	}
+	switch r.o.Overflow {
+	case OverflowDrop:
+		r.dropped.Add(1)
+		return nil, errDropped
+	case OverflowTruncate:
+		r.truncated.Add(1)
+	default:
+		r.dropped.Add(1)
+		return nil, ErrTooLarge
+	}
+	r.messages.Add(1)
+	return dst[:len(dst)+keep], nil
+}
+
+// noEOF turns an EOF in the middle of a frame into
+// io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Conn reads and writes framed messages on one stream.
+type Conn struct {
+	*Reader
+	*Writer
+}
+
+// NewConn returns a Conn framing messages on rw.
+func NewConn(rw io.ReadWriter, o *Options) *Conn {
+	c := &Conn{Reader: NewReader(rw, o), Writer: NewWriter(rw, o)}
+	if c.Reader.o.NAK {
+		c.Reader.nak = c.Writer
+	}
+	return c
+}
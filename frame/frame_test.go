@@ -0,0 +1,162 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// frameOf returns msg framed as a Writer with o would send it.
+func frameOf(t testing.TB, msg []byte, o *Options) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := NewWriter(&b, o).WriteMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// bogusHeader returns a header that checks out, claiming n bytes.
+func bogusHeader(n uint32) []byte {
+	b := make([]byte, headerLen)
+	putHeader(b, kindData, 0)
+	binary.BigEndian.PutUint32(b[3:], n)
+	b[7] = crc8(b[2:7])
+	return b
+}
+
+// FuzzReader checks that a Reader with a checksum recovers the first
+// intact frame after any garbage, including bogus headers.
+func FuzzReader(f *testing.F) {
+	f.Add([]byte{}, uint8(0))
+	f.Add([]byte("line noise"), uint8(1))
+	f.Add([]byte(syncBytes), uint8(0))
+	f.Add(bogusHeader(0xffffffff), uint8(0))
+	f.Add(bogusHeader(0xffffffff), uint8(1))
+	f.Add(bogusHeader(65), uint8(0))
+	f.Add(append(bogusHeader(10), "short"...), uint8(1))
+	f.Add(append(bogusHeader(20), bogusHeader(1<<20)...), uint8(0))
+	f.Fuzz(func(t *testing.T, garbage []byte, cs uint8) {
+		o := &Options{Checksum: CRC16 + Checksum(cs%2), MaxSize: 64}
+		want := []byte("the intact frame")
+		in := append(bytes.Clone(garbage), frameOf(t, want, o)...)
+		r := NewReader(bytes.NewReader(in), o)
+		for {
+			msg, err := r.ReadMessage()
+			if err == nil && bytes.Equal(msg, want) {
+				return
+			}
+			switch {
+			case err == nil,
+				errors.Is(err, ErrChecksum),
+				errors.Is(err, ErrTooLarge),
+				errors.Is(err, ErrNAK):
+				continue
+			}
+			t.Fatalf("intact frame not recovered after %q: %v", garbage, err)
+		}
+	})
+}
+
+// FuzzRoundTrip checks that what a Writer sends a Reader reads back.
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte{}, uint16(0), uint8(0))
+	f.Add([]byte("hello"), uint16(16), uint8(1))
+	f.Add([]byte(syncBytes+syncBytes), uint16(3), uint8(2))
+	f.Add(bytes.Repeat(bogusHeader(1), 8), uint16(5), uint8(0))
+	f.Fuzz(func(t *testing.T, data []byte, maxSize uint16, cs uint8) {
+		o := &Options{Checksum: Checksum(cs % 3), MaxSize: int(maxSize)}
+		var b bytes.Buffer
+		w := NewWriter(&b, o)
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(&b, o)
+		var got []byte
+		for {
+			var err error
+			got, err = r.AppendMessage(got)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("read %q, wrote %q", got, data)
+		}
+		if s := r.Stats(); s.Resyncs != 0 || s.Corrupt != 0 || s.Dropped != 0 {
+			t.Fatalf("clean stream read with %+v", s)
+		}
+	})
+}
+
+// loopReader reads b over and over.
+type loopReader struct {
+	b   []byte
+	off int
+}
+
+func (l *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, l.b[l.off:])
+	l.off = (l.off + n) % len(l.b)
+	return n, nil
+}
+
+func BenchmarkWriterReadFrom(b *testing.B) {
+	data := make([]byte, 1<<20)
+	for _, cs := range []Checksum{None, CRC32} {
+		b.Run(cs.String(), func(b *testing.B) {
+			w := NewWriter(io.Discard, &Options{Checksum: cs})
+			src := bytes.NewReader(data)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				src.Reset(data)
+				// Hide src's WriteTo, so the copy goes through
+				// Writer.ReadFrom.
+				if _, err := io.Copy(w, struct{ io.Reader }{src}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWriteMessage(b *testing.B) {
+	msg := make([]byte, 1<<10)
+	for _, cs := range []Checksum{None, CRC32} {
+		b.Run(cs.String(), func(b *testing.B) {
+			w := NewWriter(io.Discard, &Options{Checksum: cs})
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := w.WriteMessage(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReaderAppendMessage(b *testing.B) {
+	msg := make([]byte, 1<<10)
+	for _, cs := range []Checksum{None, CRC32} {
+		b.Run(cs.String(), func(b *testing.B) {
+			o := &Options{Checksum: cs}
+			r := NewReader(&loopReader{b: frameOf(b, msg, o)}, o)
+			var buf []byte
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			for b.Loop() {
+				var err error
+				if buf, err = r.AppendMessage(buf[:0]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package turnstile
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// gate hands out the single slot shared by a listener or dialer.
+// Callers that find the slot taken queue up and are granted it in
+// order of priority, and within a priority in the order they arrived;
+// the slot is passed directly from the releasing holder to the next
+// waiter, so a caller that keeps re-requesting the slot cannot jump
+// ahead of those already waiting.
+//
+// If preemption is enabled, a waiter with a higher priority than the
+// current holder causes the holder's preempt func to be called once
+// the grace period has elapsed.
+type gate struct {
+	preempt      bool
+	preemptGrace time.Duration
+
+	mu      sync.Mutex
+	closed  bool
+	busy    bool
+	waiters []*waiter
+	done    chan struct{} // closed by close()
+	freeCh  chan struct{} // non-nil while busy; closed when the slot frees up
+	notify  []chan<- Event
+
+	// State of the current holder; only meaningful while busy.
+	gen          uint64 // incremented on every grant
+	holderPrio   int
+	holderCancel func(CloseReason)
+	preemptTimer *time.Timer
+}
+
+type waiter struct {
+	ch   chan struct{}
+	prio int
+}
+
+func newGate() *gate {
+	return &gate{done: make(chan struct{})}
+}
+
+// acquire blocks until the caller holds the slot, ctx is cancelled,
+// or the gate is closed. Higher prio values are served first. Once the
+// gate is closed, every pending and later acquire fails with
+// net.ErrClosed, even one handed the slot just before.
+func (g *gate) acquire(ctx context.Context, prio int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return net.ErrClosed
+	}
+	if !g.busy && len(g.waiters) == 0 {
+		g.setBusyLocked(true)
+		g.grantLocked(prio)
+		g.mu.Unlock()
+		return nil
+	}
+	w := &waiter{ch: make(chan struct{}), prio: prio}
+	// Insert behind every waiter of equal or higher priority.
+	i := len(g.waiters)
+	for i > 0 && g.waiters[i-1].prio < prio {
+		i--
+	}
+	g.waiters = append(g.waiters, nil)
+	copy(g.waiters[i+1:], g.waiters[i:])
+	g.waiters[i] = w
+	g.schedulePreemptLocked()
+	g.mu.Unlock()
+
+	select {
+	case <-w.ch:
+		// The previous holder handed the slot to us. If the gate was
+		// closed meanwhile, pass it on rather than let the caller go
+		// ahead, so nobody is served after close.
+		if g.isClosed() {
+			g.release()
+			return net.ErrClosed
+		}
+		return nil
+	case <-g.done:
+		g.abandon(w)
+		return net.ErrClosed
+	case <-ctx.Done():
+		g.abandon(w)
+		return ctx.Err()
+	}
+}
+
+// tryAcquire takes the slot if it is free and nobody is waiting,
+// reporting whether it did.
+func (g *gate) tryAcquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed || g.busy || len(g.waiters) > 0 {
+		return false
+	}
+	g.setBusyLocked(true)
+	g.grantLocked(0)
+	return true
+}
+
+// abandon removes w from the wait queue. If w was already granted
+// the slot, the slot is released so the next waiter isn't stranded.
+func (g *gate) abandon(w *waiter) {
+	g.mu.Lock()
+	for i, x := range g.waiters {
+		if x == w {
+			g.waiters = append(g.waiters[:i], g.waiters[i+1:]...)
+			g.mu.Unlock()
+			return
+		}
+	}
+	g.mu.Unlock()
+	g.release()
+}
+
+// release gives up the slot, handing it to the first waiting caller
+// if there is one.
+func (g *gate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.clearHolderLocked()
+	if !g.closed && len(g.waiters) > 0 {
+		w := g.waiters[0]
+		g.waiters = g.waiters[1:]
+		g.grantLocked(w.prio)
+		close(w.ch)
+		return
+	}
+	g.setBusyLocked(false)
+}
+
+// setBusyLocked marks the slot taken or free, waking waitFree callers
+// and sending the change to the notify channels.
+func (g *gate) setBusyLocked(busy bool) {
+	if g.busy == busy {
+		return
+	}
+	g.busy = busy
+	ev := EventFree
+	if busy {
+		ev = EventBusy
+		g.freeCh = make(chan struct{})
+	} else {
+		close(g.freeCh)
+		g.freeCh = nil
+	}
+	for _, ch := range g.notify {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// waitFree blocks until the slot is free, ctx is cancelled, or the
+// gate is closed.
+func (g *gate) waitFree(ctx context.Context) error {
+	g.mu.Lock()
+	ch := g.freeCh
+	g.mu.Unlock()
+	if ch == nil {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-g.done:
+		return net.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addNotify and removeNotify manage the channels told about busy/free
+// changes.
+func (g *gate) addNotify(ch chan<- Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.notify = append(g.notify, ch)
+}
+
+func (g *gate) removeNotify(ch chan<- Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, x := range g.notify {
+		if x == ch {
+			g.notify = append(g.notify[:i], g.notify[i+1:]...)
+			return
+		}
+	}
+}
+
+func (g *gate) grantLocked(prio int) {
+	g.gen++
+	g.holderPrio = prio
+}
+
+func (g *gate) clearHolderLocked() {
+	g.holderCancel = nil
+	if g.preemptTimer != nil {
+		g.preemptTimer.Stop()
+		g.preemptTimer = nil
+	}
+}
+
+// setPreempt registers fn as the way to evict the current holder, used
+// by preemption and evict, which pass it the reason. It must only be
+// called by the holder.
+func (g *gate) setPreempt(fn func(CloseReason)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.holderCancel = fn
+	g.schedulePreemptLocked()
+}
+
+// evict calls the current holder's preempt func, if it registered one.
+func (g *gate) evict(r CloseReason) {
+	g.mu.Lock()
+	cancel := g.holderCancel
+	g.holderCancel = nil
+	g.mu.Unlock()
+	if cancel != nil {
+		cancel(r)
+	}
+}
+
+// schedulePreemptLocked arms the preemption timer if a waiter outranks
+// the current holder and the holder can be evicted.
+func (g *gate) schedulePreemptLocked() {
+	if !g.preempt || !g.busy || g.holderCancel == nil || g.preemptTimer != nil {
+		return
+	}
+	if len(g.waiters) == 0 || g.waiters[0].prio <= g.holderPrio {
+		return
+	}
+	gen := g.gen
+	g.preemptTimer = time.AfterFunc(g.preemptGrace, func() {
+		g.mu.Lock()
+		if g.gen != gen || g.holderCancel == nil {
+			g.mu.Unlock()
+			return
+		}
+		cancel := g.holderCancel
+		g.holderCancel = nil
+		g.mu.Unlock()
+		cancel(ReasonPreempted)
+	})
+}
+
+// close marks the gate closed and wakes every waiter. It is safe to
+// call more than once.
+func (g *gate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return
+	}
+	g.closed = true
+	close(g.done)
+}
+
+func (g *gate) isClosed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closed
+}
+
+// queueLength reports how many callers are waiting for the slot.
+func (g *gate) queueLength() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.waiters)
+}
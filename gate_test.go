@@ -0,0 +1,46 @@
+package turnstile
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestGateHandOffAcrossClose hands the slot to a waiter and closes the
+// gate before the waiter gets to run: it must give the slot up and
+// fail with net.ErrClosed rather than go ahead.
+func TestGateHandOffAcrossClose(t *testing.T) {
+	// With one P, the waiter can't run between release and close.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	for range 20 {
+		g := newGate()
+		if err := g.acquire(context.Background(), 0); err != nil {
+			t.Fatal(err)
+		}
+		res := make(chan error, 1)
+		go func() { res <- g.acquire(context.Background(), 0) }()
+		deadline := time.Now().Add(10 * time.Second)
+		for g.queueLength() != 1 {
+			if time.Now().After(deadline) {
+				t.Fatal("waiter never queued")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		g.release()
+		g.close()
+		select {
+		case err := <-res:
+			if !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("waiter got %v, want net.ErrClosed", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("waiter never returned")
+		}
+		if g.queueLength() != 0 {
+			t.Fatal("waiter still queued")
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package turnstile
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Health is the state of a listener's or dialer's link, as reported
+// WithHealthReport.
+type Health int
+
+const (
+	// HealthUnknown means nothing has been opened yet.
+	HealthUnknown Health = iota
+
+	// Healthy means the latest open succeeded.
+	Healthy
+
+	// Degraded means opening the device has failed repeatedly.
+	Degraded
+)
+
+func (h Health) String() string {
+	switch h {
+	case HealthUnknown:
+		return "unknown"
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	}
+	return fmt.Sprintf("Health(%d)", int(h))
+}
+
+// WithHealthReport calls fn whenever the link's health changes: with
+// Healthy once an open succeeds, and with Degraded and the latest error
+// once failures opens in a row have failed (failures defaults to 3).
+// Process supervisors can then restart a gateway whose serial stack
+// has wedged; see SystemdNotifier. fn is called from whichever
+// goroutine opened the device, so it should be quick.
+func WithHealthReport(failures int, fn func(h Health, err error)) Option {
+	if failures <= 0 {
+		failures = 3
+	}
+	return func(o *options) {
+		o.health = &healthTracker{threshold: failures, report: fn}
+	}
+}
+
+// healthTracker follows the results of opens for WithHealthReport.
+type healthTracker struct {
+	threshold int
+	report    func(Health, error)
+
+	mu    sync.Mutex
+	fails int // consecutive failed opens
+	state Health
+}
+
+// opened records the result of an open.
+func (t *healthTracker) opened(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	h := t.state
+	if err == nil {
+		t.fails = 0
+		h = Healthy
+	} else if t.fails++; t.fails >= t.threshold {
+		h = Degraded
+	}
+	changed := h != t.state
+	t.state = h
+	t.mu.Unlock()
+	if changed {
+		t.report(h, err)
+	}
+}
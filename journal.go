@@ -0,0 +1,124 @@
+package turnstile
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// JournalEntry is one line of a Journal.
+type JournalEntry struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"` // see Journal
+	Device string    `json:"device,omitempty"`
+	PID    int       `json:"pid,omitempty"`
+
+	Session  uint64  `json:"session,omitempty"`
+	Attempt  int     `json:"attempt,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	BytesIn  int64   `json:"bytes_in,omitempty"`
+	BytesOut int64   `json:"bytes_out,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // in seconds
+	Reason   string  `json:"reason,omitempty"`
+}
+
+// Journal writes an append-only record of session activity as JSON
+// lines, one JournalEntry each, for post-mortem analysis of devices in
+// the field where live metrics can't be reached. The events are:
+//
+//   - "start": the journal was opened, with the process ID, so
+//     restarts show up;
+//   - "open_error": opening the device failed, with the attempt
+//     number and error;
+//   - "session_start": a conn was handed out;
+//   - "session_end": a conn was closed, with the byte counts,
+//     duration, close reason, and first read or write error.
+//
+// A Journal is safe to share between listeners and dialers; entries
+// name their device.
+type Journal struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+	err error // first write error; the journal stops there
+}
+
+// NewJournal returns a Journal writing to w.
+func NewJournal(w io.Writer) *Journal {
+	j := &Journal{w: w, enc: json.NewEncoder(w)}
+	j.write(JournalEntry{Event: "start", PID: os.Getpid()})
+	return j
+}
+
+// OpenJournal opens the file name for appending, creating it if
+// needed, and returns a Journal writing to it. The file keeps
+// entries from earlier runs, so it survives restarts.
+func OpenJournal(name string) (*Journal, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJournal(f), nil
+}
+
+// Err returns the first error writing the journal, after which
+// nothing more is written.
+func (j *Journal) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Close closes the writer, if it is an io.Closer.
+func (j *Journal) Close() error {
+	if c, ok := j.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (j *Journal) write(e JournalEntry) {
+	if j == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err == nil {
+		// Encode writes each entry with a single Write.
+		j.err = j.enc.Encode(e)
+	}
+}
+
+// WithJournal records a listener's or dialer's opens and sessions in
+// j.
+func WithJournal(j *Journal) Option {
+	return func(o *options) {
+		o.journal = j
+	}
+}
+
+// sessionEnd records the end of the session summarized by s.
+func (j *Journal) sessionEnd(device string, s SessionSummary) {
+	if j == nil {
+		return
+	}
+	e := JournalEntry{
+		Event:    "session_end",
+		Device:   device,
+		Session:  s.ID,
+		BytesIn:  s.BytesIn,
+		BytesOut: s.BytesOut,
+		Duration: s.Duration().Seconds(),
+		Reason:   s.Reason.String(),
+	}
+	switch {
+	case s.ReadErr != nil:
+		e.Error = s.ReadErr.Error()
+	case s.WriteErr != nil:
+		e.Error = s.WriteErr.Error()
+	}
+	j.write(e)
+}
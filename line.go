@@ -0,0 +1,216 @@
+package turnstile
+
+import (
+	"net"
+	"sync"
+)
+
+// Newline selects a line ending for LineDiscipline.
+type Newline int
+
+const (
+	NewlineKeep Newline = iota // pass line endings through untouched
+	NewlineLF                  // "\n"
+	NewlineCR                  // "\r"
+	NewlineCRLF                // "\r\n"
+)
+
+func (n Newline) bytes() []byte {
+	switch n {
+	case NewlineLF:
+		return []byte{'\n'}
+	case NewlineCR:
+		return []byte{'\r'}
+	case NewlineCRLF:
+		return []byte{'\r', '\n'}
+	}
+	return nil
+}
+
+// LineDiscipline configures NewLineConn, which makes a raw UART usable
+// as an interactive console.
+type LineDiscipline struct {
+	// InputNewline and OutputNewline translate CR, LF, and CRLF line
+	// endings read from or written to the conn into the given ending.
+	InputNewline  Newline
+	OutputNewline Newline
+
+	// Echo writes input back to the conn as it is read, for peers
+	// whose terminal relies on the far end to echo.
+	Echo bool
+
+	// StripEcho drops input that repeats what was just written, for
+	// devices that echo everything they receive.
+	StripEcho bool
+
+	// Cooked buffers input a line at a time, applying backspace (BS
+	// and DEL) editing, and only returns complete lines from Read.
+	Cooked bool
+}
+
+// lineConn applies a LineDiscipline to a net.Conn.
+type lineConn struct {
+	net.Conn
+	ld LineDiscipline
+
+	rmu  sync.Mutex
+	raw  [512]byte
+	out  []byte // processed input ready for Read
+	line []byte // partial line in cooked mode
+	inCR bool   // previous input byte was CR
+	err  error  // sticky read error
+
+	wmu   sync.Mutex
+	outCR bool   // previous output byte was CR
+	echoQ []byte // written bytes the peer is expected to echo
+}
+
+// maxEchoQ bounds how much written data StripEcho remembers.
+const maxEchoQ = 4096
+
+// NewLineConn wraps c with the line discipline ld.
+func NewLineConn(c net.Conn, ld LineDiscipline) net.Conn {
+	return &lineConn{Conn: c, ld: ld}
+}
+
+func (c *lineConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.out) == 0 {
+		if c.err != nil {
+			// Hand over any partial line before the error.
+			if len(c.line) > 0 {
+				c.out, c.line = c.line, nil
+				break
+			}
+			return 0, c.err
+		}
+		n, err := c.Conn.Read(c.raw[:])
+		c.input(c.raw[:n])
+		c.err = err
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// input runs b through the discipline, appending the result to c.out.
+func (c *lineConn) input(b []byte) {
+	var echo []byte
+	for _, ch := range b {
+		if c.ld.StripEcho && c.unecho(ch) {
+			continue
+		}
+
+		newline := false
+		if ch == '\r' || ch == '\n' {
+			if ch == '\n' && c.inCR {
+				// Second half of a CRLF.
+				c.inCR = false
+				if c.ld.InputNewline != NewlineKeep {
+					continue
+				}
+				if c.ld.Cooked {
+					// The line went out with the CR; finish it.
+					c.out = append(c.out, ch)
+					continue
+				}
+			} else {
+				c.inCR = ch == '\r'
+				newline = true
+			}
+		} else {
+			c.inCR = false
+		}
+
+		var emit []byte
+		switch {
+		case newline && c.ld.InputNewline != NewlineKeep:
+			emit = c.ld.InputNewline.bytes()
+		case c.ld.Cooked && (ch == '\b' || ch == 0x7f):
+			if len(c.line) > 0 {
+				c.line = c.line[:len(c.line)-1]
+				echo = append(echo, '\b', ' ', '\b')
+			}
+			continue
+		default:
+			emit = []byte{ch}
+		}
+
+		if c.ld.Echo {
+			if newline {
+				echo = append(echo, '\r', '\n')
+			} else {
+				echo = append(echo, emit...)
+			}
+		}
+		if !c.ld.Cooked {
+			c.out = append(c.out, emit...)
+			continue
+		}
+		c.line = append(c.line, emit...)
+		if newline {
+			c.out = append(c.out, c.line...)
+			c.line = nil
+		}
+	}
+	if c.ld.Echo && len(echo) > 0 {
+		c.Conn.Write(echo)
+	}
+}
+
+// unecho reports whether ch is the echo of a byte we wrote.
+func (c *lineConn) unecho(ch byte) bool {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if len(c.echoQ) == 0 {
+		return false
+	}
+	if c.echoQ[0] == ch {
+		c.echoQ = c.echoQ[1:]
+		return true
+	}
+	// The peer stopped echoing; don't eat unrelated input.
+	c.echoQ = nil
+	return false
+}
+
+func (c *lineConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	b := p
+	if c.ld.OutputNewline != NewlineKeep {
+		ob := getBuf()
+		b = c.output((*ob)[:0], p)
+		*ob = b[:0] // keep any growth
+		defer putBuf(ob)
+	}
+	if c.ld.StripEcho {
+		c.echoQ = append(c.echoQ, b...)
+		if len(c.echoQ) > maxEchoQ {
+			c.echoQ = c.echoQ[len(c.echoQ)-maxEchoQ:]
+		}
+	}
+	c.wmu.Unlock()
+	if _, err := c.Conn.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// output translates line endings in p according to OutputNewline,
+// appending the result to b.
+func (c *lineConn) output(b, p []byte) []byte {
+	nl := c.ld.OutputNewline.bytes()
+	for _, ch := range p {
+		switch {
+		case ch == '\n' && c.outCR:
+			// Already emitted for the CR.
+		case ch == '\r' || ch == '\n':
+			b = append(b, nl...)
+		default:
+			b = append(b, ch)
+		}
+		c.outCR = ch == '\r'
+	}
+	return b
+}
@@ -0,0 +1,130 @@
+package turnstile
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// CloseLinker ties the lifetimes of two conns together, e.g. the two
+// sides of a bridge, so that when one closes, the other follows. The
+// other side first has its write half shut down, if it has a
+// CloseWrite method, so the far end sees EOF while data still in flight
+// towards us can arrive; it is then closed once the linger time has
+// passed.
+//
+// Use the conns returned by A and B in place of the originals. A conn
+// with a *Conn behind it (see ConnContext) is also followed when it is
+// closed some other way, such as by preemption or a watchdog, and a
+// *Conn closed because of its partner reports ReasonLinked.
+type CloseLinker struct {
+	a, b   *linkedConn
+	linger time.Duration
+	done   chan struct{}
+
+	mu     sync.Mutex
+	first  net.Conn // the side that closed first
+	reason CloseReason
+	closed int
+}
+
+// NewCloseLinker links a and b, lingering for linger before closing the
+// side left open.
+func NewCloseLinker(a, b net.Conn, linger time.Duration) *CloseLinker {
+	l := &CloseLinker{linger: linger, done: make(chan struct{})}
+	l.a = &linkedConn{Conn: a, l: l}
+	l.b = &linkedConn{Conn: b, l: l}
+	l.a.peer, l.b.peer = l.b, l.a
+	for _, c := range []*linkedConn{l.a, l.b} {
+		if tc, ok := unwrapConn(c.Conn); ok {
+			// The conn's context ends when it is closed, however that
+			// happens.
+			context.AfterFunc(tc.Context(), func() { c.closed(tc.CloseReason()) })
+		}
+	}
+	return l
+}
+
+// A and B return the linked versions of the conns given to
+// NewCloseLinker.
+func (l *CloseLinker) A() net.Conn { return l.a }
+func (l *CloseLinker) B() net.Conn { return l.b }
+
+// Done is closed once both conns are closed.
+func (l *CloseLinker) Done() <-chan struct{} { return l.done }
+
+// First returns the conn that closed first, as returned by A or B, and
+// its close reason if it has a *Conn behind it; nil and ReasonNone if
+// neither has closed yet.
+func (l *CloseLinker) First() (net.Conn, CloseReason) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.first, l.reason
+}
+
+// linkedConn is one side of a CloseLinker.
+type linkedConn struct {
+	net.Conn
+	l    *CloseLinker
+	peer *linkedConn
+
+	once sync.Once
+	err  error
+}
+
+// Close closes the conn and, after lingering, its partner.
+func (c *linkedConn) Close() error {
+	reason := ReasonLocal
+	if tc, ok := unwrapConn(c.Conn); ok {
+		if r := tc.CloseReason(); r != ReasonNone {
+			reason = r
+		}
+	}
+	c.closed(reason)
+	return c.err
+}
+
+// closed closes c, if it hasn't been, recording reason if it is the
+// first side to close, and passes the close on to the partner.
+func (c *linkedConn) closed(reason CloseReason) {
+	c.once.Do(func() {
+		c.err = c.Conn.Close()
+		l := c.l
+		l.mu.Lock()
+		first := l.first == nil
+		if first {
+			l.first, l.reason = c, reason
+		}
+		l.closed++
+		if l.closed == 2 {
+			close(l.done)
+		}
+		l.mu.Unlock()
+		if first {
+			go c.peer.follow()
+		}
+	})
+}
+
+// follow closes c because its partner has closed.
+func (c *linkedConn) follow() {
+	if tc, ok := unwrapConn(c.Conn); ok {
+		tc.reason.CompareAndSwap(0, int32(ReasonLinked))
+	}
+	if c.l.linger > 0 {
+		if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		t := time.NewTimer(c.l.linger)
+		select {
+		case <-t.C:
+		case <-c.l.done:
+		}
+		t.Stop()
+	}
+	c.closed(ReasonLinked)
+}
+
+// Unwrap returns the conn c links.
+func (c *linkedConn) Unwrap() net.Conn { return c.Conn }
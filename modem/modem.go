@@ -0,0 +1,167 @@
+// Package modem runs AT command init scripts on freshly opened modems,
+// so that turnstile hands out a modem that is ready for PPP or data.
+//
+//	open := modem.Init(openSerial, modem.DefaultScript)
+//	d := turnstile.NewReopenDialer(open, "/dev/ttyUSB2")
+//
+// A script that fails (unexpected response, ERROR, or timeout) closes
+// the device and reports the error, so turnstile retries the open with
+// its usual backoff.
+package modem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/sparques/turnstile"
+)
+
+// DefaultTimeout is used for steps that don't set their own Timeout.
+const DefaultTimeout = 2 * time.Second
+
+// Step is a single exchange of an init script: Send is written to the
+// modem, then input is read until it matches Expect. If Fail is set
+// and matches earlier in the input than Expect, the step fails. A step
+// that times out is retried Retries more times before the script gives
+// up.
+type Step struct {
+	Send    string
+	Expect  *regexp.Regexp
+	Fail    *regexp.Regexp
+	Timeout time.Duration
+	Retries int
+}
+
+// Script is a sequence of steps run in order.
+type Script []Step
+
+var (
+	ok       = regexp.MustCompile(`(?m)^\s*OK\s*$`)
+	errorRes = regexp.MustCompile(`(?m)^\s*(ERROR|\+CME ERROR.*|NO CARRIER)\s*$`)
+)
+
+// Cmd returns a Step that sends the AT command cmd, terminated by a
+// carriage return, and waits for OK. ERROR, +CME ERROR, and NO CARRIER
+// fail the step.
+func Cmd(cmd string) Step {
+	return Step{Send: cmd + "\r", Expect: ok, Fail: errorRes}
+}
+
+// DefaultScript resets the modem and turns off command echo.
+var DefaultScript = Script{
+	{Send: "ATZ\r", Expect: ok, Fail: errorRes, Retries: 2},
+	Cmd("ATE0"),
+}
+
+// ErrTimeout is returned when a step sees no matching response in time.
+var ErrTimeout = turnstile.ErrExpectTimeout
+
+// ErrFailed is returned when a step's Fail pattern matched.
+var ErrFailed = errors.New("modem: command failed")
+
+// StepError reports which step of a script failed and what the modem
+// sent in response.
+type StepError struct {
+	Step     int
+	Send     string
+	Response string
+	Err      error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("modem: step %d (%q): %v (got %q)", e.Step, e.Send, e.Err, e.Response)
+}
+
+func (e *StepError) Unwrap() error { return e.Err }
+
+// Init wraps open so that script is run after every successful open.
+// If the script fails the device is closed and the error returned.
+// Anything the modem sends after the last reply is kept for the
+// session.
+func Init(open turnstile.OpenFunc, script Script) turnstile.OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		rwc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		e := turnstile.NewExpecter(rwc)
+		if err := script.run(e); err != nil {
+			rwc.Close()
+			return nil, err
+		}
+		// Pass on whatever the modem sent after the last reply.
+		return e.ReadWriteCloser(), nil
+	}
+}
+
+// Check returns script as a health check suitable for
+// turnstile.WithHealthCheck.
+func Check(script Script) func(io.ReadWriter) error {
+	return script.Run
+}
+
+// Run executes the script over rw.
+//
+// Since an io.ReadWriter has no deadlines, a read may still be pending
+// when a step times out; it is picked up by the next step. After a
+// failed script the caller should close rw, which unblocks that read.
+// After a successful one, input read past the last reply, and a read
+// still pending, are handed back to rw with Expecter.Release, so a
+// health check doesn't swallow the start of the session.
+func (s Script) Run(rw io.ReadWriter) error {
+	e := turnstile.NewExpecter(rw)
+	if err := s.run(e); err != nil {
+		return err
+	}
+	e.Release()
+	return nil
+}
+
+func (s Script) run(e *turnstile.Expecter) error {
+	for i, step := range s {
+		timeout := step.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		var err error
+		var got string
+		for try := 0; try <= step.Retries; try++ {
+			if step.Send != "" {
+				if err = e.Send(step.Send); err != nil {
+					break
+				}
+			}
+			if step.Expect == nil {
+				break
+			}
+			got, err = expect(e, step, timeout)
+			if err != ErrTimeout {
+				break
+			}
+		}
+		if err != nil {
+			return &StepError{Step: i, Send: step.Send, Response: got, Err: err}
+		}
+	}
+	return nil
+}
+
+// expect waits for the step's Expect or Fail pattern, returning the
+// modem's response.
+func expect(e *turnstile.Expecter, step Step, timeout time.Duration) (string, error) {
+	res := []*regexp.Regexp{step.Expect}
+	if step.Fail != nil {
+		res = append(res, step.Fail)
+	}
+	i, m, err := e.ExpectAny(timeout, res...)
+	if err != nil {
+		return m[0], err
+	}
+	if i == 1 {
+		return m[0], ErrFailed
+	}
+	return m[0], nil
+}
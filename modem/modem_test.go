@@ -0,0 +1,32 @@
+package modem
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestFailBeforeExpect has the modem send ERROR and a stray OK in one
+// read: the step must fail on the ERROR that came first.
+func TestFailBeforeExpect(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	go func() {
+		defer b.Close()
+		buf := make([]byte, 64)
+		if _, err := b.Read(buf); err != nil {
+			return
+		}
+		io.WriteString(b, "\r\nERROR\r\n\r\nOK\r\n")
+	}()
+	err := Script{Cmd("AT+CFUN=1")}.Run(a)
+	var se *StepError
+	if !errors.As(err, &se) || !errors.Is(err, ErrFailed) {
+		t.Fatalf("Run: %v, want ErrFailed", err)
+	}
+	if !strings.Contains(se.Response, "ERROR") || strings.Contains(se.Response, "OK") {
+		t.Fatalf("response %q", se.Response)
+	}
+}
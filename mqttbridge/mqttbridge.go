@@ -0,0 +1,62 @@
+// Package mqttbridge runs an MQTT client over a serial link managed by
+// a turnstile.ReopenDialer, such as a UART to a device that speaks MQTT
+// or to a broker on the far side of a radio modem.
+//
+// It doesn't depend on any MQTT library, and doesn't run the client
+// itself: a Bridge supplies the conns and tells the client whether to
+// ask for a clean session, and the client's own reconnect loop does
+// the rest. With the Eclipse Paho client, for instance:
+//
+//	b := &mqttbridge.Bridge{Dialer: d, Persistent: true}
+//	opts := mqtt.NewClientOptions().
+//		AddBroker("tcp://serial:1883").
+//		SetClientID("telemetry-1").
+//		SetCleanSession(b.CleanSession()).
+//		SetAutoReconnect(true).
+//		SetConnectRetry(true).
+//		SetCustomOpenConnectionFn(func(*url.URL, mqtt.ClientOptions) (net.Conn, error) {
+//			return b.Dial(context.Background())
+//		}).
+//		SetOnConnectHandler(func(c mqtt.Client) {
+//			// Called after every connect, so subscriptions the broker
+//			// didn't keep are made again.
+//			c.Subscribe("cmd/#", 1, onCommand)
+//		}).
+//		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+//			log.Printf("mqtt: link lost: %v", err)
+//		})
+//
+// Paho then re-establishes the session whenever turnstile reopens the
+// link, resending unacknowledged QoS 1 and 2 messages from its store.
+package mqttbridge
+
+import (
+	"context"
+	"net"
+
+	"github.com/sparques/turnstile"
+)
+
+// Bridge hands out conns to an MQTT broker over a turnstile dialer.
+type Bridge struct {
+	Dialer *turnstile.ReopenDialer
+
+	// Address is passed to Dial; it only shows up in RemoteAddr.
+	Address string
+
+	// Persistent asks for persistent MQTT sessions, kept across
+	// reconnects however the link was lost. A link error is just
+	// when the in-flight QoS 1 and 2 messages a persistent session
+	// holds matter, so it never forces a clean session.
+	Persistent bool
+}
+
+// CleanSession reports whether CONNECT should ask for a clean session.
+func (b *Bridge) CleanSession() bool {
+	return !b.Persistent
+}
+
+// Dial waits for the link and returns a conn for the MQTT client.
+func (b *Bridge) Dial(ctx context.Context) (net.Conn, error) {
+	return b.Dialer.DialContext(ctx, "serial", b.Address)
+}
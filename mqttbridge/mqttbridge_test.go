@@ -0,0 +1,44 @@
+package mqttbridge
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sparques/turnstile"
+)
+
+// TestPersistentAfterLinkError loses the link under a persistent
+// session: the next connect must still resume it, or the client would
+// drop its in-flight messages.
+func TestPersistentAfterLinkError(t *testing.T) {
+	devices := make(chan net.Conn, 2)
+	open := func() (io.ReadWriteCloser, error) {
+		a, b := net.Pipe()
+		devices <- b
+		return a, nil
+	}
+	d := turnstile.NewReopenDialer(open, "uart")
+	defer d.Close()
+	b := &Bridge{Dialer: d, Persistent: true}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := range 2 {
+		if b.CleanSession() {
+			t.Fatalf("connect %d asks for a clean session", i)
+		}
+		c, err := b.Dial(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The device vanishes.
+		(<-devices).Close()
+		if _, err := c.Read(make([]byte, 1)); err == nil {
+			t.Fatal("read from a lost link succeeded")
+		}
+		c.Close()
+	}
+}
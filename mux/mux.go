@@ -0,0 +1,402 @@
+// Package mux carries several independent streams over one serial
+// link, addressed by port number the way TCP is, so a console, a
+// management protocol, and a log feed can share a single UART.
+//
+// Both ends wrap the link, a turnstile session or anything else that
+// delivers bytes reliably and in order, in a Session. Either end can
+// then listen on ports and dial the other's:
+//
+//	c, err := d.DialContext(ctx, "serial", "board")
+//	...
+//	s := mux.New(c)
+//	logs, err := s.PortListener(514)
+//	...
+//	shell, err := s.DialPort(ctx, 23)
+//
+// Each stream is flow controlled on its own, so a stream nobody reads
+// from doesn't hold up the others. When the link fails the Session and
+// all its streams fail with it; build a new Session on the next
+// turnstile session.
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Frame types. The high bit is set on frames sent by the end that
+// opened the stream, so both ends can number their streams
+// independently.
+const (
+	frameOpen   = iota // payload: port
+	frameAccept        // the port is listening
+	frameData
+	frameFin    // no more data from the sender
+	frameReset  // stream refused or aborted
+	frameWindow // payload: bytes the sender may send on top
+
+	fromOpener = 0x80
+)
+
+const (
+	headerLen = 5 // type, stream ID, payload length
+
+	// maxPayload bounds a frame so one busy stream can't hog the link.
+	maxPayload = 1024
+
+	// window is how much unread data a stream buffers.
+	window = 16 << 10
+
+	// backlog is how many streams a port listener queues.
+	backlog = 16
+)
+
+var (
+	// ErrRefused is returned by DialPort when nothing listens on the
+	// port.
+	ErrRefused = errors.New("mux: connection refused")
+
+	// ErrReset is returned by reads and writes on a stream the peer
+	// aborted.
+	ErrReset = errors.New("mux: stream reset by peer")
+
+	// ErrPortInUse is returned by PortListener when the port already
+	// has a listener.
+	ErrPortInUse = errors.New("mux: port in use")
+
+	// ErrProtocol is returned when the peer sends something that
+	// doesn't parse; the Session is closed.
+	ErrProtocol = errors.New("mux: protocol error")
+)
+
+// Addr is the address of a stream's end: the port it was dialed on.
+type Addr uint16
+
+func (a Addr) Network() string { return "mux" }
+func (a Addr) String() string  { return fmt.Sprintf("mux:%d", uint16(a)) }
+
+// streamKey identifies a stream: its ID and which end opened it.
+type streamKey struct {
+	id     uint16
+	remote bool
+}
+
+// Session multiplexes streams over a link.
+type Session struct {
+	c io.ReadWriteCloser
+
+	mu        sync.Mutex
+	streams   map[streamKey]*stream
+	listeners map[uint16]*portListener
+	nextID    uint16
+	out       [][]byte // frames waiting for the writer
+	outReady  chan struct{}
+	done      chan struct{}
+	err       error
+}
+
+// New starts a Session on c. The Session owns c and closes it when the
+// Session is closed or fails.
+func New(c io.ReadWriteCloser) *Session {
+	s := &Session{
+		c:         c,
+		streams:   make(map[streamKey]*stream),
+		listeners: make(map[uint16]*portListener),
+		outReady:  make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	go s.readLoop()
+	go s.writeLoop()
+	return s
+}
+
+// Close closes the Session, its link, and all its streams.
+func (s *Session) Close() error {
+	s.fail(net.ErrClosed)
+	return nil
+}
+
+// Done is closed when the Session ends.
+func (s *Session) Done() <-chan struct{} { return s.done }
+
+// Err returns why the Session ended, or nil while it is running.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// fail ends the Session with err, unless it has already ended.
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = nil
+	s.out = nil
+	close(s.done)
+	s.mu.Unlock()
+
+	s.c.Close()
+	for _, st := range streams {
+		st.abort(err)
+	}
+}
+
+// PortListener returns a listener for streams the peer dials to port.
+func (s *Session) PortListener(port uint16) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.listeners[port] != nil {
+		return nil, ErrPortInUse
+	}
+	l := &portListener{s: s, port: port, conns: make(chan *stream, backlog), closed: make(chan struct{})}
+	s.listeners[port] = l
+	return l, nil
+}
+
+// DialPort opens a stream to whatever listens on port at the other end.
+func (s *Session) DialPort(ctx context.Context, port uint16) (net.Conn, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	var key streamKey
+	for {
+		key = streamKey{id: s.nextID}
+		s.nextID++
+		if s.streams[key] == nil {
+			break
+		}
+	}
+	st := newStream(s, key, port)
+	st.accepted = make(chan struct{})
+	s.streams[key] = st
+	s.queue(frameOpen, key, binary.BigEndian.AppendUint16(nil, port))
+	s.mu.Unlock()
+
+	select {
+	case <-st.accepted:
+		st.mu.Lock()
+		err := st.err
+		st.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return st, nil
+	case <-ctx.Done():
+		s.forget(key)
+		s.send(frameReset, key, nil)
+		st.abort(ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+// send queues a frame for the writer.
+func (s *Session) send(typ byte, key streamKey, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue(typ, key, payload)
+}
+
+// queue is send with s.mu held.
+func (s *Session) queue(typ byte, key streamKey, payload []byte) {
+	if s.err != nil {
+		return
+	}
+	if !key.remote {
+		typ |= fromOpener
+	}
+	f := make([]byte, headerLen, headerLen+len(payload))
+	f[0] = typ
+	binary.BigEndian.PutUint16(f[1:], key.id)
+	binary.BigEndian.PutUint16(f[3:], uint16(len(payload)))
+	s.out = append(s.out, append(f, payload...))
+	select {
+	case s.outReady <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop writes queued frames, so the read loop never blocks on
+// the link to reply.
+func (s *Session) writeLoop() {
+	for {
+		select {
+		case <-s.outReady:
+		case <-s.done:
+			return
+		}
+		s.mu.Lock()
+		out := s.out
+		s.out = nil
+		s.mu.Unlock()
+		for _, f := range out {
+			if _, err := s.c.Write(f); err != nil {
+				s.fail(err)
+				return
+			}
+		}
+	}
+}
+
+func (s *Session) readLoop() {
+	hdr := make([]byte, headerLen)
+	// handle doesn't hold on to payloads, so one buffer does for all.
+	buf := make([]byte, maxPayload)
+	for {
+		if _, err := io.ReadFull(s.c, hdr); err != nil {
+			s.fail(err)
+			return
+		}
+		n := binary.BigEndian.Uint16(hdr[3:])
+		if n > maxPayload {
+			s.fail(ErrProtocol)
+			return
+		}
+		payload := buf[:n]
+		if _, err := io.ReadFull(s.c, payload); err != nil {
+			s.fail(err)
+			return
+		}
+		// A frame from the opener is for a stream the peer opened.
+		key := streamKey{id: binary.BigEndian.Uint16(hdr[1:]), remote: hdr[0]&fromOpener != 0}
+		if err := s.handle(hdr[0]&^fromOpener, key, payload); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handle(typ byte, key streamKey, payload []byte) error {
+	if typ == frameOpen {
+		if len(payload) != 2 || !key.remote {
+			return ErrProtocol
+		}
+		s.accept(key, binary.BigEndian.Uint16(payload))
+		return nil
+	}
+
+	s.mu.Lock()
+	st := s.streams[key]
+	s.mu.Unlock()
+	if st == nil {
+		// The stream is gone at our end; tell the peer, unless it
+		// already knows.
+		if typ != frameReset {
+			s.send(frameReset, key, nil)
+		}
+		return nil
+	}
+
+	switch typ {
+	case frameAccept:
+		st.established()
+	case frameData:
+		if !st.deliver(payload) {
+			s.forget(key)
+			s.send(frameReset, key, nil)
+		}
+	case frameFin:
+		if st.peerFin() {
+			s.forget(key)
+		}
+	case frameReset:
+		s.forget(key)
+		st.reset()
+	case frameWindow:
+		if len(payload) != 4 {
+			return ErrProtocol
+		}
+		st.grant(int(binary.BigEndian.Uint32(payload)))
+	default:
+		return ErrProtocol
+	}
+	return nil
+}
+
+// accept handles a stream the peer opens to port.
+func (s *Session) accept(key streamKey, port uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	l := s.listeners[port]
+	if l == nil || s.streams[key] != nil {
+		s.queue(frameReset, key, nil)
+		return
+	}
+	st := newStream(s, key, port)
+	select {
+	case l.conns <- st:
+		s.streams[key] = st
+		s.queue(frameAccept, key, nil)
+	default:
+		// Backlog full.
+		s.queue(frameReset, key, nil)
+	}
+}
+
+// forget drops a finished stream.
+func (s *Session) forget(key streamKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, key)
+}
+
+// portListener is the listener for one port.
+type portListener struct {
+	s         *Session
+	port      uint16
+	conns     chan *stream
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *portListener) Accept() (net.Conn, error) {
+	select {
+	case st := <-l.conns:
+		return st, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-l.s.done:
+		return nil, l.s.Err()
+	}
+}
+
+// Close stops listening on the port. Streams already accepted are not
+// affected; those still queued are reset.
+func (l *portListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.s.mu.Lock()
+		if l.s.listeners[l.port] == l {
+			delete(l.s.listeners, l.port)
+		}
+		l.s.mu.Unlock()
+		close(l.closed)
+		for {
+			select {
+			case st := <-l.conns:
+				st.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (l *portListener) Addr() net.Addr { return Addr(l.port) }
@@ -0,0 +1,156 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// pair returns two Sessions talking over a pipe.
+func pair(t *testing.T) (a, b *Session) {
+	t.Helper()
+	ca, cb := net.Pipe()
+	a, b = New(ca), New(cb)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// listen returns a listener on port of s that runs serve on each
+// stream it accepts.
+func listen(t *testing.T, s *Session, port uint16, serve func(net.Conn)) {
+	t.Helper()
+	l, err := s.PortListener(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c)
+		}
+	}()
+}
+
+// dial opens a stream to port of the peer, failing the test if it can't.
+func dial(t *testing.T, s *Session, port uint16) net.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := s.DialPort(ctx, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+// streams returns how many streams s is keeping track of.
+func streams(s *Session) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// TestEcho sends more than a window's worth down a stream to an echo
+// server and back, a few times over, and checks closed streams are
+// forgotten at both ends.
+func TestEcho(t *testing.T) {
+	a, b := pair(t)
+	listen(t, b, 7, func(c net.Conn) {
+		io.Copy(c, c)
+		c.Close()
+	})
+
+	data := testData(3 * window)
+	for range 3 {
+		c := dial(t, a, 7)
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		go func() {
+			c.Write(data)
+			c.(interface{ CloseWrite() error }).CloseWrite()
+		}()
+		got, err := io.ReadAll(c)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("echoed %d bytes, %v; want %d back", len(got), err, len(data))
+		}
+		c.Close()
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); streams(a)+streams(b) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d and %d streams left open", streams(a), streams(b))
+		}
+	}
+
+	if _, err := a.DialPort(context.Background(), 8); !errors.Is(err, ErrRefused) {
+		t.Fatalf("dial to a closed port: %v, want ErrRefused", err)
+	}
+}
+
+// TestWindowExhausted stalls a stream whose reader has stopped: its
+// writer must block once the window is used up, without holding up
+// other streams, and carry on once the reader catches up.
+func TestWindowExhausted(t *testing.T) {
+	a, b := pair(t)
+	accepted := make(chan net.Conn, 1)
+	listen(t, b, 1, func(c net.Conn) { accepted <- c })
+	listen(t, b, 7, func(c net.Conn) {
+		io.Copy(c, c)
+		c.Close()
+	})
+
+	stalled := dial(t, a, 1)
+	defer stalled.Close()
+	peer := <-accepted
+	defer peer.Close()
+
+	data := testData(2 * window)
+	stalled.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := stalled.Write(data)
+	if n != window || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write to a stalled stream: %d, %v; want %d, deadline exceeded", n, err, window)
+	}
+
+	// Other streams still flow.
+	echo := dial(t, a, 7)
+	defer echo.Close()
+	echo.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := echo.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(echo, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo: %q, %v", buf, err)
+	}
+
+	// Reading opens the window up again.
+	stalled.SetWriteDeadline(time.Time{})
+	go func() {
+		stalled.Write(data[n:])
+		stalled.(interface{ CloseWrite() error }).CloseWrite()
+	}()
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(peer)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes, %v; want %d", len(got), err, len(data))
+	}
+}
@@ -0,0 +1,284 @@
+package mux
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// stream is one end of a stream; it implements net.Conn.
+type stream struct {
+	s    *Session
+	key  streamKey
+	port uint16
+
+	accepted chan struct{} // closed when a dialed stream is answered
+
+	mu       sync.Mutex
+	buf      []byte // received, not yet read
+	unacked  int    // bytes read but not yet granted back to the peer
+	credit   int    // bytes we may still send
+	eof      bool   // the peer sent FIN
+	finSent  bool
+	closed   bool
+	err      error // reset or session failure
+	rdl, wdl time.Time
+	readable chan struct{}
+	writable chan struct{}
+}
+
+func newStream(s *Session, key streamKey, port uint16) *stream {
+	return &stream{
+		s:        s,
+		key:      key,
+		port:     port,
+		credit:   window,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wake rouses any Read or Write waiting on the stream.
+func (st *stream) wake() {
+	signal(st.readable)
+	signal(st.writable)
+}
+
+// wait waits for ch until deadline.
+func (st *stream) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (st *stream) Read(b []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.closed {
+			st.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if len(st.buf) > 0 {
+			n := copy(b, st.buf)
+			st.buf = st.buf[n:]
+			st.unacked += n
+			grant := 0
+			if st.unacked >= window/2 || len(st.buf) == 0 {
+				grant, st.unacked = st.unacked, 0
+			}
+			st.mu.Unlock()
+			if grant > 0 {
+				st.s.send(frameWindow, st.key, binary.BigEndian.AppendUint32(nil, uint32(grant)))
+			}
+			return n, nil
+		}
+		if st.err != nil {
+			err := st.err
+			st.mu.Unlock()
+			return 0, err
+		}
+		if st.eof {
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		dl := st.rdl
+		st.mu.Unlock()
+		if err := st.wait(st.readable, dl); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (st *stream) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		st.mu.Lock()
+		switch {
+		case st.closed:
+			st.mu.Unlock()
+			return written, net.ErrClosed
+		case st.err != nil:
+			err := st.err
+			st.mu.Unlock()
+			return written, err
+		case st.finSent:
+			st.mu.Unlock()
+			return written, net.ErrClosed
+		}
+		if st.credit > 0 {
+			n := min(len(b)-written, st.credit, maxPayload)
+			st.credit -= n
+			st.mu.Unlock()
+			st.s.send(frameData, st.key, append([]byte(nil), b[written:written+n]...))
+			written += n
+			continue
+		}
+		dl := st.wdl
+		st.mu.Unlock()
+		if err := st.wait(st.writable, dl); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// CloseWrite tells the peer no more data is coming; it reads EOF once
+// it has read what was sent.
+func (st *stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.closed || st.finSent {
+		st.mu.Unlock()
+		return net.ErrClosed
+	}
+	st.finSent = true
+	fin := st.err == nil
+	done := st.eof || st.err != nil
+	st.mu.Unlock()
+	if fin {
+		st.s.send(frameFin, st.key, nil)
+	}
+	if done {
+		st.s.forget(st.key)
+	}
+	st.wake()
+	return nil
+}
+
+// Close closes the stream. Data the peer sends afterwards gets the
+// stream reset, as with TCP.
+func (st *stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return net.ErrClosed
+	}
+	st.closed = true
+	fin := !st.finSent && st.err == nil
+	st.finSent = true
+	done := st.eof || st.err != nil
+	st.mu.Unlock()
+	if fin {
+		st.s.send(frameFin, st.key, nil)
+	}
+	if done {
+		st.s.forget(st.key)
+	}
+	st.wake()
+	return nil
+}
+
+// established marks a dialed stream as answered.
+func (st *stream) established() {
+	if st.accepted != nil {
+		select {
+		case <-st.accepted:
+		default:
+			close(st.accepted)
+		}
+	}
+}
+
+// deliver buffers data from the peer, reporting false if the stream
+// was closed at our end.
+func (st *stream) deliver(p []byte) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return false
+	}
+	st.buf = append(st.buf, p...)
+	signal(st.readable)
+	return true
+}
+
+// peerFin records the peer's FIN, reporting whether the stream is now
+// finished in both directions.
+func (st *stream) peerFin() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.eof = true
+	signal(st.readable)
+	return st.finSent
+}
+
+// reset handles the peer aborting or refusing the stream.
+func (st *stream) reset() {
+	if st.accepted != nil {
+		select {
+		case <-st.accepted:
+		default:
+			st.abort(ErrRefused)
+			return
+		}
+	}
+	st.abort(ErrReset)
+}
+
+// abort fails the stream with err.
+func (st *stream) abort(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.mu.Unlock()
+	st.established()
+	st.wake()
+}
+
+// grant adds to the stream's send credit.
+func (st *stream) grant(n int) {
+	st.mu.Lock()
+	st.credit += n
+	st.mu.Unlock()
+	signal(st.writable)
+}
+
+func (st *stream) LocalAddr() net.Addr  { return Addr(st.port) }
+func (st *stream) RemoteAddr() net.Addr { return Addr(st.port) }
+
+func (st *stream) SetDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.rdl, st.wdl = t, t
+	st.mu.Unlock()
+	st.wake()
+	return nil
+}
+
+func (st *stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.rdl = t
+	st.mu.Unlock()
+	signal(st.readable)
+	return nil
+}
+
+func (st *stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.wdl = t
+	st.mu.Unlock()
+	signal(st.writable)
+	return nil
+}
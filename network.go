@@ -0,0 +1,242 @@
+package turnstile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNoTransport is returned by Network for an address whose scheme
+	// has no handler.
+	ErrNoTransport = errors.New("turnstile: no transport for address")
+
+	// ErrAddressInUse is returned by Network.Listen for an address
+	// that is already being listened on or dialed.
+	ErrAddressInUse = errors.New("turnstile: address in use")
+)
+
+// Network adapts turnstile to frameworks with pluggable transports,
+// such as libp2p or a NATS custom dialer, which dial and listen on
+// address strings. Addresses are multiaddr-like: "/" and a scheme,
+// then the path handed to the scheme's handler, e.g.
+// "/serial/dev/ttyUSB0" or "/rfcomm/00:11:22:33:44:55/1".
+//
+// Each device gets one ReopenDialer or ReopenListener, created on first
+// use with the Network's options, so concurrent Dials to an address
+// take turns on it like any other dialer's. A device can't be dialed
+// and listened on at the same time; its dialer is let go once no Dial
+// is waiting and every conn dialed is closed.
+type Network struct {
+	opts []Option
+
+	mu        sync.Mutex
+	handlers  map[string]func(path string) (OpenFunc, error)
+	dialers   map[string]*networkDialer
+	listeners map[string]*ReopenListener
+}
+
+// networkDialer is a Network's dialer for a device, with a count of
+// the Dials waiting on it and conns it has handed out.
+type networkDialer struct {
+	d    *ReopenDialer
+	refs int
+}
+
+// NewNetwork returns a Network whose dialers and listeners use opts.
+// It handles the "serial" scheme by opening the path as a file, e.g.
+// "/serial/dev/ttyUSB0"; use Handle for line settings or other kinds
+// of device.
+func NewNetwork(opts ...Option) *Network {
+	n := &Network{
+		opts:      opts,
+		handlers:  map[string]func(string) (OpenFunc, error){},
+		dialers:   map[string]*networkDialer{},
+		listeners: map[string]*ReopenListener{},
+	}
+	n.Handle("serial", func(path string) (OpenFunc, error) {
+		return func() (io.ReadWriteCloser, error) {
+			return os.OpenFile(path, os.O_RDWR, 0)
+		}, nil
+	})
+	return n
+}
+
+// Handle makes addresses with the given scheme open devices with the
+// OpenFunc that fn returns for the rest of the address, leading slash
+// included. Conns on those devices report the scheme from their
+// addresses' Network method. Unlike RegisterNetwork, this only affects
+// the Network's own dialers and listeners.
+func (n *Network) Handle(scheme string, fn func(path string) (OpenFunc, error)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handlers[scheme] = fn
+}
+
+// Protocols returns the schemes the Network handles, sorted.
+func (n *Network) Protocols() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := make([]string, 0, len(n.handlers))
+	for scheme := range n.handlers {
+		s = append(s, scheme)
+	}
+	sort.Strings(s)
+	return s
+}
+
+// CanDial reports whether addr has a scheme the Network handles.
+func (n *Network) CanDial(addr string) bool {
+	scheme, _, ok := splitTransportAddr(addr)
+	if !ok {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.handlers[scheme] != nil
+}
+
+// Dial waits its turn on the device at addr, opens it, and returns the
+// session's conn. The conn's RemoteAddr is the device's address.
+func (n *Network) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	n.mu.Lock()
+	nd := n.dialers[addr]
+	if nd == nil {
+		if n.listeners[addr] != nil {
+			n.mu.Unlock()
+			return nil, &net.OpError{Op: "dial", Net: "turnstile", Err: ErrAddressInUse}
+		}
+		open, name, opts, err := n.resolveLocked(addr)
+		if err != nil {
+			n.mu.Unlock()
+			return nil, &net.OpError{Op: "dial", Net: "turnstile", Err: err}
+		}
+		nd = &networkDialer{d: NewReopenDialer(open, name, opts...)}
+		n.dialers[addr] = nd
+	}
+	nd.refs++
+	n.mu.Unlock()
+
+	c, err := nd.d.dialTo(ctx, nd.d.addr, 0)
+	if err != nil {
+		n.release(addr, nd)
+		return nil, err
+	}
+	return &networkConn{Conn: c, release: func() { n.release(addr, nd) }}, nil
+}
+
+// release drops a reference to nd, letting go of its dialer, and so of
+// addr, with the last one.
+func (n *Network) release(addr string, nd *networkDialer) {
+	n.mu.Lock()
+	nd.refs--
+	last := nd.refs == 0 && n.dialers[addr] == nd
+	if last {
+		delete(n.dialers, addr)
+	}
+	n.mu.Unlock()
+	if last {
+		nd.d.Close()
+	}
+}
+
+// Listen returns a listener serving sessions on the device at addr.
+// Closing it frees addr for another Listen or Dial.
+func (n *Network) Listen(addr string) (net.Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listeners[addr] != nil || n.dialers[addr] != nil {
+		return nil, &net.OpError{Op: "listen", Net: "turnstile", Err: ErrAddressInUse}
+	}
+	open, name, opts, err := n.resolveLocked(addr)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "turnstile", Err: err}
+	}
+	l := NewReopenListener(open, name, opts...)
+	n.listeners[addr] = l
+	return &networkListener{ReopenListener: l, n: n, addr: addr}, nil
+}
+
+// Close closes every dialer and listener the Network has created.
+func (n *Network) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for addr, nd := range n.dialers {
+		nd.d.Close()
+		delete(n.dialers, addr)
+	}
+	for addr, l := range n.listeners {
+		l.Close()
+		delete(n.listeners, addr)
+	}
+	return nil
+}
+
+// resolveLocked returns the OpenFunc, device name, and options for the
+// dialer or listener of addr.
+func (n *Network) resolveLocked(addr string) (OpenFunc, string, []Option, error) {
+	scheme, path, ok := splitTransportAddr(addr)
+	fn := n.handlers[scheme]
+	if !ok || fn == nil {
+		return nil, "", nil, fmt.Errorf("%w %q", ErrNoTransport, addr)
+	}
+	open, err := fn(path)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	opts := append(slices.Clip(n.opts), func(o *options) { o.network = scheme })
+	return open, scheme + ":" + path, opts, nil
+}
+
+// splitTransportAddr splits "/scheme/path" into its scheme and
+// "/path".
+func splitTransportAddr(addr string) (scheme, path string, ok bool) {
+	rest, ok := strings.CutPrefix(addr, "/")
+	if !ok {
+		return "", "", false
+	}
+	i := strings.IndexByte(rest, '/')
+	if i <= 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i:], true
+}
+
+// networkListener frees its address in the Network when closed.
+type networkListener struct {
+	*ReopenListener
+	n    *Network
+	addr string
+}
+
+func (l *networkListener) Close() error {
+	l.n.mu.Lock()
+	if l.n.listeners[l.addr] == l.ReopenListener {
+		delete(l.n.listeners, l.addr)
+	}
+	l.n.mu.Unlock()
+	return l.ReopenListener.Close()
+}
+
+// networkConn releases its dialer in the Network when closed.
+type networkConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *networkConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// Unwrap returns the dialer's conn.
+func (c *networkConn) Unwrap() net.Conn { return c.Conn }
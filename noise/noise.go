@@ -0,0 +1,288 @@
+// Package noise encrypts a turnstile session with the Noise protocol
+// framework, for devices too small to run TLS. It implements the XX
+// and IK handshakes (optionally with a pre-shared key) using X25519,
+// AES-GCM, and SHA-256, all from the standard library.
+//
+//	key, _ := noise.GenerateKey()
+//	l := turnstile.NewReopenListener(open, "/dev/ttyUSB0",
+//		turnstile.WithConnMiddleware(noise.ServerMiddleware(&noise.Config{
+//			Pattern:   noise.XX,
+//			StaticKey: key,
+//		})))
+//
+// Noise messages are framed on the wire with a two-byte big-endian
+// length, so the peer must speak Noise_XX_25519_AESGCM_SHA256 (or the
+// IK/psk variant configured) with that framing.
+package noise
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/sparques/turnstile"
+)
+
+var randReader = rand.Reader
+
+var (
+	// ErrHandshake is returned when a handshake message is malformed.
+	ErrHandshake = errors.New("noise: handshake failed")
+
+	// ErrDecrypt is returned when a message fails authentication.
+	ErrDecrypt = errors.New("noise: message authentication failed")
+
+	// ErrPeerKey is returned when the peer's static key isn't the one
+	// expected.
+	ErrPeerKey = errors.New("noise: unexpected peer static key")
+)
+
+// Pattern is a Noise handshake pattern.
+type Pattern int
+
+const (
+	// XX transmits both static keys during the handshake, so neither
+	// side needs to know the other's key in advance.
+	XX Pattern = iota
+
+	// IK requires the initiator to know the responder's static key,
+	// saving a round trip.
+	IK
+)
+
+// maxMessage is the largest Noise message, including the tag.
+const maxMessage = 65535
+
+// Config configures one side of a Noise session.
+type Config struct {
+	Pattern Pattern
+
+	// StaticKey is this side's long-term key. Required.
+	StaticKey *ecdh.PrivateKey
+
+	// PeerKey is the peer's static key. IK initiators need it; in
+	// every other case, if set, a peer presenting a different key is
+	// rejected with ErrPeerKey.
+	PeerKey *ecdh.PublicKey
+
+	// VerifyPeer, if set, is called with the peer's static key once
+	// the handshake completes; returning an error aborts the session.
+	VerifyPeer func(*ecdh.PublicKey) error
+
+	// PSK is an optional 32-byte pre-shared key mixed into the
+	// handshake (XXpsk3 or IKpsk2), so only holders of the PSK can
+	// connect at all.
+	PSK []byte
+
+	// Prologue is optional data both sides must agree on, such as a
+	// protocol version string.
+	Prologue []byte
+}
+
+// Conn is an encrypted net.Conn. The handshake runs on the first Read
+// or Write, or explicitly with Handshake.
+type Conn struct {
+	net.Conn
+	cfg       *Config
+	initiator bool
+
+	hmu     sync.Mutex
+	hsDone  bool
+	hsErr   error
+	peerKey *ecdh.PublicKey
+
+	rmu  sync.Mutex
+	recv *cipherState
+	rbuf []byte
+
+	wmu  sync.Mutex
+	send *cipherState
+}
+
+// Client returns a Conn that encrypts c, acting as the handshake
+// initiator.
+func Client(c net.Conn, cfg *Config) *Conn {
+	return &Conn{Conn: c, cfg: cfg, initiator: true}
+}
+
+// Server returns a Conn that encrypts c, acting as the handshake
+// responder.
+func Server(c net.Conn, cfg *Config) *Conn {
+	return &Conn{Conn: c, cfg: cfg}
+}
+
+// ClientMiddleware returns a turnstile.ConnMiddleware that wraps each
+// session with Client.
+func ClientMiddleware(cfg *Config) turnstile.ConnMiddleware {
+	return func(c net.Conn) net.Conn { return Client(c, cfg) }
+}
+
+// ServerMiddleware returns a turnstile.ConnMiddleware that wraps each
+// session with Server.
+func ServerMiddleware(cfg *Config) turnstile.ConnMiddleware {
+	return func(c net.Conn) net.Conn { return Server(c, cfg) }
+}
+
+// Handshake runs the handshake if it hasn't been run yet. A failed
+// handshake leaves the conn unusable.
+func (c *Conn) Handshake() error {
+	c.hmu.Lock()
+	defer c.hmu.Unlock()
+	if c.hsDone {
+		return c.hsErr
+	}
+	c.hsDone = true
+	c.hsErr = c.handshake()
+	return c.hsErr
+}
+
+func (c *Conn) handshake() error {
+	hs, err := newHandshakeState(c.cfg, c.initiator)
+	if err != nil {
+		return err
+	}
+	for i, tokens := range hs.msgs {
+		if (i%2 == 0) == c.initiator {
+			msg, err := hs.writeMessage(nil, tokens)
+			if err != nil {
+				return err
+			}
+			if err := writeFrame(c.Conn, msg); err != nil {
+				return err
+			}
+		} else {
+			msg, err := readFrame(c.Conn)
+			if err != nil {
+				return err
+			}
+			if err := hs.readMessage(msg, tokens); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.cfg.PeerKey != nil && !hs.rs.Equal(c.cfg.PeerKey) {
+		return ErrPeerKey
+	}
+	if c.cfg.VerifyPeer != nil {
+		if err := c.cfg.VerifyPeer(hs.rs); err != nil {
+			return err
+		}
+	}
+	c1, c2 := hs.ss.split()
+	if c.initiator {
+		c.send, c.recv = c1, c2
+	} else {
+		c.send, c.recv = c2, c1
+	}
+	c.peerKey = hs.rs
+	return nil
+}
+
+// PeerKey returns the peer's static key, or nil before the handshake
+// has completed.
+func (c *Conn) PeerKey() *ecdh.PublicKey {
+	c.hmu.Lock()
+	defer c.hmu.Unlock()
+	return c.peerKey
+}
+
+// Read reads and decrypts data from the conn.
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.rbuf) == 0 {
+		msg, err := readFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if c.rbuf, err = c.recv.decrypt(msg[:0], nil, msg); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// Write encrypts p and writes it to the conn, split into as many Noise
+// messages as needed.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var n int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxMessage-tagLen)]
+		msg, err := c.send.encrypt(nil, nil, chunk)
+		if err != nil {
+			return n, err
+		}
+		if err := writeFrame(c.Conn, msg); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Unwrap returns the conn being encrypted.
+func (c *Conn) Unwrap() net.Conn { return c.Conn }
+
+func writeFrame(w io.Writer, msg []byte) error {
+	b := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(msg)), uint16(len(msg)))
+	_, err := w.Write(append(b, msg...))
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// GenerateKey returns a new X25519 static key.
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(randReader)
+}
+
+// EncodeKey returns the base64 encoding of a private or public key's
+// bytes, as used by WireGuard and most Noise tooling.
+func EncodeKey(key interface{ Bytes() []byte }) string {
+	return base64.StdEncoding.EncodeToString(key.Bytes())
+}
+
+// ParsePrivateKey decodes a base64 private key made by EncodeKey.
+func ParsePrivateKey(s string) (*ecdh.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(b)
+}
+
+// ParsePublicKey decodes a base64 public key made by EncodeKey.
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
@@ -0,0 +1,428 @@
+package noise
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// Known-answer vectors for the suites this package speaks, from the
+// cacophony-format vectors.txt shipped with github.com/flynn/noise,
+// keeping those with empty handshake payloads. Every vector uses the
+// same static and ephemeral keys.
+const (
+	vecInitStatic = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	vecRespStatic = "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20"
+	vecInitEph    = "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f"
+	vecRespEph    = "4142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f60"
+	vecPSK        = "2176657279736563726574766572797365637265747665727973656372657421"
+	vecPrologue   = "6e6f74736563726574"
+
+	// The transport messages that follow each handshake: the first
+	// from initiator to responder, the second back.
+	vecPayload0 = "79656c6c6f777375626d6172696e65"
+	vecPayload1 = "7375626d6172696e6579656c6c6f77"
+)
+
+var vectors = []struct {
+	name     string
+	pattern  Pattern
+	psk      bool
+	prologue bool
+	msgs     []string // handshake messages, then transport messages
+}{
+	{
+		name:    "Noise_XX_25519_AESGCM_SHA256",
+		pattern: XX,
+		msgs: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd166254",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d484665393019dbd6f438795da206db0886610b26108e424142c2e9b5fd1f7ea70cde8767ce62d7e3c0e9bcefe4ab872c0505b9e824df091b74ffe10a2b32809cab21f",
+			"e610eadc4b00c17708bf223f29a66f02342fbedf6c0044736544b9271821ae40e70144cecd9d265dffdc5bb8e051c3f83db32a425e04d8f510c58a43325fbc56",
+			"9ea1da1ec3bfecfffab213e537ed1791bfa887dd9c631351b3f63d6315ab9a",
+			"217c5111fad7afde33bd28abaff3def88a57ab50515115d23a10f28621f842",
+		},
+	},
+	{
+		name:     "Noise_XX_25519_AESGCM_SHA256",
+		pattern:  XX,
+		prologue: true,
+		msgs: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd166254",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d484665393019dbd6f438795da206db0886610b26108e424142c2e9b5fd1f7ea70cde8545f22cc3b52e6cf83a9266ed4850a7a3460f29794110cc1e4c4b5241c939f90",
+			"e610eadc4b00c17708bf223f29a66f02342fbedf6c0044736544b9271821ae406561124920ea641646ea97786397ad23ab2f0dbf49fc3e46328b481b0924438c",
+			"9ea1da1ec3bfecfffab213e537ed1791bfa887dd9c631351b3f63d6315ab9a",
+			"217c5111fad7afde33bd28abaff3def88a57ab50515115d23a10f28621f842",
+		},
+	},
+	{
+		name:    "Noise_XXpsk3_25519_AESGCM_SHA256",
+		pattern: XX,
+		psk:     true,
+		msgs: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd166254e3443cc5cde4af71a33c6b56cbc00ea8",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d48466bb1259f77345353d70dcef1e97d161dd9c3324e72b46203ebe87dcb40159eb6683aae01b5e9a0d3b45f0cc22a1eba217aa52f541c089a733541cbace7919e264",
+			"a702c30239110afbb8afacb639f961e5c2574c3fe59ee6069c0f5f5414ea249379e58f0d514bb0f277ffe5ae6e6aecf9f4c58681a8586ac9878e6b9a086f4e40",
+			"187cadad4158250d0af49c2aea3bedc34aee2cc962336fbe649527ca78e48c",
+			"91de652b73884e25506003fe72969748b9092a4518be9c6e4911a52b60375f",
+		},
+	},
+	{
+		name:     "Noise_XXpsk3_25519_AESGCM_SHA256",
+		pattern:  XX,
+		psk:      true,
+		prologue: true,
+		msgs: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd1662545b2f4adfa73e9ba5320d7dad00152ab9",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d48466bb1259f77345353d70dcef1e97d161dd9c3324e72b46203ebe87dcb40159eb666198ef90bf6d0b1a0e76374ae604ac12c54156a8210758dea8c50d8720b4533f",
+			"a702c30239110afbb8afacb639f961e5c2574c3fe59ee6069c0f5f5414ea2493bf47ccd868daf2dc792d2a6493790f4a804d0508de91173260899064d056c042",
+			"187cadad4158250d0af49c2aea3bedc34aee2cc962336fbe649527ca78e48c",
+			"91de652b73884e25506003fe72969748b9092a4518be9c6e4911a52b60375f",
+		},
+	},
+	{
+		name:    "Noise_IK_25519_AESGCM_SHA256",
+		pattern: IK,
+		msgs: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd16625419d6fab175300a577115c701c41ed681373f0432f81d3bf8676bd05216cd1919ba2eaa418fdd8e09ae59d7cf57869de42789c3b9ca915c2cacf009f9d0e4436e",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d4846623c019a124da3f096e964fe624cf65db",
+			"80a75e75c8e8d2e9c2a6c7bc6e550c4997d6d2b45429a530821c4aa5d36f27",
+			"b8475410da62a98493d33a1e669f8f56dd8f61d449b53bd375299c3435424a",
+		},
+	},
+	{
+		name:     "Noise_IK_25519_AESGCM_SHA256",
+		pattern:  IK,
+		prologue: true,
+		msgs: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd16625419d6fab175300a577115c701c41ed681373f0432f81d3bf8676bd05216cd1919e61b75ccef0c0cf0b216fcdf371d0859ab50373f8c7b70a239f8cc8318e6075b",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d48466bb50a12b50b0b1b43fc6725181315302",
+			"80a75e75c8e8d2e9c2a6c7bc6e550c4997d6d2b45429a530821c4aa5d36f27",
+			"b8475410da62a98493d33a1e669f8f56dd8f61d449b53bd375299c3435424a",
+		},
+	},
+	{
+		name:    "Noise_IKpsk2_25519_AESGCM_SHA256",
+		pattern: IK,
+		psk:     true,
+		msgs: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd1662540322be5210eec7e84567f5b4ad376b908b7c38a587eb71776e0661a6ca9f3ef251962835db06e694781bcf163d3cb38dfe6ffdada1fcf40492123fd5eae6c0c9",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d48466f9d3c922ccf0a76efc90a08b4d23df61",
+			"c033f4a3312af700a5a655f6992bcad095ceb5af11b02027cecd87ef65738c",
+			"3363987af8578ae96cb358858a859ef8060129a05d85700d8a9c4955c599c1",
+		},
+	},
+	{
+		name:     "Noise_IKpsk2_25519_AESGCM_SHA256",
+		pattern:  IK,
+		psk:      true,
+		prologue: true,
+		msgs: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd1662540322be5210eec7e84567f5b4ad376b908b7c38a587eb71776e0661a6ca9f3ef2da7e079ebdd84739c3bce2764827999b754f95e803096d0591567119765f495e",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d48466848adcd10e9bc9826df50f4b6bc66b29",
+			"c033f4a3312af700a5a655f6992bcad095ceb5af11b02027cecd87ef65738c",
+			"3363987af8578ae96cb358858a859ef8060129a05d85700d8a9c4955c599c1",
+		},
+	},
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func mustKey(t *testing.T, s string) *ecdh.PrivateKey {
+	t.Helper()
+	k, err := ecdh.X25519().NewPrivateKey(mustHex(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		ini := &Config{Pattern: v.pattern, StaticKey: mustKey(t, vecInitStatic)}
+		resp := &Config{Pattern: v.pattern, StaticKey: mustKey(t, vecRespStatic)}
+		if v.pattern == IK {
+			ini.PeerKey = resp.StaticKey.PublicKey()
+		}
+		if v.psk {
+			ini.PSK = mustHex(t, vecPSK)
+			resp.PSK = ini.PSK
+		}
+		if v.prologue {
+			ini.Prologue = mustHex(t, vecPrologue)
+			resp.Prologue = ini.Prologue
+		}
+		hsI, err := newHandshakeState(ini, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hsR, err := newHandshakeState(resp, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ephI, ephR := mustKey(t, vecInitEph), mustKey(t, vecRespEph)
+		hsI.ephemeral = func() (*ecdh.PrivateKey, error) { return ephI, nil }
+		hsR.ephemeral = func() (*ecdh.PrivateKey, error) { return ephR, nil }
+
+		for i, tokens := range hsI.msgs {
+			w, r := hsI, hsR
+			if i%2 != 0 {
+				w, r = hsR, hsI
+			}
+			msg, err := w.writeMessage(nil, tokens)
+			if err != nil {
+				t.Fatalf("%s: message %d: %v", v.name, i, err)
+			}
+			if got := hex.EncodeToString(msg); got != v.msgs[i] {
+				t.Fatalf("%s: message %d is %s, want %s", v.name, i, got, v.msgs[i])
+			}
+			if err := r.readMessage(msg, tokens); err != nil {
+				t.Fatalf("%s: reading message %d: %v", v.name, i, err)
+			}
+		}
+
+		i1, i2 := hsI.ss.split()
+		r1, r2 := hsR.ss.split()
+		n := len(hsI.msgs)
+		for j, tc := range []struct {
+			enc, dec *cipherState
+			payload  string
+		}{
+			{i1, r1, vecPayload0},
+			{r2, i2, vecPayload1},
+		} {
+			msg, err := tc.enc.encrypt(nil, nil, mustHex(t, tc.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(msg); got != v.msgs[n+j] {
+				t.Fatalf("%s: transport message %d is %s, want %s", v.name, j, got, v.msgs[n+j])
+			}
+			pt, err := tc.dec.decrypt(nil, nil, msg)
+			if err != nil {
+				t.Fatalf("%s: decrypting transport message %d: %v", v.name, j, err)
+			}
+			if hex.EncodeToString(pt) != tc.payload {
+				t.Fatalf("%s: transport message %d decrypted to %x", v.name, j, pt)
+			}
+		}
+	}
+}
+
+// tapConn hands each Write, which for a Conn is one whole frame, to
+// tap once the tap is set.
+type tapConn struct {
+	net.Conn
+	tap func(frame []byte) [][]byte
+}
+
+func (c *tapConn) Write(p []byte) (int, error) {
+	if c.tap == nil {
+		return c.Conn.Write(p)
+	}
+	for _, f := range c.tap(bytes.Clone(p)) {
+		if _, err := c.Conn.Write(f); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// pair returns a connected client and server, the client writing
+// through tap.
+func pair(t *testing.T, ccfg, scfg *Config) (*Conn, *Conn, *tapConn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	tap := &tapConn{Conn: a}
+	return Client(tap, ccfg), Server(b, scfg), tap
+}
+
+// handshake runs the handshake on both c and s, returning both errors
+// joined. A side that fails closes its conn, so the other doesn't hang.
+func handshake(c, s *Conn) error {
+	run := func(c *Conn) error {
+		err := c.Handshake()
+		if err != nil {
+			c.Conn.Close()
+		}
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- run(s) }()
+	cerr := run(c)
+	return errors.Join(cerr, <-errc)
+}
+
+func keys(t *testing.T) (k1, k2 *ecdh.PrivateKey) {
+	t.Helper()
+	var err error
+	if k1, err = GenerateKey(); err != nil {
+		t.Fatal(err)
+	}
+	if k2, err = GenerateKey(); err != nil {
+		t.Fatal(err)
+	}
+	return k1, k2
+}
+
+func TestRoundTrip(t *testing.T) {
+	k1, k2 := keys(t)
+	psk := bytes.Repeat([]byte{7}, 32)
+	for _, tc := range []struct {
+		name       string
+		ccfg, scfg *Config
+	}{
+		{"XX", &Config{StaticKey: k1}, &Config{StaticKey: k2}},
+		{"XXpsk3", &Config{StaticKey: k1, PSK: psk}, &Config{StaticKey: k2, PSK: psk}},
+		// An empty PSK is no PSK.
+		{"XXEmptyPSK", &Config{StaticKey: k1, PSK: []byte{}}, &Config{StaticKey: k2}},
+		{"IK", &Config{Pattern: IK, StaticKey: k1, PeerKey: k2.PublicKey()}, &Config{Pattern: IK, StaticKey: k2}},
+		{"IKpsk2", &Config{Pattern: IK, StaticKey: k1, PeerKey: k2.PublicKey(), PSK: psk}, &Config{Pattern: IK, StaticKey: k2, PSK: psk}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, s, _ := pair(t, tc.ccfg, tc.scfg)
+			// More than one Noise message's worth each way.
+			data := make([]byte, 3*maxMessage)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			errc := make(chan error, 1)
+			go func() {
+				buf := make([]byte, len(data))
+				_, err := io.ReadFull(s, buf)
+				if err == nil {
+					_, err = s.Write(buf)
+				}
+				errc <- err
+			}()
+			if _, err := c.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, len(data))
+			if _, err := io.ReadFull(c, got); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("echo differs from what was sent")
+			}
+			if !c.PeerKey().Equal(k2.PublicKey()) || !s.PeerKey().Equal(k1.PublicKey()) {
+				t.Fatal("wrong peer keys")
+			}
+		})
+	}
+}
+
+func TestHandshakeRejects(t *testing.T) {
+	k1, k2 := keys(t)
+	psk := bytes.Repeat([]byte{7}, 32)
+	other := bytes.Repeat([]byte{8}, 32)
+	for _, tc := range []struct {
+		name       string
+		ccfg, scfg *Config
+		want       error
+	}{
+		{"PSK", &Config{StaticKey: k1, PSK: psk}, &Config{StaticKey: k2, PSK: other}, ErrDecrypt},
+		{"Prologue", &Config{StaticKey: k1, Prologue: []byte("v1")}, &Config{StaticKey: k2, Prologue: []byte("v2")}, ErrDecrypt},
+		{"PeerKey", &Config{StaticKey: k1, PeerKey: k1.PublicKey()}, &Config{StaticKey: k2}, ErrPeerKey},
+		{"IKPeerKey", &Config{Pattern: IK, StaticKey: k1, PeerKey: k1.PublicKey()}, &Config{Pattern: IK, StaticKey: k2}, ErrDecrypt},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, s, _ := pair(t, tc.ccfg, tc.scfg)
+			if err := handshake(c, s); !errors.Is(err, tc.want) {
+				t.Fatalf("handshake: %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestTamperedHandshake(t *testing.T) {
+	k1, k2 := keys(t)
+	c, s, tap := pair(t, &Config{StaticKey: k1}, &Config{StaticKey: k2})
+	// Flip a bit of the client's second frame, the third message of the
+	// handshake, carrying its static key.
+	var n int
+	tap.tap = func(f []byte) [][]byte {
+		if n++; n == 2 {
+			f[len(f)-1] ^= 1
+		}
+		return [][]byte{f}
+	}
+	if err := handshake(c, s); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("handshake: %v, want ErrDecrypt", err)
+	}
+}
+
+func TestTransportRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		tap  func(f []byte) [][]byte
+	}{
+		{"Tamper", func(f []byte) [][]byte {
+			f[2] ^= 1
+			return [][]byte{f}
+		}},
+		{"Replay", func(f []byte) [][]byte { return [][]byte{f, f} }},
+		{"Reorder", func() func(f []byte) [][]byte {
+			var held []byte
+			return func(f []byte) [][]byte {
+				if held == nil {
+					held = f
+					return nil
+				}
+				return [][]byte{f, held}
+			}
+		}()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			k1, k2 := keys(t)
+			c, s, tap := pair(t, &Config{StaticKey: k1}, &Config{StaticKey: k2})
+			if err := handshake(c, s); err != nil {
+				t.Fatal(err)
+			}
+			tap.tap = tc.tap
+			errc := make(chan error, 1)
+			go func() {
+				var err error
+				for _, m := range []string{"one", "two"} {
+					if _, err = c.Write([]byte(m)); err != nil {
+						break
+					}
+				}
+				errc <- err
+			}()
+			buf := make([]byte, 16)
+			var err error
+			for range 2 {
+				var n int
+				if n, err = s.Read(buf); err != nil {
+					break
+				}
+				if m := string(buf[:n]); m != "one" {
+					t.Fatalf("read %q", m)
+				}
+			}
+			if !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("read: %v, want ErrDecrypt", err)
+			}
+			s.Conn.Close()
+			<-errc
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package noise
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	dhLen   = 32
+	hashLen = sha256.Size
+	tagLen  = 16
+)
+
+var errNonceExhausted = errors.New("noise: nonce exhausted")
+
+// cipherState is the Noise CipherState for AESGCM.
+type cipherState struct {
+	aead cipher.AEAD // nil until a key is set
+	n    uint64
+}
+
+func (c *cipherState) setKey(k []byte) {
+	block, _ := aes.NewCipher(k[:32])
+	c.aead, _ = cipher.NewGCM(block)
+	c.n = 0
+}
+
+func (c *cipherState) hasKey() bool { return c.aead != nil }
+
+// nonce encodes n as AESGCM wants it: 32 zero bits, then n big-endian.
+func (c *cipherState) nonce() []byte {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[4:], c.n)
+	return b[:]
+}
+
+func (c *cipherState) encrypt(dst, ad, plaintext []byte) ([]byte, error) {
+	if !c.hasKey() {
+		return append(dst, plaintext...), nil
+	}
+	if c.n == math.MaxUint64 {
+		return nil, errNonceExhausted
+	}
+	out := c.aead.Seal(dst, c.nonce(), plaintext, ad)
+	c.n++
+	return out, nil
+}
+
+func (c *cipherState) decrypt(dst, ad, ciphertext []byte) ([]byte, error) {
+	if !c.hasKey() {
+		return append(dst, ciphertext...), nil
+	}
+	if c.n == math.MaxUint64 {
+		return nil, errNonceExhausted
+	}
+	out, err := c.aead.Open(dst, c.nonce(), ciphertext, ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	c.n++
+	return out, nil
+}
+
+// symmetricState is the Noise SymmetricState for SHA256.
+type symmetricState struct {
+	cs cipherState
+	ck [hashLen]byte
+	h  [hashLen]byte
+}
+
+func newSymmetricState(protocol string) *symmetricState {
+	s := new(symmetricState)
+	if len(protocol) <= hashLen {
+		copy(s.h[:], protocol)
+	} else {
+		s.h = sha256.Sum256([]byte(protocol))
+	}
+	s.ck = s.h
+	return s
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h[:])
+	h.Write(data)
+	h.Sum(s.h[:0])
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	ck, k, _ := hkdf(s.ck[:], ikm, 2)
+	copy(s.ck[:], ck)
+	s.cs.setKey(k)
+}
+
+func (s *symmetricState) mixKeyAndHash(ikm []byte) {
+	ck, h, k := hkdf(s.ck[:], ikm, 3)
+	copy(s.ck[:], ck)
+	s.mixHash(h)
+	s.cs.setKey(k)
+}
+
+func (s *symmetricState) encryptAndHash(dst, plaintext []byte) ([]byte, error) {
+	out, err := s.cs.encrypt(dst, s.h[:], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(out[len(dst):])
+	return out, nil
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	out, err := s.cs.decrypt(nil, s.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return out, nil
+}
+
+// split returns the initiator-to-responder and responder-to-initiator
+// transport ciphers.
+func (s *symmetricState) split() (c1, c2 *cipherState) {
+	k1, k2, _ := hkdf(s.ck[:], nil, 2)
+	c1, c2 = new(cipherState), new(cipherState)
+	c1.setKey(k1)
+	c2.setKey(k2)
+	return c1, c2
+}
+
+// hkdf is the HKDF function of the Noise spec, returning n (2 or 3)
+// outputs.
+func hkdf(ck, ikm []byte, n int) (out1, out2, out3 []byte) {
+	mac := func(key []byte, data ...[]byte) []byte {
+		m := hmac.New(sha256.New, key)
+		for _, d := range data {
+			m.Write(d)
+		}
+		return m.Sum(nil)
+	}
+	temp := mac(ck, ikm)
+	out1 = mac(temp, []byte{1})
+	out2 = mac(temp, out1, []byte{2})
+	if n == 3 {
+		out3 = mac(temp, out2, []byte{3})
+	}
+	return out1, out2, out3
+}
+
+// Tokens of a handshake pattern.
+type token int
+
+const (
+	tokE token = iota
+	tokS
+	tokEE
+	tokES
+	tokSE
+	tokSS
+	tokPSK
+)
+
+// patterns holds the message patterns, alternating between initiator
+// and responder starting with the initiator, and whether the
+// responder's static key is a pre-message.
+var patterns = map[Pattern]struct {
+	name        string
+	preResponse bool
+	msgs        [][]token
+	pskMsg      int // message a PSK is appended to
+}{
+	XX: {
+		name: "XX",
+		msgs: [][]token{
+			{tokE},
+			{tokE, tokEE, tokS, tokES},
+			{tokS, tokSE},
+		},
+		pskMsg: 2,
+	},
+	IK: {
+		name:        "IK",
+		preResponse: true,
+		msgs: [][]token{
+			{tokE, tokES, tokS, tokSS},
+			{tokE, tokEE, tokSE},
+		},
+		pskMsg: 1,
+	},
+}
+
+// handshakeState is the Noise HandshakeState.
+type handshakeState struct {
+	ss        *symmetricState
+	initiator bool
+	psk       []byte
+	msgs      [][]token
+
+	s  *ecdh.PrivateKey
+	e  *ecdh.PrivateKey
+	rs *ecdh.PublicKey
+	re *ecdh.PublicKey
+
+	// ephemeral generates e; tests swap it for fixed keys.
+	ephemeral func() (*ecdh.PrivateKey, error)
+}
+
+func newHandshakeState(cfg *Config, initiator bool) (*handshakeState, error) {
+	p, ok := patterns[cfg.Pattern]
+	if !ok {
+		return nil, errors.New("noise: unknown pattern")
+	}
+	if cfg.StaticKey == nil {
+		return nil, errors.New("noise: no static key")
+	}
+	if len(cfg.PSK) != 0 && len(cfg.PSK) != 32 {
+		return nil, errors.New("noise: PSK must be 32 bytes")
+	}
+	msgs := make([][]token, len(p.msgs))
+	copy(msgs, p.msgs)
+	name := p.name
+	if len(cfg.PSK) != 0 {
+		msgs[p.pskMsg] = append(append([]token(nil), msgs[p.pskMsg]...), tokPSK)
+		name += "psk" + string(rune('0'+p.pskMsg+1))
+	}
+
+	hs := &handshakeState{
+		ss:        newSymmetricState("Noise_" + name + "_25519_AESGCM_SHA256"),
+		initiator: initiator,
+		psk:       cfg.PSK,
+		msgs:      msgs,
+		s:         cfg.StaticKey,
+		ephemeral: GenerateKey,
+	}
+	hs.ss.mixHash(cfg.Prologue)
+	if p.preResponse {
+		if initiator {
+			if cfg.PeerKey == nil {
+				return nil, errors.New("noise: IK initiator needs the responder's PeerKey")
+			}
+			hs.rs = cfg.PeerKey
+			hs.ss.mixHash(hs.rs.Bytes())
+		} else {
+			hs.ss.mixHash(hs.s.PublicKey().Bytes())
+		}
+	}
+	return hs, nil
+}
+
+// writeMessage appends the next handshake message to dst.
+func (hs *handshakeState) writeMessage(dst []byte, tokens []token) ([]byte, error) {
+	var err error
+	for _, t := range tokens {
+		switch t {
+		case tokE:
+			if hs.e, err = hs.ephemeral(); err != nil {
+				return nil, err
+			}
+			pub := hs.e.PublicKey().Bytes()
+			dst = append(dst, pub...)
+			hs.ss.mixHash(pub)
+			if len(hs.psk) > 0 {
+				hs.ss.mixKey(pub)
+			}
+		case tokS:
+			if dst, err = hs.ss.encryptAndHash(dst, hs.s.PublicKey().Bytes()); err != nil {
+				return nil, err
+			}
+		case tokPSK:
+			hs.ss.mixKeyAndHash(hs.psk)
+		default:
+			if err := hs.mixDH(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Empty payload.
+	return hs.ss.encryptAndHash(dst, nil)
+}
+
+// readMessage consumes a handshake message.
+func (hs *handshakeState) readMessage(msg []byte, tokens []token) error {
+	for _, t := range tokens {
+		switch t {
+		case tokE:
+			if len(msg) < dhLen {
+				return ErrHandshake
+			}
+			re, err := ecdh.X25519().NewPublicKey(msg[:dhLen])
+			if err != nil {
+				return ErrHandshake
+			}
+			hs.re = re
+			hs.ss.mixHash(msg[:dhLen])
+			if len(hs.psk) > 0 {
+				hs.ss.mixKey(msg[:dhLen])
+			}
+			msg = msg[dhLen:]
+		case tokS:
+			n := dhLen
+			if hs.ss.cs.hasKey() {
+				n += tagLen
+			}
+			if len(msg) < n {
+				return ErrHandshake
+			}
+			pub, err := hs.ss.decryptAndHash(msg[:n])
+			if err != nil {
+				return err
+			}
+			if hs.rs, err = ecdh.X25519().NewPublicKey(pub); err != nil {
+				return ErrHandshake
+			}
+			msg = msg[n:]
+		case tokPSK:
+			hs.ss.mixKeyAndHash(hs.psk)
+		default:
+			if err := hs.mixDH(t); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := hs.ss.decryptAndHash(msg)
+	return err
+}
+
+// mixDH performs the DH named by t, from our side of the handshake.
+func (hs *handshakeState) mixDH(t token) error {
+	var priv *ecdh.PrivateKey
+	var pub *ecdh.PublicKey
+	switch t {
+	case tokEE:
+		priv, pub = hs.e, hs.re
+	case tokSS:
+		priv, pub = hs.s, hs.rs
+	case tokES:
+		if hs.initiator {
+			priv, pub = hs.e, hs.rs
+		} else {
+			priv, pub = hs.s, hs.re
+		}
+	case tokSE:
+		if hs.initiator {
+			priv, pub = hs.s, hs.re
+		} else {
+			priv, pub = hs.e, hs.rs
+		}
+	}
+	if priv == nil || pub == nil {
+		return ErrHandshake
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return ErrHandshake
+	}
+	hs.ss.mixKey(shared)
+	return nil
+}
@@ -0,0 +1,122 @@
+package turnstile
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+)
+
+// WithAcceptOnData makes a listener hand out a session only once the
+// device has sent something, rather than as soon as it opens, so a
+// device that is powered off but whose tty opens fine doesn't start a
+// session, e.g. an HTTP exchange, that goes nowhere. With no pattern
+// the first byte will do, and is passed on to the session. With a
+// pattern, Accept waits until the device sends it; anything before it
+// is discarded, and the session's first Read starts with the pattern.
+// A device whose Read fails while waiting is closed and reopened with
+// the usual backoff. Dialers ignore this option.
+func WithAcceptOnData(pattern []byte) Option {
+	return func(o *options) {
+		o.acceptOnData = true
+		o.dataPattern = bytes.Clone(pattern)
+	}
+}
+
+// waitData blocks until rwc has sent data matching the pattern set
+// WithAcceptOnData, and returns an RWC whose reads start with it. On
+// failure, rwc is closed.
+func (c *core) waitData(ctx context.Context, rwc io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	pattern := c.opts.dataPattern
+	back := c.takeStray()
+	seen, ch := back.b, back.pending
+	for {
+		if ch == nil {
+			ch = readAsync(rwc)
+		}
+		select {
+		case r := <-ch:
+			ch = nil
+			seen = append(seen, r.b...)
+			if len(pattern) == 0 && len(seen) > 0 {
+				return &prefixRWC{ReadWriteCloser: rwc, back: putBack{b: seen, err: r.err}}, nil
+			}
+			if i := bytes.Index(seen, pattern); len(pattern) > 0 && i >= 0 {
+				return &prefixRWC{ReadWriteCloser: rwc, back: putBack{b: seen[i:], err: r.err}}, nil
+			}
+			if r.err != nil {
+				c.policy.closeRWC(rwc)
+				return nil, r.err
+			}
+			// Keep only what could be the start of the pattern.
+			if k := len(pattern) - 1; len(seen) > k {
+				seen = append(seen[:0], seen[len(seen)-k:]...)
+			}
+		case <-ctx.Done():
+			c.abandon(rwc, putBack{b: seen, pending: ch})
+			return nil, ctx.Err()
+		case <-c.gate.done:
+			c.abandon(rwc, putBack{b: seen, pending: ch})
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+// readAsync starts a read of r, delivering the result on the channel.
+func readAsync(r io.Reader) <-chan readResult {
+	ch := make(chan readResult, 1)
+	go func() {
+		b := make([]byte, 512)
+		n, err := r.Read(b)
+		ch <- readResult{b[:n], err}
+	}()
+	return ch
+}
+
+// abandon closes rwc, given up on with pb read from it or still being
+// read. Closing a device interrupts its read, but closing the shared
+// stream of NewReadWriterListener or NewReadWriterDialer doesn't, so
+// there pb is kept for the next open to pick up with takeStray rather
+// than let the read steal the start of the next session.
+func (c *core) abandon(rwc io.ReadWriteCloser, pb putBack) {
+	if _, ok := unwrapTo[rwNilCloser](rwc); ok {
+		c.smu.Lock()
+		c.stray = pb
+		c.smu.Unlock()
+	}
+	c.policy.closeRWC(rwc)
+}
+
+// takeStray returns what abandon kept, if anything.
+func (c *core) takeStray() putBack {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	pb := c.stray
+	c.stray = putBack{}
+	return pb
+}
+
+// prefixRWC returns input put back in front of the RWC from Read
+// before reading from the RWC itself.
+type prefixRWC struct {
+	io.ReadWriteCloser
+	back putBack
+}
+
+func (p *prefixRWC) Read(b []byte) (int, error) {
+	if !p.back.empty() {
+		return p.back.read(b)
+	}
+	return p.ReadWriteCloser.Read(b)
+}
+
+func (p *prefixRWC) unread(pb putBack) { p.back = pb }
+
+func (p *prefixRWC) reclaim() (putBack, io.Reader) {
+	pb := p.back
+	p.back = putBack{}
+	return pb, p.ReadWriteCloser
+}
+
+// Unwrap returns the underlying RWC.
+func (p *prefixRWC) Unwrap() io.ReadWriteCloser { return p.ReadWriteCloser }
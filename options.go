@@ -0,0 +1,386 @@
+package turnstile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// Option configures a ReopenListener or ReopenDialer. Options that
+// only make sense on one side are ignored by the other.
+type Option func(*options)
+
+type options struct {
+	preempt      bool
+	preemptGrace time.Duration
+
+	resetRequired bool
+	maxSessions   int
+	maxLifetime   time.Duration
+	cooldown      time.Duration
+	reopenDelay   time.Duration
+	healthCheck   func(io.ReadWriter) error
+
+	serializeWrites bool
+	readTimeout     time.Duration
+	strictDeadlines bool
+	watchdog        *watchdog
+
+	reuseActive bool
+	eager       bool
+	allowAddrs  []string
+
+	acceptTimeout time.Duration
+	acceptOnData  bool
+	dataPattern   []byte
+
+	sessionToken bool
+	tokenTimeout time.Duration
+
+	writeQueue int
+
+	network string // overrides the network name from RegisterNetwork
+
+	pauseErr bool
+	failFast bool
+	retry    RetryDecider
+	jitter   Jitter
+
+	rawTerminal bool
+	connContext func(context.Context, *Conn) context.Context
+
+	drain        bool
+	drainTimeout time.Duration
+
+	onClose func(SessionSummary)
+	history int
+	tracer  Tracer
+	journal *Journal
+	health  *healthTracker
+
+	middleware  []ConnMiddleware
+	interceptor []AcceptInterceptor
+	filter      AcceptFilter
+	acceptErrs  AcceptErrorPolicy
+	serveErrs   func(net.Conn, error)
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithPreemption allows a dial with a higher priority (see
+// ReopenDialer.DialPriority) to close the active conn of a
+// lower-priority caller. The active conn is given grace to finish
+// up before it is closed.
+func WithPreemption(grace time.Duration) Option {
+	return func(o *options) {
+		o.preempt = true
+		o.preemptGrace = grace
+	}
+}
+
+// WithResetRequired makes a listener hand out one session and then
+// block further Accept calls until its Reset method is called. This is
+// mostly useful with NewReadWriterListener, where a new session would
+// otherwise reuse a stream that may already be at EOF, sending servers
+// into a hot Accept loop.
+func WithResetRequired() Option {
+	return func(o *options) {
+		o.resetRequired = true
+	}
+}
+
+// WithMaxSessions limits a listener or dialer to n sessions. After the
+// nth conn is closed it closes itself and Accept/Dial return
+// net.ErrClosed. Zero, the default, means no limit.
+func WithMaxSessions(n int) Option {
+	return func(o *options) {
+		o.maxSessions = n
+	}
+}
+
+// WithMaxLifetime limits how long a listener or dialer stays usable,
+// counted from its creation. When d has passed the active conn, if
+// any, is closed and Accept/Dial return net.ErrClosed.
+func WithMaxLifetime(d time.Duration) Option {
+	return func(o *options) {
+		o.maxLifetime = d
+	}
+}
+
+// WithCooldown makes Accept/Dial wait until d has passed since the
+// previous session ended before starting a new one, e.g. to let a
+// modem settle after hanging up.
+func WithCooldown(d time.Duration) Option {
+	return func(o *options) {
+		o.cooldown = d
+	}
+}
+
+// WithReopenDelay enforces a minimum delay between the underlying RWC
+// being closed and the next call to the OpenFunc, whether or not the
+// previous open succeeded. USB-serial adapters and modems often need
+// this settle time before they can be opened again.
+func WithReopenDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.reopenDelay = d
+	}
+}
+
+// WithHealthCheck runs check on every freshly opened RWC before it is
+// handed out, e.g. to send an AT or ENQ probe and wait for the reply.
+// If check returns an error the RWC is closed and the open is retried
+// with the usual backoff. The check can record what it learns for the
+// conn with SetValue.
+func WithHealthCheck(check func(io.ReadWriter) error) Option {
+	return func(o *options) {
+		o.healthCheck = check
+	}
+}
+
+// WithSerializedWrites guards each conn's Write with a mutex and writes
+// every call out in full before starting the next, so goroutines
+// writing concurrently (say, heartbeats alongside data) can't corrupt
+// each other's messages on the wire.
+func WithSerializedWrites() Option {
+	return func(o *options) {
+		o.serializeWrites = true
+	}
+}
+
+// WithReadTimeout makes a conn's Read give up after d without data,
+// returning os.ErrDeadlineExceeded (a net.Error with Timeout() true)
+// instead of blocking forever. The underlying read is kept and its
+// data delivered by the next Read, so poll-style protocol loops can
+// simply retry.
+func WithReadTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.readTimeout = d
+	}
+}
+
+// WithPauseError makes Accept/Dial fail with ErrPaused while paused,
+// rather than blocking until Resume.
+func WithPauseError() Option {
+	return func(o *options) {
+		o.pauseErr = true
+	}
+}
+
+// WithFailFast makes Accept/Dial return the error from the OpenFunc
+// if the very first open fails, instead of retrying, so a
+// misconfiguration such as a wrong device path shows up right away.
+// Once an open has succeeded, failed reopens are retried with backoff
+// as usual.
+func WithFailFast() Option {
+	return func(o *options) {
+		o.failFast = true
+	}
+}
+
+// RetryDecider decides what to do after the OpenFunc fails with err:
+// retry after delay, or give up and return err from Accept/Dial. A
+// delay of zero or less means the usual backoff.
+type RetryDecider func(err error) (retry bool, delay time.Duration)
+
+// WithRetryDecider consults decide after every failed open, so
+// permanent errors can be reported instead of retried forever. See
+// RetryTransient.
+func WithRetryDecider(decide RetryDecider) Option {
+	return func(o *options) {
+		o.retry = decide
+	}
+}
+
+// RetryTransient is a RetryDecider that gives up on errors that won't
+// fix themselves, a missing device (fs.ErrNotExist, e.g. ENOENT) or
+// lacking permission (fs.ErrPermission, e.g. EACCES), and retries
+// everything else, such as EBUSY or EIO, with the usual backoff.
+func RetryTransient(err error) (bool, time.Duration) {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return false, 0
+	}
+	return true, 0
+}
+
+// Jitter selects how the delay between open retries is randomized.
+type Jitter int
+
+const (
+	// NoJitter uses the plain exponential backoff, 100ms doubling up
+	// to 2s.
+	NoJitter Jitter = iota
+
+	// FullJitter waits a random time between zero and the backoff.
+	FullJitter
+
+	// EqualJitter waits half the backoff plus a random time up to the
+	// other half.
+	EqualJitter
+)
+
+// WithJitter randomizes the backoff between open retries, so many
+// dialers pointed at the same serial server don't all retry in
+// lockstep after it comes back. Delays chosen by a RetryDecider are
+// used as they are.
+func WithJitter(j Jitter) Option {
+	return func(o *options) {
+		o.jitter = j
+	}
+}
+
+// apply returns the delay to wait for backoff d.
+func (j Jitter) apply(d time.Duration) time.Duration {
+	switch j {
+	case FullJitter:
+		return rand.N(d)
+	case EqualJitter:
+		return d/2 + rand.N(d/2)
+	}
+	return d
+}
+
+// WithDrainOnClose makes a conn's Close flush and drain the underlying
+// RWC before closing it, so a final response written just before Close
+// isn't cut off. An RWC that buffers writes is flushed if it has a
+// Flush() error method, and one implementing Drainer is then drained,
+// waiting at most timeout (zero means no limit) for the transmit
+// buffer to empty.
+func WithDrainOnClose(timeout time.Duration) Option {
+	return func(o *options) {
+		o.drain = true
+		o.drainTimeout = timeout
+	}
+}
+
+// ConnMiddleware wraps a conn handed out by Accept or Dial, e.g. to add
+// logging, rate limiting, framing, compression, or TLS. The conn it
+// returns must close the conn it was given when closed, or the slot is
+// never released.
+type ConnMiddleware func(net.Conn) net.Conn
+
+// WithConnMiddleware wraps every conn handed out by Accept/Dial in mw.
+// Middleware is applied in order, so the first one wraps the *Conn
+// directly and the last one is outermost. Repeated uses append to the
+// chain. Note that once wrapped, the result no longer type-asserts to
+// *Conn; middleware that needs Value or Unwrap should keep a reference
+// to the conn it was given.
+func WithConnMiddleware(mw ...ConnMiddleware) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, mw...)
+	}
+}
+
+// AcceptInterceptor inspects a session before Accept returns it, e.g.
+// to authenticate the peer or account for the session. It sees the bare
+// *Conn, before any ConnMiddleware, and may read and write it. Returning
+// an error vetoes the session: the listener closes the conn and goes
+// back to waiting for the next one instead of returning.
+type AcceptInterceptor func(*Conn) error
+
+// WithAcceptInterceptor runs fns, in order, on every session a listener
+// accepts; the first error vetoes it. A vetoed session still counts
+// towards WithMaxSessions and is followed by any cool-down. Dialers
+// ignore this option.
+func WithAcceptInterceptor(fns ...AcceptInterceptor) Option {
+	return func(o *options) {
+		o.interceptor = append(o.interceptor, fns...)
+	}
+}
+
+// PeerInfo describes the far end of a session whose OpenFunc connects
+// to a remote endpoint, such as a TCP-backed serial server or an
+// rfc2217.Client. The addresses come from the RWC's own LocalAddr and
+// RemoteAddr methods, or those of an RWC it unwraps to, and are nil for
+// a local device.
+type PeerInfo struct {
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+}
+
+// AcceptFilter decides whether a listener should accept a session
+// from peer.
+type AcceptFilter func(peer PeerInfo) bool
+
+var errFiltered = errors.New("turnstile: rejected by accept filter")
+
+// WithAcceptFilter makes a listener check every session with f before
+// anything else sees it; sessions f rejects are closed, and Accept
+// goes on waiting as for a vetoed session (see WithAcceptInterceptor).
+// Dialers ignore this option.
+func WithAcceptFilter(f AcceptFilter) Option {
+	return func(o *options) {
+		o.filter = f
+	}
+}
+
+// WithAcceptTimeout makes Accept and AcceptContext give up if no
+// session has started within d, failing with a timeout error wrapping
+// os.ErrDeadlineExceeded, so a supervisory loop can check on other
+// things between tries. The error is temporary under the
+// DefaultAcceptErrorPolicy, so http.Server and Serve just try again.
+// Dialers ignore this option.
+func WithAcceptTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.acceptTimeout = d
+	}
+}
+
+// WithServeErrorHandler has ReopenListener.Serve pass each error a
+// handler returns to fn, along with the conn, now closed, that it was
+// handling; e.g. to log it. Dialers ignore this option.
+func WithServeErrorHandler(fn func(c net.Conn, err error)) Option {
+	return func(o *options) {
+		o.serveErrs = fn
+	}
+}
+
+// peerInfo describes c's far end for an AcceptFilter.
+func peerInfo(c *Conn) PeerInfo {
+	var p PeerInfo
+	if a, ok := device[interface{ LocalAddr() net.Addr }](c); ok {
+		p.LocalAddr = a.LocalAddr()
+	}
+	if a, ok := device[interface{ RemoteAddr() net.Addr }](c); ok {
+		p.RemoteAddr = a.RemoteAddr()
+	}
+	return p
+}
+
+// intercept runs the configured interceptors on c, stopping at the
+// first veto. The accept filter, if any, goes first.
+func (o options) intercept(c *Conn) error {
+	if o.filter != nil && !o.filter(peerInfo(c)) {
+		return errFiltered
+	}
+	for _, fn := range o.interceptor {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrap applies the configured middleware to c.
+func (o options) wrap(c net.Conn) net.Conn {
+	for _, mw := range o.middleware {
+		c = mw(c)
+	}
+	return c
+}
+
+func (o options) newGate() *gate {
+	g := newGate()
+	g.preempt = o.preempt
+	g.preemptGrace = o.preemptGrace
+	return g
+}
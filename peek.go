@@ -0,0 +1,41 @@
+package turnstile
+
+import (
+	"bufio"
+	"net"
+)
+
+// PeekableConn is a net.Conn whose input can be inspected before it is
+// consumed, so a server can tell which protocol a peer speaks (HTTP, a
+// Modbus frame, a bootloader's sync byte) and hand the conn to the
+// matching handler with nothing lost.
+type PeekableConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewPeekableConn wraps c so its input can be peeked at. Up to size
+// bytes can be peeked at once; size defaults to 4096 if it is 0.
+func NewPeekableConn(c net.Conn, size int) *PeekableConn {
+	if size <= 0 {
+		size = 4096
+	}
+	return &PeekableConn{Conn: c, r: bufio.NewReaderSize(c, size)}
+}
+
+// Peek returns the next n bytes without consuming them, waiting until
+// that many have arrived. If fewer than n bytes are returned, the error
+// says why, e.g. bufio.ErrBufferFull if n is larger than the buffer, or
+// a timeout from a conn created WithReadTimeout; the bytes that did
+// arrive stay buffered either way.
+func (c *PeekableConn) Peek(n int) ([]byte, error) { return c.r.Peek(n) }
+
+// Buffered returns how many bytes have been read from the conn but not
+// yet consumed, i.e. can be peeked at without waiting.
+func (c *PeekableConn) Buffered() int { return c.r.Buffered() }
+
+// Read reads the buffered input first, then from the conn.
+func (c *PeekableConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// Unwrap returns the conn c wraps.
+func (c *PeekableConn) Unwrap() net.Conn { return c.Conn }
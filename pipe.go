@@ -0,0 +1,190 @@
+package turnstile
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Speed describes the timing of a serial line for Pipe: characters of
+// DataBits bits (default 8), plus a start bit, an optional parity bit,
+// and StopBits stop bits (default 1), sent at Baud bits per second.
+// The zero Speed has no delay at all.
+type Speed struct {
+	Baud     int
+	DataBits int
+	Parity   bool
+	StopBits int
+}
+
+// charTime is how long one character takes on the wire.
+func (s Speed) charTime() time.Duration {
+	if s.Baud <= 0 {
+		return 0
+	}
+	bits := 1 + s.DataBits + s.StopBits
+	if s.DataBits <= 0 {
+		bits += 8
+	}
+	if s.StopBits <= 0 {
+		bits++
+	}
+	if s.Parity {
+		bits++
+	}
+	return time.Duration(bits) * time.Second / time.Duration(s.Baud)
+}
+
+// Pipe returns the two ends of an in-memory serial link running at s,
+// for testing protocol code without hardware. Like a UART, Write
+// returns at once, and the bytes become readable at the other end one
+// character time apart, so 9600 8N1 delivers about 960 bytes a second
+// in each direction and slow-link timeouts show up in tests. Each end
+// implements Drainer, waiting for its writes to have been sent, and
+// has a BaudRate method. Closing an end makes the other end's Reads
+// return io.EOF once the data in flight has arrived, and its Writes
+// fail.
+func Pipe(s Speed) (a, b io.ReadWriteCloser) {
+	ct := s.charTime()
+	ab := newPipeHalf(ct)
+	ba := newPipeHalf(ct)
+	return &pipeEnd{in: ba, out: ab, baud: s.Baud}, &pipeEnd{in: ab, out: ba, baud: s.Baud}
+}
+
+type pipeEnd struct {
+	in, out *pipeHalf
+	baud    int
+}
+
+func (e *pipeEnd) Read(p []byte) (int, error)  { return e.in.read(p) }
+func (e *pipeEnd) Write(p []byte) (int, error) { return e.out.write(p) }
+func (e *pipeEnd) Drain() error                { return e.out.drain() }
+func (e *pipeEnd) BaudRate() int               { return e.baud }
+
+func (e *pipeEnd) Close() error {
+	e.out.closeWrite()
+	e.in.closeRead()
+	return nil
+}
+
+// pipeHalf is one direction of a Pipe.
+type pipeHalf struct {
+	ct   time.Duration
+	wake chan struct{} // signalled when segs or the closed flags change
+
+	mu        sync.Mutex
+	segs      []pipeSeg
+	busyUntil time.Time // when the last byte written will have arrived
+	wclosed   bool      // the writing end was closed
+	rclosed   bool      // the reading end was closed
+}
+
+// pipeSeg is a run of written bytes, the first of which starts on the
+// wire at start.
+type pipeSeg struct {
+	b     []byte
+	start time.Time
+}
+
+func newPipeHalf(ct time.Duration) *pipeHalf {
+	return &pipeHalf{ct: ct, wake: make(chan struct{}, 1)}
+}
+
+func (h *pipeHalf) signal() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (h *pipeHalf) write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.wclosed || h.rclosed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	start := time.Now()
+	if start.Before(h.busyUntil) {
+		start = h.busyUntil
+	}
+	h.segs = append(h.segs, pipeSeg{b: append([]byte(nil), p...), start: start})
+	h.busyUntil = start.Add(time.Duration(len(p)) * h.ct)
+	h.signal()
+	return len(p), nil
+}
+
+func (h *pipeHalf) read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		h.mu.Lock()
+		if h.rclosed {
+			h.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		var wait time.Duration
+		if len(h.segs) > 0 {
+			seg := &h.segs[0]
+			arrived := len(seg.b)
+			if h.ct > 0 {
+				arrived = min(arrived, int(time.Since(seg.start)/h.ct))
+			}
+			if arrived > 0 {
+				n := copy(p, seg.b[:arrived])
+				seg.b = seg.b[n:]
+				seg.start = seg.start.Add(time.Duration(n) * h.ct)
+				if len(seg.b) == 0 {
+					h.segs = h.segs[1:]
+				}
+				h.mu.Unlock()
+				return n, nil
+			}
+			wait = max(time.Until(seg.start.Add(h.ct)), time.Microsecond)
+		} else if h.wclosed {
+			h.mu.Unlock()
+			return 0, io.EOF
+		}
+		h.mu.Unlock()
+
+		if wait == 0 {
+			<-h.wake
+			continue
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-h.wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// drain waits until everything written has arrived at the other end.
+func (h *pipeHalf) drain() error {
+	h.mu.Lock()
+	until := h.busyUntil
+	h.mu.Unlock()
+	if d := time.Until(until); d > 0 {
+		time.Sleep(d)
+	}
+	return nil
+}
+
+func (h *pipeHalf) closeWrite() {
+	h.mu.Lock()
+	h.wclosed = true
+	h.mu.Unlock()
+	h.signal()
+}
+
+func (h *pipeHalf) closeRead() {
+	h.mu.Lock()
+	h.rclosed = true
+	h.segs = nil
+	h.mu.Unlock()
+	h.signal()
+}
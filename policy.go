@@ -0,0 +1,203 @@
+package turnstile
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// sessionPolicy enforces the limits shared by listeners and dialers:
+// how many sessions may be handed out, until when, how soon after the
+// previous one ended, and how soon the device may be re-opened.
+type sessionPolicy struct {
+	maxSessions int
+	deadline    time.Time // zero means no lifetime limit
+	cooldown    time.Duration
+	reopenDelay time.Duration
+	healthCheck func(io.ReadWriter) error
+
+	mu        sync.Mutex
+	openFn    OpenFunc
+	sessions  int       // number of conns handed out so far
+	active    bool      // a conn is out
+	lastEnd   time.Time // when the previous session ended
+	lastClose time.Time // when an opened RWC was last closed
+	openErr   error     // result of the latest open
+	opened    bool      // an open has succeeded at least once
+	created   time.Time
+
+	// For WithEagerOpen.
+	warm    *warmRWC      // opened ahead of the next session
+	warming chan struct{} // non-nil while prewarm is opening; closed when done
+}
+
+func (o options) newPolicy(open OpenFunc) *sessionPolicy {
+	p := &sessionPolicy{
+		openFn:      open,
+		maxSessions: o.maxSessions,
+		cooldown:    o.cooldown,
+		reopenDelay: o.reopenDelay,
+		healthCheck: o.healthCheck,
+		created:     time.Now(),
+	}
+	if o.maxLifetime > 0 {
+		p.deadline = time.Now().Add(o.maxLifetime)
+	}
+	return p
+}
+
+// exhausted reports whether no more sessions may be handed out,
+// because of either WithMaxSessions or WithMaxLifetime.
+func (p *sessionPolicy) exhausted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.maxSessions > 0 && p.sessions >= p.maxSessions {
+		return true
+	}
+	return !p.deadline.IsZero() && !time.Now().Before(p.deadline)
+}
+
+// waitCooldown blocks until the cool-down following the previous
+// session has passed, done is closed, or ctx is cancelled.
+func (p *sessionPolicy) waitCooldown(ctx context.Context, done <-chan struct{}) error {
+	p.mu.Lock()
+	lastEnd := p.lastEnd
+	p.mu.Unlock()
+	return waitSince(ctx, done, lastEnd, p.cooldown)
+}
+
+// waitReopen blocks until the reopen delay following the last close of
+// the underlying RWC has passed, done is closed, or ctx is cancelled.
+func (p *sessionPolicy) waitReopen(ctx context.Context, done <-chan struct{}) error {
+	p.mu.Lock()
+	lastClose := p.lastClose
+	p.mu.Unlock()
+	return waitSince(ctx, done, lastClose, p.reopenDelay)
+}
+
+// waitSince blocks until d has passed since t. A zero t doesn't wait.
+func waitSince(ctx context.Context, done <-chan struct{}, t time.Time, d time.Duration) error {
+	wait := time.Until(t.Add(d))
+	if t.IsZero() || wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-done:
+		return net.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// started records that c has been handed out, arming its lifetime
+// limit, and returns its session number.
+func (p *sessionPolicy) started(c *Conn) uint64 {
+	p.mu.Lock()
+	p.sessions++
+	p.active = true
+	n := p.sessions
+	deadline := p.deadline
+	p.mu.Unlock()
+	if !deadline.IsZero() {
+		c.closeAt(deadline, ReasonShutdown)
+	}
+	return uint64(n)
+}
+
+// ended records that a session finished. Its RWC has already been closed.
+func (p *sessionPolicy) ended() {
+	p.mu.Lock()
+	p.active = false
+	p.lastEnd = time.Now()
+	p.lastClose = p.lastEnd
+	p.mu.Unlock()
+}
+
+// open calls the OpenFunc and, if it succeeds, runs the health check on
+// the result. An RWC that fails its health check is closed and the
+// check's error returned, so callers treat it like any other failed
+// open. It also returns the metadata gathered for the conn.
+func (p *sessionPolicy) open() (io.ReadWriteCloser, *values, error) {
+	if w := p.takeWarm(); w != nil {
+		p.mu.Lock()
+		p.openErr, p.opened = nil, true
+		p.mu.Unlock()
+		return w.rwc, w.vals, nil
+	}
+	c, vals, err := p.tryOpen()
+	p.mu.Lock()
+	p.openErr = err
+	p.opened = p.opened || err == nil
+	p.mu.Unlock()
+	return c, vals, err
+}
+
+// everOpened reports whether an open has ever succeeded.
+func (p *sessionPolicy) everOpened() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.opened
+}
+
+func (p *sessionPolicy) tryOpen() (io.ReadWriteCloser, *values, error) {
+	c, err := p.rawOpen()
+	if err != nil {
+		return nil, nil, err
+	}
+	vals := &values{}
+	if b, ok := unwrapTo[interface{ BaudRate() int }](c); ok {
+		vals.SetValue(BaudRateKey, b.BaudRate())
+	}
+	if p.healthCheck != nil {
+		// Let the check hand back input it read past its reply.
+		pc := &prefixRWC{ReadWriteCloser: c}
+		if err := p.healthCheck(valueRW{pc, vals}); err != nil {
+			p.closeRWC(c)
+			return nil, nil, err
+		}
+		if !pc.back.empty() {
+			c = pc
+		}
+	}
+	return c, vals, nil
+}
+
+// rawOpen calls the OpenFunc.
+func (p *sessionPolicy) rawOpen() (io.ReadWriteCloser, error) {
+	p.mu.Lock()
+	open := p.openFn
+	p.mu.Unlock()
+	return open()
+}
+
+// state fills in the policy's part of s.
+func (p *sessionPolicy) state(s *State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.Active = p.active
+	s.LastOpenErr = p.openErr
+	s.SessionCount = uint64(p.sessions)
+	s.Uptime = time.Since(p.created)
+}
+
+// setOpen replaces the OpenFunc used from the next open on.
+func (p *sessionPolicy) setOpen(open OpenFunc) {
+	p.mu.Lock()
+	p.openFn = open
+	p.mu.Unlock()
+	p.dropWarm()
+}
+
+// closeRWC closes an RWC that was opened but never handed out.
+func (p *sessionPolicy) closeRWC(c io.Closer) {
+	c.Close()
+	p.mu.Lock()
+	p.lastClose = time.Now()
+	p.mu.Unlock()
+}
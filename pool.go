@@ -0,0 +1,28 @@
+package turnstile
+
+import "sync"
+
+// copyBufSize is the size of the pooled buffers, the same as io.Copy
+// uses.
+const copyBufSize = 32 << 10
+
+// bufPool holds copy and staging buffers, so busy bridges don't
+// allocate on every read.
+var bufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, copyBufSize)
+		return &b
+	},
+}
+
+func getBuf() *[]byte { return bufPool.Get().(*[]byte) }
+
+// putBuf returns b to the pool. Buffers that have grown well past
+// copyBufSize are left to the garbage collector.
+func putBuf(b *[]byte) {
+	if cap(*b) > 4*copyBufSize {
+		return
+	}
+	*b = (*b)[:cap(*b)]
+	bufPool.Put(b)
+}
@@ -0,0 +1,146 @@
+package turnstile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrIdle is returned by Proxy when a direction sees no data for its
+// idle timeout.
+var ErrIdle = errors.New("turnstile: idle timeout")
+
+// ProxyOptions tunes Proxy. A nil *ProxyOptions uses the defaults.
+type ProxyOptions struct {
+	// IdleAToB and IdleBToA end the proxy if no data has been copied
+	// in that direction for that long. Zero means no limit. A
+	// direction with a limit is copied through a buffer, so it gives
+	// up the splice fast path.
+	IdleAToB, IdleBToA time.Duration
+
+	// HalfClose, when one side hits EOF, shuts down writing to the
+	// other side instead of closing both, if it has a CloseWrite
+	// method (as TCP conns and mux streams do), and keeps copying in
+	// the other direction until it ends too.
+	HalfClose bool
+}
+
+// ProxyStats counts the bytes Proxy copied in each direction.
+type ProxyStats struct {
+	AToB, BToA int64
+}
+
+// Proxy copies data both ways between a and b, e.g. a serial session
+// and a network client, until one side ends, an idle timeout expires,
+// or ctx is done. It then closes both conns and returns how much was
+// copied. The error is nil if a side ended with EOF, ErrIdle if a
+// direction went idle, ctx.Err() if ctx was done, or otherwise the
+// first copy error.
+func Proxy(ctx context.Context, a, b net.Conn, o *ProxyOptions) (ProxyStats, error) {
+	if o == nil {
+		o = &ProxyOptions{}
+	}
+	p := &proxy{a: a, b: b}
+	stop := context.AfterFunc(ctx, func() { p.end(ctx.Err()) })
+	defer stop()
+
+	var s ProxyStats
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.AToB = p.copy(b, a, o.IdleAToB, o.HalfClose)
+	}()
+	go func() {
+		defer wg.Done()
+		s.BToA = p.copy(a, b, o.IdleBToA, o.HalfClose)
+	}()
+	wg.Wait()
+	p.end(nil)
+	return s, p.err
+}
+
+type proxy struct {
+	a, b net.Conn
+
+	mu    sync.Mutex
+	ended bool
+	err   error // why the proxy ended
+	eofs  int   // directions that ended in EOF, when half-closing
+}
+
+// end closes both conns, recording err as the reason unless the proxy
+// has already ended.
+func (p *proxy) end(err error) {
+	p.mu.Lock()
+	if p.ended {
+		p.mu.Unlock()
+		return
+	}
+	p.ended, p.err = true, err
+	p.mu.Unlock()
+	p.a.Close()
+	p.b.Close()
+}
+
+// copy copies src to dst until src ends, then ends the proxy or, when
+// half-closing, shuts down dst's write side.
+func (p *proxy) copy(dst, src net.Conn, idle time.Duration, halfClose bool) int64 {
+	var n int64
+	var err error
+	if idle > 0 {
+		n, err = copyIdle(dst, src, idle, func() { p.end(ErrIdle) })
+	} else {
+		n, err = copyPooled(dst, src)
+	}
+	if err != nil {
+		p.end(err)
+		return n
+	}
+	cw, ok := dst.(interface{ CloseWrite() error })
+	if !halfClose || !ok {
+		p.end(nil)
+		return n
+	}
+	p.mu.Lock()
+	p.eofs++
+	both := p.eofs == 2
+	p.mu.Unlock()
+	if both || cw.CloseWrite() != nil {
+		p.end(nil)
+	}
+	return n
+}
+
+// copyIdle is io.Copy, calling expire if no data arrives for idle.
+func copyIdle(dst io.Writer, src io.Reader, idle time.Duration, expire func()) (int64, error) {
+	t := time.AfterFunc(idle, expire)
+	defer t.Stop()
+	b := getBuf()
+	defer putBuf(b)
+	buf := *b
+	var n int64
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			t.Reset(idle)
+			nw, werr := dst.Write(buf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+			if nw < nr {
+				return n, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
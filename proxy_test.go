@@ -0,0 +1,52 @@
+package turnstile
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func BenchmarkProxy(b *testing.B) {
+	const size = 256 << 10
+	for _, bc := range []struct {
+		name string
+		o    *ProxyOptions
+	}{
+		{"Copy", nil},
+		{"Idle", &ProxyOptions{IdleAToB: time.Minute, IdleBToA: time.Minute}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			chunk := make([]byte, copyBufSize)
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for b.Loop() {
+				a, pa := net.Pipe()
+				pb, sink := net.Pipe()
+				var wg sync.WaitGroup
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					for range size / len(chunk) {
+						a.Write(chunk)
+					}
+					a.Close()
+				}()
+				go func() {
+					defer wg.Done()
+					io.Copy(io.Discard, sink)
+				}()
+				s, err := Proxy(context.Background(), pa, pb, bc.o)
+				if err != nil {
+					b.Fatal(err)
+				}
+				wg.Wait()
+				if s.AToB != size {
+					b.Fatalf("copied %d bytes, want %d", s.AToB, size)
+				}
+			}
+		})
+	}
+}
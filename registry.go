@@ -0,0 +1,160 @@
+package turnstile
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Endpoint is a listener or dialer that can be registered for
+// introspection; *ReopenListener and *ReopenDialer are Endpoints.
+type Endpoint interface {
+	State() State
+	Sessions() []SessionSummary
+	String() string
+}
+
+var registry struct {
+	mu sync.Mutex
+	m  map[string]Endpoint
+}
+
+// Register adds e to the process-wide registry under name, replacing
+// whatever was registered under it before, so that Handler lists it.
+// Registration is optional and has no effect on e itself; a gateway
+// managing many ports might register each listener as it is created.
+func Register(name string, e Endpoint) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if registry.m == nil {
+		registry.m = make(map[string]Endpoint)
+	}
+	registry.m[name] = e
+}
+
+// Unregister removes name from the registry. Closing an endpoint
+// doesn't; it is listed as closed until unregistered.
+func Unregister(name string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.m, name)
+}
+
+// Registered returns the names in the registry, sorted.
+func Registered() []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	names := make([]string, 0, len(registry.m))
+	for name := range registry.m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func lookup(name string) (Endpoint, bool) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	e, ok := registry.m[name]
+	return e, ok
+}
+
+// Handler returns an http.Handler giving an at-a-glance view of the
+// registered endpoints as JSON: for each, in order of name, its
+// description, state, and the sessions kept WithSessionHistory. A
+// request for ?name=x lists just the endpoint registered as x, or
+// fails with 404 Not Found. Mount it somewhere private, e.g.
+//
+//	http.Handle("/debug/turnstile", turnstile.Handler())
+func Handler() http.Handler {
+	return http.HandlerFunc(serveRegistry)
+}
+
+type endpointInfo struct {
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	Status       string        `json:"status,omitempty"` // idle, active, paused, or closed
+	Active       bool          `json:"active"`
+	Waiters      int           `json:"waiters"`
+	LastOpenErr  string        `json:"last_open_error,omitempty"`
+	SessionCount uint64        `json:"session_count"`
+	Uptime       float64       `json:"uptime"` // in seconds
+	Sessions     []sessionInfo `json:"sessions,omitempty"`
+}
+
+type sessionInfo struct {
+	ID       uint64    `json:"id"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"` // in seconds
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	ReadErr  string    `json:"read_error,omitempty"`
+	WriteErr string    `json:"write_error,omitempty"`
+	Reason   string    `json:"reason"`
+}
+
+func serveRegistry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	names := Registered()
+	if name := r.URL.Query().Get("name"); name != "" {
+		if _, ok := lookup(name); !ok {
+			http.Error(w, "no endpoint "+name, http.StatusNotFound)
+			return
+		}
+		names = []string{name}
+	}
+	infos := make([]endpointInfo, 0, len(names))
+	for _, name := range names {
+		if e, ok := lookup(name); ok {
+			infos = append(infos, describeEndpoint(name, e))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(infos)
+}
+
+func describeEndpoint(name string, e Endpoint) endpointInfo {
+	s := e.State()
+	info := endpointInfo{
+		Name:         name,
+		Description:  e.String(),
+		Active:       s.Active,
+		Waiters:      s.Waiters,
+		LastOpenErr:  errString(s.LastOpenErr),
+		SessionCount: s.SessionCount,
+		Uptime:       s.Uptime.Seconds(),
+	}
+	if c, ok := e.(interface{ status(State) string }); ok {
+		info.Status = c.status(s)
+	}
+	for _, ss := range e.Sessions() {
+		info.Sessions = append(info.Sessions, sessionInfo{
+			ID:       ss.ID,
+			Start:    ss.Start,
+			End:      ss.End,
+			Duration: ss.Duration().Seconds(),
+			BytesIn:  ss.BytesIn,
+			BytesOut: ss.BytesOut,
+			ReadErr:  errString(ss.ReadErr),
+			WriteErr: errString(ss.WriteErr),
+			Reason:   ss.Reason.String(),
+		})
+	}
+	return info
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
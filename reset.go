@@ -0,0 +1,197 @@
+package turnstile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrBannerTimeout is returned by an OpenFunc from ResetOpen when the
+// device doesn't finish its boot banner in time.
+var ErrBannerTimeout = errors.New("turnstile: timed out waiting for boot banner")
+
+// ResetPulse configures the reset ResetOpen performs after every open,
+// for boards like the Arduino that reset when DTR or RTS is toggled,
+// and how it skips the banner the bootloader prints afterwards.
+type ResetPulse struct {
+	// DTR and RTS select the lines to pulse, through the RWC's
+	// ControlLineSetter. Setting neither pulses DTR, the line Arduino
+	// boards reset on.
+	DTR, RTS bool
+
+	// Width is how long the lines are raised. Default 100ms.
+	Width time.Duration
+
+	// Settle is how long to wait after lowering them, before reading
+	// the banner or returning the RWC.
+	Settle time.Duration
+
+	// BannerEnd, if set, makes the open wait for the device to send
+	// it; it and everything before it is discarded.
+	BannerEnd []byte
+
+	// BannerQuiet, if set, makes the open wait, after BannerEnd if that
+	// is set too, until the device has sent nothing for this long,
+	// discarding whatever it does send.
+	BannerQuiet time.Duration
+
+	// BannerTimeout bounds the wait for the banner. An open that runs
+	// out of time fails with ErrBannerTimeout, and is retried like any
+	// other failed open. Default 5s.
+	BannerTimeout time.Duration
+}
+
+// ResetOpen returns an OpenFunc that resets the device after every
+// open by pulsing its control lines as r describes, then waits for and
+// discards its boot banner, if r asks for that. An RWC that doesn't
+// implement ControlLineSetter fails to open with errors.ErrUnsupported.
+func ResetOpen(open OpenFunc, r ResetPulse) OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		rwc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.pulse(rwc); err != nil {
+			rwc.Close()
+			return nil, err
+		}
+		if r.BannerEnd == nil && r.BannerQuiet <= 0 {
+			return rwc, nil
+		}
+		return r.skipBanner(rwc)
+	}
+}
+
+// pulse raises the configured lines for Width, lowers them, and waits
+// Settle.
+func (r ResetPulse) pulse(rwc io.ReadWriteCloser) error {
+	s, ok := unwrapTo[ControlLineSetter](rwc)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	width := r.Width
+	if width <= 0 {
+		width = 100 * time.Millisecond
+	}
+	dtr := r.DTR || !r.RTS
+	set := func(on bool) error {
+		if dtr {
+			if err := s.SetDTR(on); err != nil {
+				return err
+			}
+		}
+		if r.RTS {
+			return s.SetRTS(on)
+		}
+		return nil
+	}
+	if err := set(true); err != nil {
+		return err
+	}
+	time.Sleep(width)
+	if err := set(false); err != nil {
+		return err
+	}
+	time.Sleep(r.Settle)
+	return nil
+}
+
+// skipBanner reads and discards the banner. Reads can't be abandoned,
+// so one still in flight when the banner is over is handed to the
+// returned RWC, whose first Read collects it.
+func (r ResetPulse) skipBanner(rwc io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	timeout := r.BannerTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	results := make(chan readResult, 1)
+	pending := false
+	inBanner := r.BannerEnd != nil
+	var seen []byte
+	var quiet *time.Timer // started once the banner is over
+	for {
+		if !pending {
+			pending = true
+			go func() {
+				b := make([]byte, 512)
+				n, err := rwc.Read(b)
+				results <- readResult{b[:n], err}
+			}()
+		}
+		if !inBanner {
+			// Any data restarts the quiet period.
+			if quiet == nil {
+				quiet = time.NewTimer(r.BannerQuiet)
+				defer quiet.Stop()
+			} else {
+				quiet.Reset(r.BannerQuiet)
+			}
+		}
+		select {
+		case res := <-results:
+			pending = false
+			if inBanner {
+				seen = append(seen, res.b...)
+				if i := bytes.Index(seen, r.BannerEnd); i >= 0 {
+					inBanner = false
+					if r.BannerQuiet <= 0 {
+						return &bannerRWC{ReadWriteCloser: rwc, buf: seen[i+len(r.BannerEnd):], err: res.err}, nil
+					}
+				} else if k := len(r.BannerEnd) - 1; len(seen) > k {
+					seen = append(seen[:0], seen[len(seen)-k:]...)
+				}
+			}
+			if res.err != nil {
+				rwc.Close()
+				return nil, res.err
+			}
+		case <-timerC(quiet):
+			return &bannerRWC{ReadWriteCloser: rwc, pending: results}, nil
+		case <-deadline.C:
+			rwc.Close()
+			return nil, ErrBannerTimeout
+		}
+	}
+}
+
+// timerC returns t's channel, or nil for a nil t.
+func timerC(t *time.Timer) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+// bannerRWC is an RWC whose banner has been skipped. Its first Reads
+// return what was read past the banner, or collect the read left in
+// flight.
+type bannerRWC struct {
+	io.ReadWriteCloser
+	buf     []byte
+	err     error
+	pending <-chan readResult
+}
+
+func (b *bannerRWC) Read(p []byte) (int, error) {
+	if b.pending != nil {
+		res := <-b.pending
+		b.pending = nil
+		b.buf, b.err = res.b, res.err
+	}
+	if len(b.buf) > 0 {
+		n := copy(p, b.buf)
+		b.buf = b.buf[n:]
+		return n, nil
+	}
+	if err := b.err; err != nil {
+		b.err = nil
+		return 0, err
+	}
+	return b.ReadWriteCloser.Read(p)
+}
+
+func (b *bannerRWC) Unwrap() io.ReadWriteCloser { return b.ReadWriteCloser }
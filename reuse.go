@@ -0,0 +1,120 @@
+package turnstile
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// WithReuseActive makes a dialer hand callers the active conn, if
+// there is one, instead of making them wait for it to close. Every
+// Dial then returns its own reference to the session, and the session
+// ends once all of them have been closed. This suits HTTP clients that
+// occasionally run two requests at once, which would otherwise
+// deadlock waiting on each other; their traffic shares the device, so
+// the protocol on it must cope. The conns returned are not *Conn, but
+// embed one. Listeners ignore this option.
+func WithReuseActive() Option {
+	return func(o *options) {
+		o.reuseActive = true
+	}
+}
+
+// sharedConn is a conn handed out to several callers WithReuseActive.
+type sharedConn struct {
+	c    *Conn
+	refs int // guarded by the dialer's smu
+}
+
+// shared returns a reference to the active conn, dialing a new one if
+// there is none.
+func (d *ReopenDialer) shared(ctx context.Context, remote net.Addr, priority int) (*connRef, error) {
+	// Only one caller dials at a time, so the others reuse its conn.
+	select {
+	case d.dialing <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-d.dialing }()
+
+	d.smu.Lock()
+	if s := d.active; s != nil {
+		s.refs++
+		d.smu.Unlock()
+		return &connRef{Conn: s.c, d: d, s: s}, nil
+	}
+	d.smu.Unlock()
+
+	c, err := d.session(ctx, priority, remote, nil, d.unshare)
+	if err != nil {
+		return nil, err
+	}
+	s := &sharedConn{c: c, refs: 1}
+	d.smu.Lock()
+	d.active = s
+	d.smu.Unlock()
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		// It was closed, e.g. preempted, before we shared it.
+		d.unshare()
+	}
+	return &connRef{Conn: c, d: d, s: s}, nil
+}
+
+// unshare stops the active conn from being handed out; it is closing.
+func (d *ReopenDialer) unshare() {
+	d.smu.Lock()
+	d.active = nil
+	d.smu.Unlock()
+}
+
+// release drops a reference to s, closing its conn with the last one.
+func (d *ReopenDialer) release(s *sharedConn) error {
+	d.smu.Lock()
+	s.refs--
+	last := s.refs == 0
+	if last && d.active == s {
+		d.active = nil
+	}
+	d.smu.Unlock()
+	if !last {
+		return nil
+	}
+	return s.c.Close()
+}
+
+// connRef is one caller's reference to a shared conn.
+type connRef struct {
+	*Conn
+	d *ReopenDialer
+	s *sharedConn
+
+	once sync.Once
+	stop func() bool // cancels closeOnDone
+}
+
+// Close drops this reference to the session; the last one closes it.
+func (r *connRef) Close() error {
+	err := net.ErrClosed
+	r.once.Do(func() {
+		if r.stop != nil {
+			r.stop()
+		}
+		err = r.d.release(r.s)
+	})
+	return err
+}
+
+// Unwrap returns the shared conn, so ConnContext, NewCloseLinker, and
+// the like find it. It hides the conn's own Unwrap; reach the device
+// through the returned *Conn.
+func (r *connRef) Unwrap() net.Conn { return r.Conn }
+
+// closeOnDone arranges for r to be closed once ctx is done.
+func (r *connRef) closeOnDone(ctx context.Context) {
+	if ctx.Done() != nil {
+		r.stop = context.AfterFunc(ctx, func() { r.Close() })
+	}
+}
@@ -0,0 +1,356 @@
+package rfc2217
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sparques/turnstile"
+)
+
+var (
+	// DialTimeout bounds how long Open waits to connect and configure
+	// the remote port.
+	DialTimeout = 10 * time.Second
+
+	// ReplyTimeout bounds how long a Client waits for the server to
+	// acknowledge a setting.
+	ReplyTimeout = 3 * time.Second
+)
+
+// ErrNoReply is returned when the server doesn't acknowledge a request.
+var ErrNoReply = errors.New("rfc2217: no reply from server")
+
+// Client is a serial port on a remote RFC 2217 server, used as an
+// io.ReadWriteCloser. It implements Port, ControlPort, Purger, and
+// ModemStatusReader, so its settings can be changed just like a local
+// port's, including by a Server re-exporting it.
+type Client struct {
+	conn net.Conn
+	w    *telnetWriter
+	neg  *negotiator
+
+	data chan []byte // received data, closed on read error
+	rbuf []byte
+	err  error // read error, valid once data is closed
+
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+
+	// reqMu keeps requests in the order they are sent, which is the
+	// order the server replies in.
+	reqMu sync.Mutex
+
+	mu      sync.Mutex
+	cfg     Config
+	modem   ModemState
+	waiting map[byte][]chan []byte // outstanding requests by command, oldest first
+	ready   bool                   // Dial is done; data goes to the data channel
+	early   [][]byte               // data received before then
+}
+
+// Open returns an OpenFunc that connects to the RFC 2217 server at
+// addr ("host:port") and configures its port with cfg, so turnstile
+// can manage a remote port just like a local one.
+func Open(addr string, cfg Config) turnstile.OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), DialTimeout)
+		defer cancel()
+		return Dial(ctx, addr, cfg)
+	}
+}
+
+// Dial connects to the RFC 2217 server at addr and configures its port
+// with cfg, giving up once ctx is done. Zero fields of cfg are taken
+// from DefaultConfig.
+func Dial(ctx context.Context, addr string, cfg Config) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(ctx, conn, cfg)
+}
+
+// newClient runs the protocol over conn, configuring the port with cfg
+// within ctx. It closes conn if that fails.
+func newClient(ctx context.Context, conn net.Conn, cfg Config) (*Client, error) {
+	w := &telnetWriter{w: conn}
+	c := &Client{
+		conn:    conn,
+		w:       w,
+		neg:     newNegotiator(w),
+		data:    make(chan []byte, 16),
+		done:    make(chan struct{}),
+		waiting: map[byte][]chan []byte{},
+	}
+	go c.readLoop()
+	if err := c.configure(ctx, cfg); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	c.ready = true
+	c.mu.Unlock()
+	return c, nil
+}
+
+// configure negotiates the telnet options and sets up the port, within
+// ctx.
+func (c *Client) configure(ctx context.Context, cfg Config) error {
+	if t, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(t)
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+
+	for _, o := range [][2]byte{{will, optComPort}, {will, optBinary}, {do, optBinary}, {will, optSGA}, {do, optSGA}} {
+		if err := c.neg.send(o[0], o[1]); err != nil {
+			return err
+		}
+	}
+	if err := c.setConfig(ctx, cfg); err != nil {
+		return err
+	}
+	// Ask to hear about every modem line change.
+	_, err := c.request(ctx, cmdSetModemStateMask, []byte{0xff})
+	return err
+}
+
+func (c *Client) readLoop() {
+	p := &telnetParser{onOption: c.neg.onOption, onSub: c.onSub}
+	for {
+		buf := make([]byte, 4096)
+		n, err := c.conn.Read(buf)
+		if data := p.feed(buf[:n]); len(data) > 0 && !c.deliver(data) {
+			err = net.ErrClosed
+		}
+		if err != nil {
+			c.err = err
+			close(c.data)
+			return
+		}
+	}
+}
+
+// deliver passes data on to Read. Until Dial is done, it is kept aside
+// rather than let a full channel hold up the replies Dial is waiting
+// for. It reports false if the client was closed first.
+func (c *Client) deliver(data []byte) bool {
+	c.mu.Lock()
+	if !c.ready {
+		c.early = append(c.early, data)
+		c.mu.Unlock()
+		return true
+	}
+	c.mu.Unlock()
+	select {
+	case c.data <- data:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *Client) onSub(sub []byte) {
+	if len(sub) < 2 || sub[0] != optComPort || sub[1] < serverOffset {
+		return
+	}
+	cmd, val := sub[1]-serverOffset, append([]byte(nil), sub[2:]...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cmd == cmdNotifyModemState && len(val) == 1 {
+		c.modem = ModemState(val[0])
+		return
+	}
+	if q := c.waiting[cmd]; len(q) > 0 {
+		c.waiting[cmd] = q[1:]
+		q[0] <- val
+	}
+}
+
+// request sends a COM-PORT-OPTION command and waits up to ReplyTimeout,
+// and no longer than ctx allows, for the reply. Requests with the same
+// command, such as SetDTR and SetRTS, may be outstanding at once; the
+// server answers them in order, so each takes the oldest reply.
+func (c *Client) request(ctx context.Context, cmd byte, val []byte) ([]byte, error) {
+	ch := make(chan []byte, 1)
+	c.reqMu.Lock()
+	c.mu.Lock()
+	c.waiting[cmd] = append(c.waiting[cmd], ch)
+	c.mu.Unlock()
+	err := c.w.comPort(cmd, val)
+	c.reqMu.Unlock()
+	if err != nil {
+		c.unwait(cmd, ch)
+		return nil, err
+	}
+	t := time.NewTimer(ReplyTimeout)
+	defer t.Stop()
+	select {
+	case v := <-ch:
+		return v, nil
+	case <-t.C:
+		err = fmt.Errorf("%w to command %d", ErrNoReply, cmd)
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-c.done:
+		err = net.ErrClosed
+	}
+	c.unwait(cmd, ch)
+	return nil, err
+}
+
+// unwait removes ch from the requests waiting for a reply to cmd.
+func (c *Client) unwait(cmd byte, ch chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q := c.waiting[cmd]
+	if i := slices.Index(q, ch); i >= 0 {
+		c.waiting[cmd] = slices.Delete(q, i, i+1)
+	}
+}
+
+// setByte sends a one-byte setting and checks the server accepted it.
+func (c *Client) setByte(ctx context.Context, cmd, v byte) error {
+	got, err := c.request(ctx, cmd, []byte{v})
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 || got[0] != v {
+		return fmt.Errorf("rfc2217: server rejected setting %d for command %d", v, cmd)
+	}
+	return nil
+}
+
+// Read reads data from the remote port.
+func (c *Client) Read(p []byte) (int, error) {
+	if len(c.rbuf) == 0 {
+		c.mu.Lock()
+		if len(c.early) > 0 {
+			c.rbuf, c.early = c.early[0], c.early[1:]
+		}
+		c.mu.Unlock()
+	}
+	if len(c.rbuf) == 0 {
+		b, ok := <-c.data
+		if !ok {
+			return 0, c.err
+		}
+		c.rbuf = b
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// Write writes data to the remote port.
+func (c *Client) Write(p []byte) (int, error) {
+	if err := c.w.data(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.conn.Close()
+}
+
+// Config returns the port's configuration as last acknowledged.
+func (c *Client) Config() Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg
+}
+
+// SetConfig changes the remote port's line settings. Zero fields of
+// cfg are taken from DefaultConfig.
+func (c *Client) SetConfig(cfg Config) error {
+	return c.setConfig(context.Background(), cfg)
+}
+
+func (c *Client) setConfig(ctx context.Context, cfg Config) error {
+	cfg = cfg.withDefaults()
+	got, err := c.request(ctx, cmdSetBaudRate, be32(cfg.BaudRate))
+	if err != nil {
+		return err
+	}
+	if len(got) != 4 || int(binary.BigEndian.Uint32(got)) != cfg.BaudRate {
+		return fmt.Errorf("rfc2217: server rejected baud rate %d", cfg.BaudRate)
+	}
+	if err := c.setByte(ctx, cmdSetDataSize, byte(cfg.DataBits)); err != nil {
+		return err
+	}
+	if err := c.setByte(ctx, cmdSetParity, byte(cfg.Parity)); err != nil {
+		return err
+	}
+	if err := c.setByte(ctx, cmdSetStopSize, byte(cfg.StopBits)); err != nil {
+		return err
+	}
+	if err := c.setByte(ctx, cmdSetControl, byte(cfg.FlowControl)); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cfg = cfg
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) control(on bool, onVal, offVal byte) error {
+	if on {
+		return c.setByte(context.Background(), cmdSetControl, onVal)
+	}
+	return c.setByte(context.Background(), cmdSetControl, offVal)
+}
+
+// LocalAddr returns the local address of the connection to the server.
+func (c *Client) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr returns the address of the server.
+func (c *Client) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetDTR raises or lowers the remote port's DTR line.
+func (c *Client) SetDTR(on bool) error { return c.control(on, ctlDTROn, ctlDTROff) }
+
+// SetRTS raises or lowers the remote port's RTS line.
+func (c *Client) SetRTS(on bool) error { return c.control(on, ctlRTSOn, ctlRTSOff) }
+
+// SetBreak starts or ends a break condition on the remote port.
+func (c *Client) SetBreak(on bool) error { return c.control(on, ctlBreakOn, ctlBreakOff) }
+
+// Purge discards the remote port's receive and/or transmit buffers.
+func (c *Client) Purge(rx, tx bool) error {
+	var v byte
+	switch {
+	case rx && tx:
+		v = purgeBoth
+	case rx:
+		v = purgeRx
+	case tx:
+		v = purgeTx
+	default:
+		return nil
+	}
+	_, err := c.request(context.Background(), cmdPurgeData, []byte{v})
+	return err
+}
+
+// ModemStatus returns the modem lines as last notified by the server.
+func (c *Client) ModemStatus() (ModemState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.modem, nil
+}
+
+// Signature asks the server to identify itself.
+func (c *Client) Signature() (string, error) {
+	b, err := c.request(context.Background(), cmdSignature, nil)
+	return string(b), err
+}
@@ -0,0 +1,136 @@
+// Package rfc2217 implements the Telnet COM-port-control protocol
+// (RFC 2217), which lets a serial port be shared over TCP with its line
+// settings (baud rate, framing, flow control, modem lines) controlled
+// by the remote end. Tools such as ser2net, socat, and pyserial's
+// rfc2217:// URLs speak it.
+//
+// Server exposes a turnstile-managed serial port to RFC 2217 clients,
+// one client at a time. Open goes the other way, producing a
+// turnstile.OpenFunc for a port on a remote RFC 2217 server.
+package rfc2217
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Parity is a serial parity setting, numbered as in RFC 2217.
+type Parity byte
+
+const (
+	ParityNone  Parity = 1
+	ParityOdd   Parity = 2
+	ParityEven  Parity = 3
+	ParityMark  Parity = 4
+	ParitySpace Parity = 5
+)
+
+// StopBits is a serial stop bit setting, numbered as in RFC 2217.
+type StopBits byte
+
+const (
+	StopBits1   StopBits = 1
+	StopBits2   StopBits = 2
+	StopBits1_5 StopBits = 3
+)
+
+// FlowControl is a serial flow control setting, numbered as in the
+// RFC 2217 SET-CONTROL command.
+type FlowControl byte
+
+const (
+	FlowNone     FlowControl = ctlFlowNone
+	FlowXonXoff  FlowControl = ctlFlowXonXoff
+	FlowHardware FlowControl = ctlFlowHardware
+)
+
+// Config holds the line settings of a serial port.
+type Config struct {
+	BaudRate    int
+	DataBits    int
+	Parity      Parity
+	StopBits    StopBits
+	FlowControl FlowControl
+}
+
+// DefaultConfig is 9600 8N1 without flow control.
+var DefaultConfig = Config{
+	BaudRate:    9600,
+	DataBits:    8,
+	Parity:      ParityNone,
+	StopBits:    StopBits1,
+	FlowControl: FlowNone,
+}
+
+// withDefaults fills in zero fields from DefaultConfig.
+func (c Config) withDefaults() Config {
+	if c.BaudRate == 0 {
+		c.BaudRate = DefaultConfig.BaudRate
+	}
+	if c.DataBits == 0 {
+		c.DataBits = DefaultConfig.DataBits
+	}
+	if c.Parity == 0 {
+		c.Parity = DefaultConfig.Parity
+	}
+	if c.StopBits == 0 {
+		c.StopBits = DefaultConfig.StopBits
+	}
+	if c.FlowControl == 0 {
+		c.FlowControl = DefaultConfig.FlowControl
+	}
+	return c
+}
+
+func (c Config) String() string {
+	p := "?NOEMS"
+	par := byte('?')
+	if int(c.Parity) < len(p) {
+		par = p[c.Parity]
+	}
+	s := map[StopBits]string{StopBits1: "1", StopBits2: "2", StopBits1_5: "1.5"}[c.StopBits]
+	return fmt.Sprintf("%d %d%c%s", c.BaudRate, c.DataBits, par, s)
+}
+
+// Port is implemented by serial devices whose line settings can be
+// changed. The Server calls SetConfig with the complete configuration
+// whenever a client changes any part of it.
+type Port interface {
+	SetConfig(Config) error
+}
+
+// ControlPort is implemented by serial devices that can drive their
+// DTR and RTS lines and send a break.
+type ControlPort interface {
+	SetDTR(on bool) error
+	SetRTS(on bool) error
+	SetBreak(on bool) error
+}
+
+// Purger is implemented by serial devices that can discard the
+// contents of their receive and/or transmit buffers.
+type Purger interface {
+	Purge(rx, tx bool) error
+}
+
+// ModemState holds the modem status lines as reported by
+// NOTIFY-MODEMSTATE. The low four bits flag lines that changed.
+type ModemState byte
+
+const (
+	ModemCD  ModemState = 0x80 // carrier detect
+	ModemRI  ModemState = 0x40 // ring indicator
+	ModemDSR ModemState = 0x20 // data set ready
+	ModemCTS ModemState = 0x10 // clear to send
+)
+
+// ModemStatusReader is implemented by serial devices that can report
+// their modem status lines. The Server polls it to notify clients of
+// changes.
+type ModemStatusReader interface {
+	ModemStatus() (ModemState, error)
+}
+
+func be32(v int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(v))
+}
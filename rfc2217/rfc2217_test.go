@@ -0,0 +1,158 @@
+package rfc2217
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPort is a port that applies settings to itself, passing data
+// through a net.Conn.
+type testPort struct {
+	net.Conn
+
+	mu            sync.Mutex
+	cfg           Config
+	dtr, rts, brk bool
+}
+
+func (p *testPort) SetConfig(cfg Config) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cfg = cfg
+	return nil
+}
+
+func (p *testPort) set(line *bool, on bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	*line = on
+	return nil
+}
+
+func (p *testPort) SetDTR(on bool) error   { return p.set(&p.dtr, on) }
+func (p *testPort) SetRTS(on bool) error   { return p.set(&p.rts, on) }
+func (p *testPort) SetBreak(on bool) error { return p.set(&p.brk, on) }
+
+// tcpPair returns both ends of a loopback TCP connection. Unlike
+// net.Pipe it buffers, as telnet negotiation needs: both sides write
+// their replies from their read loops.
+func tcpPair(t *testing.T) (a, b net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if a, err = net.Dial("tcp", l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = l.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// serve starts a Server session for port, returning the client's end
+// of the connection. The session's result is sent on done.
+func serve(t *testing.T, s *Server, port net.Conn) (client net.Conn, done <-chan error) {
+	t.Helper()
+	a, b := tcpPair(t)
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeConn(b, port) }()
+	return a, errc
+}
+
+// dialServer returns a Client talking to a Server for port.
+func dialServer(t *testing.T, port net.Conn) *Client {
+	t.Helper()
+	conn, _ := serve(t, &Server{}, port)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := newClient(ctx, conn, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// TestClientConcurrentControl sets DTR, RTS, and break from several
+// goroutines at once: all share the SET-CONTROL command, so each must
+// still get its own reply.
+func TestClientConcurrentControl(t *testing.T) {
+	pa, pb := net.Pipe()
+	defer pb.Close()
+	port := &testPort{Conn: pa}
+	c := dialServer(t, port)
+
+	setters := []func(bool) error{c.SetDTR, c.SetRTS, c.SetBreak}
+	var wg sync.WaitGroup
+	for i, set := range setters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				if err := set((i+j)%2 == 0); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	// Each setter's last call was with (i+49)%2 == 0.
+	if port.dtr || !port.rts || port.brk {
+		t.Fatalf("dtr=%v rts=%v break=%v, want false true false", port.dtr, port.rts, port.brk)
+	}
+}
+
+// TestServeConnHandsOver runs two sessions on one port in turn, with
+// the device sending between them: the second session must get it all,
+// though the first was still reading the port when it ended.
+func TestServeConnHandsOver(t *testing.T) {
+	pa, pb := net.Pipe()
+	defer pb.Close()
+	port := &testPort{Conn: pa}
+	s := &Server{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, done := serve(t, s, port)
+	c, err := newClient(ctx, conn, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("first session: %v", err)
+	}
+
+	const msg = "hello from the device"
+	go io.WriteString(pb, msg)
+
+	conn, _ = serve(t, s, port)
+	c, err = newClient(ctx, conn, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("second session: %v", err)
+	}
+	if string(buf) != msg {
+		t.Fatalf("got %q, want %q", buf, msg)
+	}
+}
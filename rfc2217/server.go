@@ -0,0 +1,441 @@
+package rfc2217
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/sparques/turnstile"
+)
+
+// Server bridges RFC 2217 clients to a serial port.
+type Server struct {
+	// Signature is sent to clients that ask for the server's signature.
+	Signature string
+
+	// Config is the line configuration the port is assumed to start
+	// with. Zero fields are taken from DefaultConfig.
+	Config Config
+
+	// PollInterval is how often the modem lines are polled when the
+	// port implements ModemStatusReader. Defaults to 100ms.
+	PollInterval time.Duration
+
+	mu     sync.Mutex
+	parked map[io.Reader]*portRead // reads left outstanding by ended sessions
+}
+
+// Serve accepts clients from l and bridges each to a conn dialed from
+// d. Since d hands out one conn at a time, clients take turns: a client
+// that connects while another holds the port waits until it's free.
+// Serve returns when l.Accept fails.
+func (s *Server) Serve(l net.Listener, d *turnstile.ReopenDialer) error {
+	for {
+		client, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer client.Close()
+			port, err := d.DialContext(context.Background(), "serial", client.RemoteAddr().String())
+			if err != nil {
+				return
+			}
+			defer port.Close()
+			s.ServeConn(client, port)
+		}()
+	}
+}
+
+// ServeConn runs the protocol between client and port until either
+// side fails or closes. A read from port still outstanding when it
+// returns is kept for the next ServeConn with the same port, so
+// sessions can take turns on a port without losing data between them.
+// Line settings are applied to port if it, or
+// the device underlying a turnstile conn, implements Port, ControlPort,
+// Purger, or ModemStatusReader.
+func (s *Server) ServeConn(client net.Conn, port io.ReadWriter) error {
+	ss := &session{
+		Server: s,
+		w:      &telnetWriter{w: client},
+		port:   port,
+		dev:    underlying(port),
+		cfg:    s.Config.withDefaults(),
+	}
+	ss.neg = newNegotiator(ss.w)
+	ss.cond = sync.NewCond(&ss.mu)
+	return ss.run(client)
+}
+
+// underlying returns the device behind a turnstile conn and any other
+// wrappers that offer Unwrap, or rw itself.
+func underlying(rw io.ReadWriter) any {
+	for {
+		u, ok := rw.(interface{ Unwrap() io.ReadWriteCloser })
+		if !ok {
+			return rw
+		}
+		rw = u.Unwrap()
+	}
+}
+
+type session struct {
+	*Server
+	w    *telnetWriter
+	neg  *negotiator
+	port io.ReadWriter
+	dev  any
+
+	mu             sync.Mutex
+	cond           *sync.Cond
+	cfg            Config
+	dtr, rts, brk  bool
+	suspended      bool
+	done           bool
+	lineStateMask  byte
+	modemStateMask byte
+}
+
+// portRead is a read from a port, done once its result is set.
+type portRead struct {
+	b      []byte
+	err    error
+	done   chan struct{}
+	parked bool // kept in Server.parked; guarded by Server.mu
+}
+
+// readPort returns the read left outstanding on port by the last
+// session, or else starts a new one.
+func (s *Server) readPort(port io.Reader) *portRead {
+	s.mu.Lock()
+	r := s.parked[port]
+	if r != nil {
+		delete(s.parked, port)
+		r.parked = false
+	}
+	s.mu.Unlock()
+	if r != nil {
+		return r
+	}
+
+	r = &portRead{done: make(chan struct{})}
+	go func() {
+		buf := make([]byte, 4096)
+		n, err := port.Read(buf)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		r.b, r.err = buf[:n], err
+		close(r.done)
+		// A failed port can't serve another session, so don't keep
+		// it around waiting for one.
+		if r.parked && err != nil {
+			delete(s.parked, port)
+		}
+	}()
+	return r
+}
+
+// park keeps r for the next session on port to pick up.
+func (s *Server) park(port io.Reader, r *portRead) {
+	if !reflect.TypeOf(port).Comparable() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-r.done:
+		if r.err != nil {
+			return
+		}
+	default:
+	}
+	if s.parked == nil {
+		s.parked = map[io.Reader]*portRead{}
+	}
+	r.parked = true
+	s.parked[port] = r
+}
+
+func (s *session) run(client net.Conn) error {
+	// The client is expected to offer WILL COM-PORT-OPTION; we
+	// invite it up front.
+	for _, o := range [][2]byte{{do, optComPort}, {will, optBinary}, {do, optBinary}, {will, optSGA}, {do, optSGA}} {
+		if err := s.neg.send(o[0], o[1]); err != nil {
+			return err
+		}
+	}
+
+	errc := make(chan error, 3)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// Port to client. When the session ends, whatever the port has yet
+	// to hand over is left for the next one.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			r := s.readPort(s.port)
+			select {
+			case <-r.done:
+			case <-stop:
+				s.park(s.port, r)
+				return
+			}
+			if len(r.b) > 0 {
+				s.waitResume()
+				if werr := s.w.data(r.b); werr != nil {
+					done := make(chan struct{})
+					close(done)
+					s.park(s.port, &portRead{b: r.b, done: done})
+					errc <- werr
+					return
+				}
+			}
+			if r.err != nil {
+				errc <- r.err
+				return
+			}
+		}
+	}()
+
+	// Client to port.
+	go func() {
+		p := &telnetParser{onOption: s.neg.onOption, onSub: s.onSub}
+		buf := make([]byte, 4096)
+		for {
+			n, err := client.Read(buf)
+			if data := p.feed(buf[:n]); len(data) > 0 {
+				if _, werr := s.port.Write(data); werr != nil {
+					errc <- werr
+					return
+				}
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	if ms, ok := s.dev.(ModemStatusReader); ok {
+		go s.pollModem(ms, stop)
+	}
+
+	err := <-errc
+	client.Close()
+	s.mu.Lock()
+	s.done = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	close(stop)
+	wg.Wait()
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *session) onSub(sub []byte) {
+	if len(sub) < 2 || sub[0] != optComPort {
+		return
+	}
+	cmd, val := sub[1], sub[2:]
+	reply := func(v []byte) { s.w.comPort(cmd+serverOffset, v) }
+
+	switch cmd {
+	case cmdSignature:
+		if len(val) == 0 {
+			reply([]byte(s.Signature))
+		}
+	case cmdSetBaudRate:
+		if len(val) != 4 {
+			return
+		}
+		if v := int(binary.BigEndian.Uint32(val)); v != 0 {
+			s.setConfig(func(c *Config) { c.BaudRate = v })
+		}
+		reply(be32(s.config().BaudRate))
+	case cmdSetDataSize:
+		if len(val) != 1 {
+			return
+		}
+		if v := int(val[0]); v >= 5 && v <= 8 {
+			s.setConfig(func(c *Config) { c.DataBits = v })
+		}
+		reply([]byte{byte(s.config().DataBits)})
+	case cmdSetParity:
+		if len(val) != 1 {
+			return
+		}
+		if v := Parity(val[0]); v >= ParityNone && v <= ParitySpace {
+			s.setConfig(func(c *Config) { c.Parity = v })
+		}
+		reply([]byte{byte(s.config().Parity)})
+	case cmdSetStopSize:
+		if len(val) != 1 {
+			return
+		}
+		if v := StopBits(val[0]); v >= StopBits1 && v <= StopBits1_5 {
+			s.setConfig(func(c *Config) { c.StopBits = v })
+		}
+		reply([]byte{byte(s.config().StopBits)})
+	case cmdSetControl:
+		if len(val) != 1 {
+			return
+		}
+		reply([]byte{s.control(val[0])})
+	case cmdFlowSuspend, cmdFlowResume:
+		s.mu.Lock()
+		s.suspended = cmd == cmdFlowSuspend
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	case cmdSetLineStateMask, cmdSetModemStateMask:
+		if len(val) != 1 {
+			return
+		}
+		s.mu.Lock()
+		if cmd == cmdSetLineStateMask {
+			s.lineStateMask = val[0]
+		} else {
+			s.modemStateMask = val[0]
+		}
+		s.mu.Unlock()
+		reply(val)
+	case cmdPurgeData:
+		if len(val) != 1 {
+			return
+		}
+		if p, ok := s.dev.(Purger); ok {
+			p.Purge(val[0] == purgeRx || val[0] == purgeBoth, val[0] == purgeTx || val[0] == purgeBoth)
+		}
+		reply(val)
+	}
+}
+
+func (s *session) config() Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg
+}
+
+// setConfig applies a change to the port. If the port rejects it, the
+// old configuration is kept and reported back to the client.
+func (s *session) setConfig(change func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg := s.cfg
+	change(&cfg)
+	if p, ok := s.dev.(Port); ok {
+		if err := p.SetConfig(cfg); err != nil {
+			return
+		}
+	}
+	s.cfg = cfg
+}
+
+// control handles SET-CONTROL and returns the value to reply with.
+func (s *session) control(v byte) byte {
+	cp, _ := s.dev.(ControlPort)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set := func(state *bool, on bool, fn func(bool) error) {
+		if cp == nil || fn(on) == nil {
+			*state = on
+		}
+	}
+	onOff := func(state bool, on, off byte) byte {
+		if state {
+			return on
+		}
+		return off
+	}
+
+	switch v {
+	case ctlFlowRequest:
+		return byte(s.cfg.FlowControl)
+	case ctlFlowNone, ctlFlowXonXoff, ctlFlowHardware:
+		cfg := s.cfg
+		cfg.FlowControl = FlowControl(v)
+		if p, ok := s.dev.(Port); !ok || p.SetConfig(cfg) == nil {
+			s.cfg = cfg
+		}
+		return byte(s.cfg.FlowControl)
+	case ctlBreakRequest:
+		return onOff(s.brk, ctlBreakOn, ctlBreakOff)
+	case ctlBreakOn, ctlBreakOff:
+		set(&s.brk, v == ctlBreakOn, func(on bool) error { return cp.SetBreak(on) })
+		return onOff(s.brk, ctlBreakOn, ctlBreakOff)
+	case ctlDTRRequest:
+		return onOff(s.dtr, ctlDTROn, ctlDTROff)
+	case ctlDTROn, ctlDTROff:
+		set(&s.dtr, v == ctlDTROn, func(on bool) error { return cp.SetDTR(on) })
+		return onOff(s.dtr, ctlDTROn, ctlDTROff)
+	case ctlRTSRequest:
+		return onOff(s.rts, ctlRTSOn, ctlRTSOff)
+	case ctlRTSOn, ctlRTSOff:
+		set(&s.rts, v == ctlRTSOn, func(on bool) error { return cp.SetRTS(on) })
+		return onOff(s.rts, ctlRTSOn, ctlRTSOff)
+	}
+	// Inbound flow control and anything newer: echo it back, as
+	// servers that don't support a setting are expected to.
+	return v
+}
+
+// waitResume blocks while the client has suspended the flow of data.
+func (s *session) waitResume() {
+	s.mu.Lock()
+	for s.suspended && !s.done {
+		s.cond.Wait()
+	}
+	s.mu.Unlock()
+}
+
+// pollModem notifies the client of changes to the modem lines that
+// it has asked to hear about.
+func (s *session) pollModem(ms ModemStatusReader, stop <-chan struct{}) {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var last ModemState
+	first := true
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		state, err := ms.ModemStatus()
+		if err != nil {
+			continue
+		}
+		state &= 0xf0
+		if !first && state == last {
+			continue
+		}
+		// Flag the lines that changed in the delta bits.
+		delta := ModemState((state ^ last) >> 4)
+		if first {
+			delta = 0
+		}
+		last, first = state, false
+
+		s.mu.Lock()
+		mask := s.modemStateMask
+		s.mu.Unlock()
+		// Only notify when a line the client cares about changed.
+		if v := byte(state|delta) & mask; v&0x0f != 0 {
+			s.w.comPort(cmdNotifyModemState+serverOffset, []byte{v})
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package rfc2217
+
+import (
+	"io"
+	"sync"
+)
+
+// Telnet commands and options used by RFC 854/2217.
+const (
+	se   = 240
+	sb   = 250
+	will = 251
+	wont = 252
+	do   = 253
+	dont = 254
+	iac  = 255
+
+	optBinary  = 0
+	optSGA     = 3
+	optComPort = 44
+)
+
+// COM-PORT-OPTION commands sent by the client. The server answers
+// each with the same command plus serverOffset.
+const (
+	cmdSignature         = 0
+	cmdSetBaudRate       = 1
+	cmdSetDataSize       = 2
+	cmdSetParity         = 3
+	cmdSetStopSize       = 4
+	cmdSetControl        = 5
+	cmdNotifyLineState   = 6
+	cmdNotifyModemState  = 7
+	cmdFlowSuspend       = 8
+	cmdFlowResume        = 9
+	cmdSetLineStateMask  = 10
+	cmdSetModemStateMask = 11
+	cmdPurgeData         = 12
+
+	serverOffset = 100
+)
+
+// SET-CONTROL values.
+const (
+	ctlFlowRequest  = 0
+	ctlFlowNone     = 1
+	ctlFlowXonXoff  = 2
+	ctlFlowHardware = 3
+	ctlBreakRequest = 4
+	ctlBreakOn      = 5
+	ctlBreakOff     = 6
+	ctlDTRRequest   = 7
+	ctlDTROn        = 8
+	ctlDTROff       = 9
+	ctlRTSRequest   = 10
+	ctlRTSOn        = 11
+	ctlRTSOff       = 12
+)
+
+// PURGE-DATA values.
+const (
+	purgeRx   = 1
+	purgeTx   = 2
+	purgeBoth = 3
+)
+
+// telnetWriter serializes writes to a telnet stream so data and
+// commands from different goroutines don't interleave.
+type telnetWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// data writes p, doubling any IAC bytes.
+func (t *telnetWriter) data(p []byte) error {
+	b := make([]byte, 0, len(p)+8)
+	for _, c := range p {
+		b = append(b, c)
+		if c == iac {
+			b = append(b, iac)
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.w.Write(b)
+	return err
+}
+
+// option writes a WILL/WONT/DO/DONT negotiation.
+func (t *telnetWriter) option(verb, opt byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.w.Write([]byte{iac, verb, opt})
+	return err
+}
+
+// comPort writes a COM-PORT-OPTION subnegotiation.
+func (t *telnetWriter) comPort(cmd byte, val []byte) error {
+	b := []byte{iac, sb, optComPort, cmd}
+	for _, c := range val {
+		b = append(b, c)
+		if c == iac {
+			b = append(b, iac)
+		}
+	}
+	b = append(b, iac, se)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.w.Write(b)
+	return err
+}
+
+// negotiator answers option negotiations, agreeing to BINARY, SGA,
+// and COM-PORT-OPTION in both directions and refusing everything else.
+// It remembers what it has sent so negotiations don't loop.
+type negotiator struct {
+	w *telnetWriter
+
+	mu   sync.Mutex
+	sent map[[2]byte]bool
+}
+
+func newNegotiator(w *telnetWriter) *negotiator {
+	return &negotiator{w: w, sent: map[[2]byte]bool{}}
+}
+
+// send sends a negotiation unless it was already sent.
+func (n *negotiator) send(verb, opt byte) error {
+	n.mu.Lock()
+	if n.sent[[2]byte{verb, opt}] {
+		n.mu.Unlock()
+		return nil
+	}
+	n.sent[[2]byte{verb, opt}] = true
+	n.mu.Unlock()
+	return n.w.option(verb, opt)
+}
+
+func (n *negotiator) onOption(verb, opt byte) {
+	supported := opt == optBinary || opt == optSGA || opt == optComPort
+	switch verb {
+	case do:
+		if supported {
+			n.send(will, opt)
+		} else {
+			n.w.option(wont, opt)
+		}
+	case will:
+		if supported {
+			n.send(do, opt)
+		} else {
+			n.w.option(dont, opt)
+		}
+	}
+}
+
+// telnetParser splits a telnet stream into data and commands.
+type telnetParser struct {
+	state int
+	verb  byte
+	sb    []byte
+
+	onOption func(verb, opt byte)
+	onSub    func(sub []byte)
+}
+
+const (
+	stData = iota
+	stIAC
+	stOption
+	stSub
+	stSubIAC
+)
+
+// maxSub bounds the length of a subnegotiation we are willing to buffer.
+const maxSub = 1024
+
+// feed parses b and returns the data bytes it contained, reusing b's
+// storage. Commands are reported through onOption and onSub.
+func (p *telnetParser) feed(b []byte) []byte {
+	data := b[:0]
+	for _, c := range b {
+		switch p.state {
+		case stData:
+			if c == iac {
+				p.state = stIAC
+			} else {
+				data = append(data, c)
+			}
+		case stIAC:
+			switch c {
+			case iac:
+				data = append(data, iac)
+				p.state = stData
+			case will, wont, do, dont:
+				p.verb = c
+				p.state = stOption
+			case sb:
+				p.sb = p.sb[:0]
+				p.state = stSub
+			default:
+				// NOP, GA, and friends carry no meaning here.
+				p.state = stData
+			}
+		case stOption:
+			if p.onOption != nil {
+				p.onOption(p.verb, c)
+			}
+			p.state = stData
+		case stSub:
+			if c == iac {
+				p.state = stSubIAC
+			} else if len(p.sb) < maxSub {
+				p.sb = append(p.sb, c)
+			}
+		case stSubIAC:
+			switch c {
+			case iac:
+				if len(p.sb) < maxSub {
+					p.sb = append(p.sb, iac)
+				}
+				p.state = stSub
+			case se:
+				if p.onSub != nil {
+					p.onSub(p.sb)
+				}
+				p.state = stData
+			default:
+				// Malformed; drop the subnegotiation.
+				p.state = stData
+			}
+		}
+	}
+	return data
+}
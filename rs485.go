@@ -0,0 +1,114 @@
+package turnstile
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// RS485 configures direction control for an RS-485 transceiver whose
+// adapter doesn't switch between sending and receiving by itself.
+type RS485 struct {
+	// RTS drives the transceiver's driver enable with RTS, through the
+	// RWC's ControlLineSetter: raised while sending, lowered after.
+	// RTSActiveLow inverts that.
+	RTS          bool
+	RTSActiveLow bool
+
+	// BeforeWrite and AfterWrite, if set, are called around every
+	// write, e.g. to toggle a GPIO line instead of (or as well as) RTS.
+	BeforeWrite func() error
+	AfterWrite  func() error
+
+	// DelayBeforeSend is how long to wait after enabling the driver
+	// before sending, and DelayAfterSend how long to keep it enabled
+	// after the data has gone out.
+	DelayBeforeSend time.Duration
+	DelayAfterSend  time.Duration
+}
+
+// RS485RW wraps an RWC and switches an RS-485 transceiver to send for
+// the duration of every Write. Data has gone out when the RWC's
+// Drainer says so; an RWC that doesn't implement Drainer returns from
+// Write as soon as the data is queued, so DelayAfterSend then has to
+// cover the time it takes to transmit.
+type RS485RW struct {
+	rwc io.ReadWriteCloser
+	cfg RS485
+	mu  sync.Mutex
+}
+
+// NewRS485RW returns rwc with direction control as configured by cfg.
+func NewRS485RW(rwc io.ReadWriteCloser, cfg RS485) *RS485RW {
+	return &RS485RW{rwc: rwc, cfg: cfg}
+}
+
+// RS485Open returns an OpenFunc that wraps every RWC opened by open in
+// an RS485RW, with the transceiver set to receive to start with.
+func RS485Open(open OpenFunc, cfg RS485) OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		rwc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		r := NewRS485RW(rwc, cfg)
+		if err := r.setRTS(false); err != nil {
+			rwc.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+}
+
+func (r *RS485RW) Read(p []byte) (int, error) { return r.rwc.Read(p) }
+
+// Write enables the driver, writes p, waits for it to go out, and
+// switches back to receive. Writes are serialized.
+func (r *RS485RW) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cfg.BeforeWrite != nil {
+		if err := r.cfg.BeforeWrite(); err != nil {
+			return 0, err
+		}
+	}
+	if err := r.setRTS(true); err != nil {
+		return 0, err
+	}
+	time.Sleep(r.cfg.DelayBeforeSend)
+
+	n, err := r.rwc.Write(p)
+	if d, ok := unwrapTo[Drainer](r.rwc); ok && err == nil {
+		err = d.Drain()
+	}
+
+	time.Sleep(r.cfg.DelayAfterSend)
+	if rerr := r.setRTS(false); err == nil {
+		err = rerr
+	}
+	if r.cfg.AfterWrite != nil {
+		if aerr := r.cfg.AfterWrite(); err == nil {
+			err = aerr
+		}
+	}
+	return n, err
+}
+
+// setRTS enables (send) or disables (receive) the driver through RTS,
+// if so configured.
+func (r *RS485RW) setRTS(send bool) error {
+	if !r.cfg.RTS {
+		return nil
+	}
+	s, ok := unwrapTo[ControlLineSetter](r.rwc)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return s.SetRTS(send != r.cfg.RTSActiveLow)
+}
+
+func (r *RS485RW) Close() error { return r.rwc.Close() }
+
+// Unwrap returns the underlying RWC.
+func (r *RS485RW) Unwrap() io.ReadWriteCloser { return r.rwc }
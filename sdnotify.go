@@ -0,0 +1,107 @@
+package turnstile
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SdNotify sends state, such as "READY=1" or "WATCHDOG=1", to the
+// service manager through the socket named by $NOTIFY_SOCKET, as
+// systemd's sd_notify does. It does nothing if the variable isn't set,
+// i.e. the process isn't running under systemd with notify support.
+func SdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	if name[0] == '@' {
+		name = "\x00" + name[1:] // abstract socket
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write([]byte(state))
+	return err
+}
+
+// SystemdNotifier reports link health to systemd. Pass its Report
+// method to WithHealthReport:
+//
+//	n := turnstile.NewSystemdNotifier()
+//	defer n.Close()
+//	l := turnstile.NewReopenListener(open, "/dev/ttyUSB0",
+//		turnstile.WithHealthReport(3, n.Report))
+//
+// Once the link is healthy, it tells systemd the service is ready and,
+// if the unit has WatchdogSec set, keeps pinging the watchdog. Once the
+// link is degraded, the pings stop, so systemd restarts the service
+// when the watchdog runs out. The status line shows the link's health
+// either way.
+type SystemdNotifier struct {
+	interval time.Duration // between watchdog pings; zero if no watchdog
+	stop     chan struct{}
+	once     sync.Once
+
+	mu      sync.Mutex
+	healthy bool
+}
+
+// NewSystemdNotifier returns a SystemdNotifier, reading the watchdog
+// settings from the environment systemd provides.
+func NewSystemdNotifier() *SystemdNotifier {
+	n := &SystemdNotifier{stop: make(chan struct{})}
+	usec, _ := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID"))
+	if usec > 0 && (err != nil || pid == os.Getpid()) {
+		n.interval = time.Duration(usec) * time.Microsecond / 2
+		go n.ping()
+	}
+	return n
+}
+
+// Report tells systemd about the link's health.
+func (n *SystemdNotifier) Report(h Health, err error) {
+	n.mu.Lock()
+	n.healthy = h == Healthy
+	n.mu.Unlock()
+	switch h {
+	case Healthy:
+		SdNotify("READY=1\nSTATUS=link healthy")
+	case Degraded:
+		status := "STATUS=link degraded"
+		if err != nil {
+			status += ": " + err.Error()
+		}
+		SdNotify(status)
+	}
+}
+
+// ping pings the watchdog while the link is healthy.
+func (n *SystemdNotifier) ping() {
+	t := time.NewTicker(n.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-n.stop:
+			return
+		}
+		n.mu.Lock()
+		healthy := n.healthy
+		n.mu.Unlock()
+		if healthy {
+			SdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+// Close stops the watchdog pings.
+func (n *SystemdNotifier) Close() error {
+	n.once.Do(func() { close(n.stop) })
+	return nil
+}
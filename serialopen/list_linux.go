@@ -0,0 +1,59 @@
+package serialopen
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// list finds ttys backed by a device in sysfs, skipping virtual ones
+// such as consoles and ptys.
+func list() ([]Port, error) {
+	entries, err := os.ReadDir("/sys/class/tty")
+	if err != nil {
+		return nil, err
+	}
+	var ports []Port
+	for _, e := range entries {
+		dev, err := filepath.EvalSymlinks(filepath.Join("/sys/class/tty", e.Name(), "device"))
+		if err != nil {
+			continue
+		}
+		p := Port{Path: "/dev/" + e.Name()}
+		// The USB device is an ancestor of the tty's device: the
+		// interface for cdc_acm, one level further up for usb-serial.
+		for d := dev; d != "/" && d != "."; d = filepath.Dir(d) {
+			vid, err := readHex(filepath.Join(d, "idVendor"))
+			if err != nil {
+				continue
+			}
+			p.VID = vid
+			p.PID, _ = readHex(filepath.Join(d, "idProduct"))
+			p.Serial = readString(filepath.Join(d, "serial"))
+			p.Manufacturer = readString(filepath.Join(d, "manufacturer"))
+			p.Product = readString(filepath.Join(d, "product"))
+			p.Description = strings.TrimSpace(p.Manufacturer + " " + p.Product)
+			break
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+func readString(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func readHex(path string) (uint16, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 16, 16)
+	return uint16(v), err
+}
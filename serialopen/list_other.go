@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package serialopen
+
+import "errors"
+
+// list is only implemented on Linux.
+func list() ([]Port, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,162 @@
+package serialopen
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32          = syscall.NewLazyDLL("advapi32.dll")
+	procRegEnumValueW = advapi32.NewProc("RegEnumValueW")
+)
+
+const errorNoMoreItems = 259
+
+// usbKey picks the VID and PID out of a device key name such as
+// USB\VID_0403&PID_6001 or FTDIBUS\VID_0403+PID_6001+A50285BIA.
+var usbKey = regexp.MustCompile(`(?i)VID_([0-9a-f]{4})[&+]PID_([0-9a-f]{4})(?:\+([^\\]+))?`)
+
+// list returns the COM ports present, taken from the SERIALCOMM device
+// map, with descriptions and USB attributes from the device enum tree
+// for those that are USB devices.
+func list() ([]Port, error) {
+	names, err := presentPorts()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Port, len(names))
+	ports := make([]Port, len(names))
+	for i, name := range names {
+		ports[i] = Port{Path: name}
+		byName[name] = &ports[i]
+	}
+	for _, bus := range []string{"USB", "FTDIBUS"} {
+		describeUSB(bus, byName)
+	}
+	return ports, nil
+}
+
+// presentPorts lists the COM ports in HKLM\HARDWARE\DEVICEMAP\SERIALCOMM,
+// which only holds ports whose devices are present.
+func presentPorts() ([]string, error) {
+	k, err := openKey(syscall.HKEY_LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`)
+	if err != nil {
+		if err == syscall.ERROR_FILE_NOT_FOUND {
+			// No serial ports at all.
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer syscall.RegCloseKey(k)
+	var names []string
+	for i := uint32(0); ; i++ {
+		var name [256]uint16
+		var data [256]uint16
+		nameLen := uint32(len(name))
+		dataLen := uint32(len(data) * 2)
+		var typ uint32
+		r, _, _ := procRegEnumValueW.Call(uintptr(k), uintptr(i),
+			uintptr(unsafe.Pointer(&name[0])), uintptr(unsafe.Pointer(&nameLen)), 0,
+			uintptr(unsafe.Pointer(&typ)), uintptr(unsafe.Pointer(&data[0])), uintptr(unsafe.Pointer(&dataLen)))
+		if r == errorNoMoreItems {
+			return names, nil
+		}
+		if r != 0 {
+			return names, syscall.Errno(r)
+		}
+		if typ == syscall.REG_SZ {
+			names = append(names, syscall.UTF16ToString(data[:]))
+		}
+	}
+}
+
+// describeUSB fills in the ports found under the Enum key for bus.
+// Each device instance that is a serial port names it in its
+// "Device Parameters\PortName" value.
+func describeUSB(bus string, byName map[string]*Port) {
+	base := `SYSTEM\CurrentControlSet\Enum\` + bus
+	for _, dev := range subKeys(base) {
+		m := usbKey.FindStringSubmatch(dev)
+		if m == nil {
+			continue
+		}
+		vid, _ := strconv.ParseUint(m[1], 16, 16)
+		pid, _ := strconv.ParseUint(m[2], 16, 16)
+		for _, inst := range subKeys(base + `\` + dev) {
+			instKey := base + `\` + dev + `\` + inst
+			p := byName[stringValue(instKey+`\Device Parameters`, "PortName")]
+			if p == nil {
+				continue
+			}
+			p.VID, p.PID = uint16(vid), uint16(pid)
+			p.Description = stringValue(instKey, "FriendlyName")
+			p.Manufacturer = stringValue(instKey, "Mfg")
+			switch {
+			case m[3] != "":
+				// FTDI's driver appends the port letter to the serial.
+				p.Serial = strings.TrimSuffix(m[3], "A")
+			case !strings.Contains(inst, "&"):
+				// Instance IDs containing '&' are made up by Windows
+				// for devices without a serial number.
+				p.Serial = inst
+			}
+		}
+	}
+}
+
+func openKey(root syscall.Handle, path string) (syscall.Handle, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var k syscall.Handle
+	err = syscall.RegOpenKeyEx(root, p, 0, syscall.KEY_READ, &k)
+	return k, err
+}
+
+// subKeys lists the subkeys of HKLM\path, or nothing on error.
+func subKeys(path string) []string {
+	k, err := openKey(syscall.HKEY_LOCAL_MACHINE, path)
+	if err != nil {
+		return nil
+	}
+	defer syscall.RegCloseKey(k)
+	var keys []string
+	for i := uint32(0); ; i++ {
+		var name [256]uint16
+		n := uint32(len(name))
+		if syscall.RegEnumKeyEx(k, i, &name[0], &n, nil, nil, nil, nil) != nil {
+			return keys
+		}
+		keys = append(keys, syscall.UTF16ToString(name[:n]))
+	}
+}
+
+// stringValue reads a string value from HKLM\path, or "" on error.
+// Values such as Mfg may hold a resource reference like
+// "@oem.inf,%ftdi%;FTDI"; only the text after the last ';' is kept.
+func stringValue(path, name string) string {
+	k, err := openKey(syscall.HKEY_LOCAL_MACHINE, path)
+	if err != nil {
+		return ""
+	}
+	defer syscall.RegCloseKey(k)
+	np, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return ""
+	}
+	var buf [512]uint16
+	n := uint32(len(buf) * 2)
+	var typ uint32
+	if syscall.RegQueryValueEx(k, np, nil, &typ, (*byte)(unsafe.Pointer(&buf[0])), &n) != nil || typ != syscall.REG_SZ {
+		return ""
+	}
+	s := syscall.UTF16ToString(buf[:n/2])
+	if i := strings.LastIndexByte(s, ';'); i >= 0 && strings.HasPrefix(s, "@") {
+		s = s[i+1:]
+	}
+	return s
+}
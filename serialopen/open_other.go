@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package serialopen
+
+import (
+	"io"
+	"os"
+)
+
+// Open opens the serial device at path for reading and writing. The
+// line settings are left as they are.
+func Open(path string) (io.ReadWriteCloser, error) {
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
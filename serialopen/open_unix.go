@@ -0,0 +1,16 @@
+//go:build unix
+
+package serialopen
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// Open opens the serial device at path for reading and writing,
+// without making it the controlling terminal. The line settings are
+// left as they are.
+func Open(path string) (io.ReadWriteCloser, error) {
+	return os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+}
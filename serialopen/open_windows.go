@@ -0,0 +1,199 @@
+package serialopen
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEventW        = kernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = kernel32.NewProc("GetOverlappedResult")
+	procSetCommTimeouts     = kernel32.NewProc("SetCommTimeouts")
+)
+
+// commTimeouts is COMMTIMEOUTS.
+type commTimeouts struct {
+	ReadIntervalTimeout         uint32
+	ReadTotalTimeoutMultiplier  uint32
+	ReadTotalTimeoutConstant    uint32
+	WriteTotalTimeoutMultiplier uint32
+	WriteTotalTimeoutConstant   uint32
+}
+
+// Open opens a COM port for reading and writing with overlapped I/O,
+// so that Close interrupts a Read or Write in progress. path may be a
+// bare port name such as "COM3"; the line settings are left as they
+// are.
+//
+// When a USB adapter is pulled out, pending and later reads and
+// writes fail, the session ends, and turnstile goes back to opening
+// the port, which fails with fs.ErrNotExist until the adapter is back.
+// Pair Open with ByUSB, whose ErrNotFound is retried by
+// turnstile.RetryTransient, or don't use RetryTransient, if the port
+// should be waited for.
+func Open(path string) (io.ReadWriteCloser, error) {
+	if !strings.HasPrefix(path, `\\.\`) {
+		// Needed for COM10 and up, harmless for the rest.
+		path = `\\.\` + path
+	}
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	p := &comPort{h: h, path: path}
+	// Have a read return as soon as at least one byte is in.
+	t := commTimeouts{
+		ReadIntervalTimeout:        ^uint32(0),
+		ReadTotalTimeoutMultiplier: ^uint32(0),
+		ReadTotalTimeoutConstant:   ^uint32(0) - 1,
+	}
+	if r, _, e := procSetCommTimeouts.Call(uintptr(h), uintptr(unsafe.Pointer(&t))); r == 0 {
+		syscall.CloseHandle(h)
+		return nil, os.NewSyscallError("SetCommTimeouts", e)
+	}
+	if p.rev, err = newEvent(); err == nil {
+		p.wev, err = newEvent()
+	}
+	if err != nil {
+		p.closeHandles()
+		return nil, err
+	}
+	return p, nil
+}
+
+func newEvent() (syscall.Handle, error) {
+	// Manual reset, initially not signaled.
+	h, _, e := procCreateEventW.Call(0, 1, 0, 0)
+	if h == 0 {
+		return 0, os.NewSyscallError("CreateEvent", e)
+	}
+	return syscall.Handle(h), nil
+}
+
+// comPort is an open COM port.
+type comPort struct {
+	h        syscall.Handle
+	path     string
+	rev, wev syscall.Handle // events for overlapped reads and writes
+
+	rmu, wmu sync.Mutex
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (p *comPort) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// io runs one overlapped operation and waits for it.
+func (p *comPort) io(op func(*syscall.Overlapped, *uint32) error, ev syscall.Handle) (int, error) {
+	o := &syscall.Overlapped{HEvent: ev}
+	var n uint32
+	err := op(o, &n)
+	if err == syscall.ERROR_IO_PENDING {
+		r, _, e := procGetOverlappedResult.Call(uintptr(p.h), uintptr(unsafe.Pointer(o)), uintptr(unsafe.Pointer(&n)), 1)
+		err = nil
+		if r == 0 {
+			err = e
+		}
+	}
+	if err != nil {
+		if p.isClosed() {
+			return int(n), os.ErrClosed
+		}
+		return int(n), &os.PathError{Op: "io", Path: p.path, Err: err}
+	}
+	return int(n), nil
+}
+
+func (p *comPort) Read(b []byte) (int, error) {
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		if p.isClosed() {
+			return 0, os.ErrClosed
+		}
+		n, err := p.io(func(o *syscall.Overlapped, n *uint32) error {
+			return syscall.ReadFile(p.h, b, n, o)
+		}, p.rev)
+		if n > 0 || err != nil {
+			return n, err
+		}
+		// The read timed out empty-handed; go again.
+	}
+}
+
+func (p *comPort) Write(b []byte) (int, error) {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	written := 0
+	for written < len(b) {
+		if p.isClosed() {
+			return written, os.ErrClosed
+		}
+		n, err := p.io(func(o *syscall.Overlapped, n *uint32) error {
+			return syscall.WriteFile(p.h, b[written:], n, o)
+		}, p.wev)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close cancels any Read or Write in progress and closes the port.
+func (p *comPort) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return os.ErrClosed
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	// Keep cancelling until the readers and writers are out, in case
+	// one started an operation just after the first cancel.
+	for !p.rmu.TryLock() || !p.tryLockW() {
+		syscall.CancelIoEx(p.h, nil)
+		time.Sleep(time.Millisecond)
+	}
+	defer p.rmu.Unlock()
+	defer p.wmu.Unlock()
+	return p.closeHandles()
+}
+
+// tryLockW is wmu.TryLock, giving rmu back if wmu is busy.
+func (p *comPort) tryLockW() bool {
+	if p.wmu.TryLock() {
+		return true
+	}
+	p.rmu.Unlock()
+	return false
+}
+
+func (p *comPort) closeHandles() error {
+	for _, ev := range []syscall.Handle{p.rev, p.wev} {
+		if ev != 0 {
+			syscall.CloseHandle(ev)
+		}
+	}
+	return syscall.CloseHandle(p.h)
+}
@@ -0,0 +1,51 @@
+package serialopen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/sparques/turnstile"
+)
+
+var (
+	// ErrPairing is wrapped by errors from RFCOMM when the device
+	// refused the connection for lack of pairing or authentication.
+	// Retrying won't help until the devices are paired; the error
+	// also matches fs.ErrPermission, so turnstile.RetryTransient gives
+	// up on it.
+	ErrPairing = errors.New("serialopen: bluetooth pairing or authentication failed")
+
+	// ErrUnreachable is wrapped by errors from RFCOMM when the device
+	// is switched off or out of range. This is usually temporary.
+	ErrUnreachable = errors.New("serialopen: bluetooth device unreachable")
+)
+
+// DefaultConnectTimeout is how long RFCOMM waits for a connection
+// when not told otherwise.
+const DefaultConnectTimeout = 10 * time.Second
+
+// RFCOMM returns an OpenFunc that connects to the Bluetooth serial
+// service on channel at the device with the given address, such as
+// "00:11:22:33:44:55", giving up after timeout (zero means
+// DefaultConnectTimeout). The devices must already be paired if the
+// service requires it. RFCOMM sockets are only supported on Linux;
+// elsewhere the OpenFunc fails with errors.ErrUnsupported.
+func RFCOMM(addr string, channel uint8, timeout time.Duration) turnstile.OpenFunc {
+	if timeout <= 0 {
+		timeout = DefaultConnectTimeout
+	}
+	return func() (io.ReadWriteCloser, error) {
+		mac, err := net.ParseMAC(addr)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("serialopen: bad bluetooth address %q", addr)
+		}
+		rwc, err := dialRFCOMM([6]byte(mac), channel, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("rfcomm %s channel %d: %w", addr, channel, err)
+		}
+		return rwc, nil
+	}
+}
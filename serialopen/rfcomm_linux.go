@@ -0,0 +1,89 @@
+package serialopen
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	afBluetooth   = 31
+	btprotoRFCOMM = 3
+)
+
+// sockaddrRC is struct sockaddr_rc.
+type sockaddrRC struct {
+	family  uint16
+	bdaddr  [6]byte // least significant byte first
+	channel uint8
+	_       byte
+}
+
+func dialRFCOMM(mac [6]byte, channel uint8, timeout time.Duration) (io.ReadWriteCloser, error) {
+	fd, err := syscall.Socket(afBluetooth, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, btprotoRFCOMM)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	sa := sockaddrRC{family: afBluetooth, channel: channel}
+	for i := range mac {
+		sa.bdaddr[i] = mac[5-i]
+	}
+	errno := connect(uintptr(fd), unsafe.Pointer(&sa), unsafe.Sizeof(sa))
+	if errno != 0 && errno != syscall.EINPROGRESS {
+		syscall.Close(fd)
+		return nil, classify(errno)
+	}
+
+	// The socket is non-blocking, so the file goes through the runtime
+	// poller and its deadline bounds the wait for the connection.
+	f := os.NewFile(uintptr(fd), "rfcomm")
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	f.SetWriteDeadline(time.Now().Add(timeout))
+	var soErr int
+	werr := rc.Write(func(fd uintptr) bool {
+		soErr, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR)
+		return err != nil || soErr != 0 || connected(fd)
+	})
+	f.SetWriteDeadline(time.Time{})
+	switch {
+	case werr != nil:
+		err = fmt.Errorf("%w: %w", ErrUnreachable, werr)
+	case err != nil:
+		err = os.NewSyscallError("getsockopt", err)
+	case soErr != 0:
+		err = classify(syscall.Errno(soErr))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// connected reports whether the socket has a peer yet; the poller may
+// wake us before the connection completes.
+func connected(fd uintptr) bool {
+	var sa sockaddrRC
+	n := uint32(unsafe.Sizeof(sa))
+	return getpeername(fd, unsafe.Pointer(&sa), &n) == 0
+}
+
+// classify wraps errno with ErrPairing or ErrUnreachable where it tells
+// us which went wrong.
+func classify(errno syscall.Errno) error {
+	switch errno {
+	case syscall.EACCES, syscall.EPERM:
+		// BlueZ reports failed authentication or encryption like this.
+		return fmt.Errorf("%w: %w", ErrPairing, os.NewSyscallError("connect", errno))
+	case syscall.EHOSTDOWN, syscall.EHOSTUNREACH, syscall.ETIMEDOUT:
+		return fmt.Errorf("%w: %w", ErrUnreachable, os.NewSyscallError("connect", errno))
+	}
+	return os.NewSyscallError("connect", errno)
+}
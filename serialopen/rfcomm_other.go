@@ -0,0 +1,14 @@
+//go:build !linux
+
+package serialopen
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// dialRFCOMM is only implemented on Linux.
+func dialRFCOMM(mac [6]byte, channel uint8, timeout time.Duration) (io.ReadWriteCloser, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,152 @@
+// Package serialopen finds serial ports and builds turnstile OpenFuncs
+// for them.
+//
+// USB serial adapters often come back under a different name after
+// being unplugged, /dev/ttyUSB1 instead of /dev/ttyUSB0, say. The
+// OpenFuncs from ByUSB and ByGlob look the device up again on every
+// open, so the link follows it:
+//
+//	open := serialopen.ByUSB(serialopen.USB{VID: 0x0403, PID: 0x6001, Serial: "A50285BI"}, nil)
+//	d := turnstile.NewReopenDialer(open, "ftdi")
+//
+// RFCOMM connects to Bluetooth serial devices, which drop out far more
+// often than cables do; turnstile reconnects them like any other port.
+//
+// Listing ports is supported on Linux, from sysfs, and on Windows, from
+// the registry. On Windows, Open uses overlapped I/O so closing a
+// session interrupts a blocked Read.
+//
+// Line settings such as the baud rate are left to the opener, which is
+// where a full serial package (go.bug.st/serial, for instance) plugs in.
+package serialopen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/sparques/turnstile"
+)
+
+// ErrNotFound is returned by the OpenFuncs in this package when no
+// device matches. It deliberately doesn't match fs.ErrNotExist, so
+// turnstile.RetryTransient keeps retrying until the device is plugged
+// back in.
+var ErrNotFound = errors.New("serialopen: no matching device")
+
+// Port describes a serial port found by List.
+type Port struct {
+	Path string // the device to open, e.g. /dev/ttyUSB0 or COM3
+
+	// Description is a human-readable name, if the system has one.
+	Description string
+
+	// USB attributes; all zero for ports that aren't USB devices.
+	VID, PID     uint16
+	Serial       string
+	Manufacturer string
+	Product      string
+}
+
+// IsUSB reports whether p is a USB device.
+func (p Port) IsUSB() bool { return p.VID != 0 }
+
+func (p Port) String() string {
+	if !p.IsUSB() {
+		return p.Path
+	}
+	s := fmt.Sprintf("%s %04x:%04x", p.Path, p.VID, p.PID)
+	if p.Serial != "" {
+		s += " " + p.Serial
+	}
+	return s
+}
+
+// List returns the serial ports present, sorted by path.
+func List() ([]Port, error) {
+	ports, err := list()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Path < ports[j].Path })
+	return ports, nil
+}
+
+// USB identifies a USB serial device. Zero fields match anything.
+type USB struct {
+	VID, PID uint16
+	Serial   string
+}
+
+func (u USB) matches(p Port) bool {
+	return p.IsUSB() &&
+		(u.VID == 0 || u.VID == p.VID) &&
+		(u.PID == 0 || u.PID == p.PID) &&
+		(u.Serial == "" || u.Serial == p.Serial)
+}
+
+// Find returns the path of the first port, in path order, that
+// matches u.
+func Find(u USB) (string, error) {
+	ports, err := List()
+	if err != nil {
+		return "", err
+	}
+	for _, p := range ports {
+		if u.matches(p) {
+			return p.Path, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Opener opens the serial device at path. A nil Opener means Open.
+type Opener func(path string) (io.ReadWriteCloser, error)
+
+func (o Opener) open(path string) (io.ReadWriteCloser, error) {
+	if o == nil {
+		return Open(path)
+	}
+	return o(path)
+}
+
+// ByPath returns an OpenFunc that opens the device at path with open,
+// e.g. serialopen.ByPath("COM3", nil).
+func ByPath(path string, open Opener) turnstile.OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		return open.open(path)
+	}
+}
+
+// ByUSB returns an OpenFunc that looks for a device matching u on
+// every call and opens it with open.
+func ByUSB(u USB, open Opener) turnstile.OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		path, err := Find(u)
+		if err != nil {
+			return nil, err
+		}
+		return open.open(path)
+	}
+}
+
+// ByGlob returns an OpenFunc that expands pattern on every call and
+// opens the first match, in lexical order, with open. Linux's
+// /dev/serial/by-id links make good patterns, as they name the device
+// by vendor, model, and serial number:
+//
+//	serialopen.ByGlob("/dev/serial/by-id/usb-FTDI_*_A50285BI-if00-port0", nil)
+func ByGlob(pattern string, open Opener) turnstile.OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, ErrNotFound
+		}
+		return open.open(matches[0])
+	}
+}
@@ -0,0 +1,21 @@
+//go:build linux && !386
+
+package serialopen
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// connect calls connect(2) with a raw sockaddr, since the syscall
+// package has no Sockaddr for Bluetooth.
+func connect(fd uintptr, sa unsafe.Pointer, n uintptr) syscall.Errno {
+	_, _, errno := syscall.Syscall(syscall.SYS_CONNECT, fd, uintptr(sa), n)
+	return errno
+}
+
+// getpeername calls getpeername(2) with a raw sockaddr.
+func getpeername(fd uintptr, sa unsafe.Pointer, n *uint32) syscall.Errno {
+	_, _, errno := syscall.Syscall(syscall.SYS_GETPEERNAME, fd, uintptr(sa), uintptr(unsafe.Pointer(n)))
+	return errno
+}
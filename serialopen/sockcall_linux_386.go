@@ -0,0 +1,24 @@
+package serialopen
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// On 386 the socket calls are multiplexed through socketcall(2).
+const (
+	sysConnect     = 3
+	sysGetpeername = 7
+)
+
+func connect(fd uintptr, sa unsafe.Pointer, n uintptr) syscall.Errno {
+	args := [3]uintptr{fd, uintptr(sa), n}
+	_, _, errno := syscall.Syscall(syscall.SYS_SOCKETCALL, sysConnect, uintptr(unsafe.Pointer(&args)), 0)
+	return errno
+}
+
+func getpeername(fd uintptr, sa unsafe.Pointer, n *uint32) syscall.Errno {
+	args := [3]uintptr{fd, uintptr(sa), uintptr(unsafe.Pointer(n))}
+	_, _, errno := syscall.Syscall(syscall.SYS_SOCKETCALL, sysGetpeername, uintptr(unsafe.Pointer(&args)), 0)
+	return errno
+}
@@ -0,0 +1,22 @@
+package serialopen
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/sparques/turnstile"
+)
+
+// Unix returns an OpenFunc that connects to the unix socket at path,
+// such as a QEMU serial chardev (-serial unix:/tmp/ttyS0.sock,server)
+// or a socat bridge, which makes it easy to point turnstile at an
+// emulated device in CI. On Linux a path starting with '@' names a
+// socket in the abstract namespace. Each attempt gives up after
+// timeout; zero means no limit beyond the operating system's.
+func Unix(path string, timeout time.Duration) turnstile.OpenFunc {
+	d := net.Dialer{Timeout: timeout}
+	return func() (io.ReadWriteCloser, error) {
+		return d.Dial("unix", path)
+	}
+}
@@ -1,8 +1,11 @@
 package turnstile
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net"
+	"os"
 	"sync"
 	"time"
 )
@@ -11,107 +14,307 @@ import (
 //  - returns exactly one active net.Conn at a time
 //  - blocks Accept() until that conn is closed
 //  - re-opens on the next Accept() after close, with optional backoff
+//  - hands the conn to blocked Accept() callers in the order they arrived
 
 type OpenFunc func() (io.ReadWriteCloser, error)
 
-type reopenListener struct {
-	open   OpenFunc
-	addr   net.Addr
-	mu     sync.Mutex
-	closed bool
-	// closedCh is non-nil while a connection is active; it is closed when that conn closes.
-	closedCh chan struct{}
+type ReopenListener struct {
+	*core
+
+	noopReopen    bool // open hands back the same io.ReadWriter every time
+	resetRequired bool
+
+	mu       sync.Mutex
+	spent    bool          // a session ended and Reset hasn't been called yet
+	resetCh  chan struct{} // closed by Reset
+	deadline time.Time     // for Accept; see SetDeadline
 }
 
-func NewReopenListener(open OpenFunc, name string) net.Listener {
-	return &reopenListener{
-		open: open,
-		addr: serialAddr(name),
+func NewReopenListener(open OpenFunc, name string, opts ...Option) *ReopenListener {
+	o := newOptions(opts)
+	l := &ReopenListener{
+		core:          newCore(open, name, o),
+		resetRequired: o.resetRequired,
 	}
+	l.core.onData = o.acceptOnData
+	return l
+}
+
+// NewOneShotListener returns a listener that serves a single session.
+// Once that session's conn is closed, Accept returns net.ErrClosed.
+func NewOneShotListener(open OpenFunc, name string, opts ...Option) *ReopenListener {
+	return NewReopenListener(open, name, append(opts, WithMaxSessions(1))...)
 }
 
-func NewReadWriterListener(rw io.ReadWriter, name string) net.Listener {
-	return &reopenListener{
-		open: func() (io.ReadWriteCloser, error) {
-			return rwNilCloser{rw}, nil
-		},
-		addr: serialAddr(name),
+// NewReadWriterListener serves rw as a listener. Since rw can't be
+// re-opened, every session reads from and writes to the same stream;
+// if rw hits EOF, later sessions will too. Use WithResetRequired to
+// stop Accept from handing out sessions over a stream that hasn't
+// been re-armed.
+func NewReadWriterListener(rw io.ReadWriter, name string, opts ...Option) *ReopenListener {
+	l := NewReopenListener(func() (io.ReadWriteCloser, error) {
+		return rwNilCloser{rw}, nil
+	}, name, opts...)
+	l.noopReopen = true
+	return l
+}
+
+func (l *ReopenListener) Addr() net.Addr { return l.addr }
+
+// Reconfigure replaces the OpenFunc, e.g. to change the device path or
+// baud rate at runtime. The new function is used from the next open
+// on; if closeActive is set, the active conn (if any) is closed so
+// that happens right away. This pairs well with a signal handler:
+//
+//	sig := make(chan os.Signal, 1)
+//	signal.Notify(sig, syscall.SIGHUP)
+//	go func() {
+//		for range sig {
+//			l.Reconfigure(loadOpenFunc(), true)
+//		}
+//	}()
+func (l *ReopenListener) Reconfigure(open OpenFunc, closeActive bool) {
+	l.reconfigure(open, closeActive)
+}
+
+// QueueLength returns the number of Accept calls currently waiting
+// for the active conn to close.
+func (l *ReopenListener) QueueLength() int { return l.gate.queueLength() }
+
+// Pause stops the listener from handing out new sessions, e.g. while
+// an operator flashes firmware over the same port out-of-band. While
+// paused, Accept calls block (or fail with ErrPaused, WithPauseError) and
+// the device isn't opened. An active conn is left alone; close it or
+// wait for State().Active to turn false before touching the device.
+func (l *ReopenListener) Pause() { l.pause() }
+
+// Resume undoes Pause.
+func (l *ReopenListener) Resume() { l.resume() }
+
+// Exclusive waits its turn like Accept, then opens the device and runs
+// fn with raw access to it, e.g. to flash firmware or talk to a
+// bootloader between regular sessions. The device is closed when fn
+// returns and normal service carries on; fn's error is returned. No
+// health check, middleware, or session accounting applies, and
+// Exclusive works even while paused, so Pause followed by Exclusive
+// keeps regular sessions out until Resume.
+func (l *ReopenListener) Exclusive(ctx context.Context, fn func(io.ReadWriteCloser) error) error {
+	return l.exclusive(ctx, fn)
+}
+
+// WaitFree blocks until no session is active and nobody is waiting
+// for one, e.g. to schedule maintenance for the moment the port goes
+// idle. It returns ctx's error if ctx is done first, or net.ErrClosed
+// if the listener is closed. The slot may be taken again right away; use
+// Exclusive to hold on to it.
+func (l *ReopenListener) WaitFree(ctx context.Context) error { return l.gate.waitFree(ctx) }
+
+// Notify sends EventBusy and EventFree to ch as the listener goes in and
+// out of use. Sends don't block, so events are dropped if ch isn't
+// ready; give it a buffer. Call StopNotify to stop.
+func (l *ReopenListener) Notify(ch chan<- Event) { l.gate.addNotify(ch) }
+
+// StopNotify stops sending events to ch.
+func (l *ReopenListener) StopNotify(ch chan<- Event) { l.gate.removeNotify(ch) }
+
+// State returns a snapshot of the listener's state.
+func (l *ReopenListener) State() State { return l.state() }
+
+// String describes the listener for logs: its device, whether it is
+// idle, active, paused, or closed, and how many Accept calls are waiting
+// and sessions have been handed out.
+func (l *ReopenListener) String() string { return l.describe("listener") }
+
+// Sessions returns the summaries of the latest sessions, oldest first,
+// as kept WithSessionHistory.
+func (l *ReopenListener) Sessions() []SessionSummary { return l.history.list() }
+
+// NoopReopen reports whether re-opening is a no-op, i.e. the listener
+// was created by NewReadWriterListener and every session shares the
+// same underlying stream.
+func (l *ReopenListener) NoopReopen() bool { return l.noopReopen }
+
+// Reset re-arms a listener created WithResetRequired, letting the next
+// Accept hand out a new session. It is a no-op otherwise.
+func (l *ReopenListener) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.spent {
+		l.spent = false
+		close(l.resetCh)
 	}
 }
 
-func (l *reopenListener) Addr() net.Addr { return l.addr }
+// sessionEnded records that a session finished, so a listener created
+// WithResetRequired will wait for Reset.
+func (l *ReopenListener) sessionEnded() {
+	if !l.resetRequired {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.spent {
+		l.spent = true
+		l.resetCh = make(chan struct{})
+	}
+}
 
-func (l *reopenListener) Close() error {
+// waitReset blocks until the listener has been Reset, if required.
+func (l *ReopenListener) waitReset(ctx context.Context) error {
 	l.mu.Lock()
-	l.closed = true
-	ch := l.closedCh
+	spent, ch := l.spent, l.resetCh
 	l.mu.Unlock()
-	// Wake any Accept() blocked on current connection finishing.
-	if ch != nil {
-		select {
-		case <-ch:
-		default:
-			close(ch)
-		}
+	if !spent {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-l.gate.done:
+		return net.ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
+
+func (l *ReopenListener) Close() error {
+	// Wake any Accept() blocked on current connection finishing.
+	return l.close()
+}
+
+// Accept waits for the previous session's conn to be closed, then opens
+// the device and returns the conn for a new session. It is safe to call
+// from several goroutines, e.g. several http.Servers sharing one
+// listener: callers are served one at a time in the order they called,
+// each closed conn handing the slot straight to the next waiting
+// caller, so none is skipped or woken for nothing. A caller whose
+// session an accept interceptor refuses goes back to the end of the
+// line. Once the listener is closed, every waiting and later call
+// returns net.ErrClosed.
+func (l *ReopenListener) Accept() (net.Conn, error) {
+	return l.acceptWithin(context.Background(), nil)
+}
+
+// AcceptContext is like Accept, but stops waiting when ctx is cancelled.
+// The returned conn is bound to ctx: it is closed automatically when
+// ctx is done, which suits request- or job-scoped code.
+func (l *ReopenListener) AcceptContext(ctx context.Context) (net.Conn, error) {
+	return l.acceptWithin(ctx, ctx)
+}
+
+// Session is Accept for callers that don't need a net.Conn, such as
+// code driving its own protocol over the device: the session is the
+// same, with the same one-at-a-time hand-off, interceptors, and
+// middleware, but is returned as a plain io.ReadWriteCloser. Closing it
+// ends the session. It is the very conn Accept would return, so with
+// WithConnMiddleware it is whatever the middleware returned, not the
+// *Conn underneath.
+func (l *ReopenListener) Session() (io.ReadWriteCloser, error) {
+	return l.SessionContext(context.Background())
+}
+
+// SessionContext is like Session, but stops waiting when ctx is
+// cancelled. Unlike AcceptContext, it doesn't bind the session to ctx.
+func (l *ReopenListener) SessionContext(ctx context.Context) (io.ReadWriteCloser, error) {
+	return l.acceptWithin(ctx, nil)
+}
+
+// SetDeadline sets the deadline for Accept and AcceptContext calls made
+// from now on, like net.TCPListener's: once it has passed, they fail
+// with a timeout error wrapping os.ErrDeadlineExceeded instead of
+// waiting on. The zero time means no deadline.
+func (l *ReopenListener) SetDeadline(t time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deadline = t
 	return nil
 }
 
-func (l *reopenListener) Accept() (net.Conn, error) {
+// acceptWithin accepts a session, waiting no longer than ctx, the
+// deadline, and WithAcceptTimeout allow, and binds it to bind, if set.
+func (l *ReopenListener) acceptWithin(ctx, bind context.Context) (net.Conn, error) {
 	l.mu.Lock()
-	if l.closed {
-		l.mu.Unlock()
-		return nil, net.ErrClosed
+	deadline := l.deadline
+	l.mu.Unlock()
+	if d := l.opts.acceptTimeout; d > 0 {
+		if t := time.Now().Add(d); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
 	}
-	// If a connection is already active, wait for it to close.
-	if ch := l.closedCh; ch != nil {
-		l.mu.Unlock()
-		<-ch
-		l.mu.Lock()
-		if l.closed {
-			l.mu.Unlock()
-			return nil, net.ErrClosed
+	wctx := ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		wctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	c, err := l.acceptIntercepted(wctx, bind)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && wctx.Err() != nil && ctx.Err() == nil {
+			// Our deadline, not the caller's context.
+			err = os.ErrDeadlineExceeded
 		}
+		return nil, l.opts.acceptError(err)
 	}
-	// No active conn; we become the one to open it.
-	l.mu.Unlock()
+	return l.opts.wrap(c), nil
+}
 
-	// Retry loop to open the underlying port with backoff.
-	backoff := 100 * time.Millisecond
+// Serve accepts sessions one after another and runs handler on each,
+// closing the conn once handler returns, until ctx is done or the
+// listener is closed. Errors from handler are passed to the function
+// set WithServeErrorHandler, if any, and don't stop Serve. Temporary
+// accept errors (see AcceptError) are retried after a short backoff;
+// any other ends Serve, which returns it. Each conn is bound to ctx as
+// by AcceptContext, so cancelling ctx also ends the session in
+// progress; Serve then returns ctx.Err().
+func (l *ReopenListener) Serve(ctx context.Context, handler func(net.Conn) error) error {
+	var backoff time.Duration
 	for {
-		if c, err := l.open(); err == nil {
-			l.mu.Lock()
-			if l.closed {
-				l.mu.Unlock()
-				c.Close()
-				return nil, net.ErrClosed
-			}
-			ch := make(chan struct{})
-			l.closedCh = ch
-			l.mu.Unlock()
-
-			rc := &rwConn{
-				ReadWriteCloser: c,
-				local:           l.addr,
-				remote:          serialAddr("peer"),
-				onClose: func() {
-					l.mu.Lock()
-					if l.closedCh != nil {
-						close(l.closedCh)
-						l.closedCh = nil
-					}
-					l.mu.Unlock()
-				},
+		c, err := l.AcceptContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-			return rc, nil
-		} else {
-			if l.closed {
-				return nil, net.ErrClosed
+			var ae *AcceptError
+			if !errors.As(err, &ae) || !ae.Temporary() {
+				return err
 			}
-			time.Sleep(backoff)
-			if backoff < 2*time.Second {
-				backoff *= 2
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
 			}
+			continue
+		}
+		backoff = 0
+		err = handler(c)
+		c.Close()
+		if err != nil && l.opts.serveErrs != nil {
+			l.opts.serveErrs(c, err)
+		}
+	}
+}
+
+// acceptIntercepted accepts sessions until one gets past the
+// interceptors. If bind is set, each conn is bound to it before the
+// interceptors see it, so cancelling bind also cuts short a slow one.
+func (l *ReopenListener) acceptIntercepted(ctx, bind context.Context) (*Conn, error) {
+	for {
+		c, err := l.accept(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if bind != nil {
+			c.closeOnDone(bind)
 		}
+		if err := l.opts.intercept(c); err != nil {
+			c.Close()
+			continue
+		}
+		return c, nil
 	}
 }
+
+func (l *ReopenListener) accept(ctx context.Context) (*Conn, error) {
+	return l.session(ctx, 0, serialAddr{l.addr.Network(), "peer"}, l.waitReset, l.sessionEnded)
+}
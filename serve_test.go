@@ -0,0 +1,166 @@
+package turnstile
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n callers are queued on l.
+func waitQueued(t *testing.T, l *ReopenListener, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for l.QueueLength() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers queued, want %d", l.QueueLength(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestAcceptStress queues waiters on Accept, hands the slot down the
+// line a few times, then closes the listener: the waiters must be
+// served in the order they called, and the rest must all give up with
+// net.ErrClosed.
+func TestAcceptStress(t *testing.T) {
+	const waiters = 8
+	for served := range waiters + 1 {
+		var devs devices
+		l := NewReopenListener(devs.open(), "test")
+		held, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		type accepted struct {
+			i int
+			c net.Conn
+		}
+		got := make(chan accepted, waiters)
+		errs := make(chan error, waiters)
+		var wg sync.WaitGroup
+		for i := range waiters {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, err := l.Accept()
+				if err != nil {
+					errs <- err
+					return
+				}
+				got <- accepted{i, c}
+			}()
+			waitQueued(t, l, i+1)
+		}
+
+		for want := range served {
+			held.Close()
+			select {
+			case a := <-got:
+				if a.i != want {
+					t.Fatalf("waiter %d served, want %d", a.i, want)
+				}
+				held = a.c
+			case err := <-errs:
+				t.Fatalf("waiter failed: %v", err)
+			case <-time.After(10 * time.Second):
+				t.Fatalf("waiter %d never served", want)
+			}
+		}
+		l.Close()
+		held.Close()
+		waitOrFail(t, &wg)
+
+		if len(got) != 0 {
+			t.Fatalf("waiter %d served after Close", (<-got).i)
+		}
+		if n := len(errs); n != waiters-served {
+			t.Fatalf("%d waiters failed, want %d", n, waiters-served)
+		}
+		for range waiters - served {
+			if err := <-errs; !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("waiter got %v, want net.ErrClosed", err)
+			}
+		}
+		devs.check(t)
+	}
+}
+
+// TestAcceptCloseWhileWaiting closes the listener while callers wait
+// on Accept, just as the active conn closes: every caller must return,
+// each either served or with net.ErrClosed.
+func TestAcceptCloseWhileWaiting(t *testing.T) {
+	const waiters = 8
+	for range 50 {
+		var devs devices
+		l := NewReopenListener(devs.open(), "test")
+		held, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for range waiters {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, err := l.Accept()
+				if err != nil {
+					if !errors.Is(err, net.ErrClosed) {
+						t.Error(err)
+					}
+					return
+				}
+				c.Close()
+			}()
+		}
+		waitQueued(t, l, waiters)
+		if n := closeAll(t, held, 1, l.Close); n != 1 {
+			t.Fatal("Close failed")
+		}
+		waitOrFail(t, &wg)
+		devs.check(t)
+		if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("Accept after Close: %v", err)
+		}
+	}
+}
+
+// TestAcceptDeadlineKeepsError has the open fail only after the accept
+// timeout has passed: Accept must report the open's error, not a
+// timeout.
+func TestAcceptDeadlineKeepsError(t *testing.T) {
+	errOpen := errors.New("open failed")
+	l := NewReopenListener(func() (io.ReadWriteCloser, error) {
+		time.Sleep(50 * time.Millisecond)
+		return nil, errOpen
+	}, "test", WithFailFast(), WithAcceptTimeout(10*time.Millisecond))
+	defer l.Close()
+	_, err := l.Accept()
+	if !errors.Is(err, errOpen) || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Accept: %v, want the open error", err)
+	}
+}
+
+// TestSessionMiddleware checks that Session returns the conn the
+// middleware made, as Accept does.
+func TestSessionMiddleware(t *testing.T) {
+	type wrapped struct{ net.Conn }
+	var devs devices
+	l := NewReopenListener(devs.open(), "test", WithConnMiddleware(func(c net.Conn) net.Conn {
+		return wrapped{c}
+	}))
+	defer l.Close()
+	s, err := l.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(wrapped); !ok {
+		t.Fatalf("Session returned %T, want the middleware's conn", s)
+	}
+	s.Close()
+	devs.check(t)
+}
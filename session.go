@@ -0,0 +1,188 @@
+package turnstile
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SessionSummary describes a session once its conn has been closed:
+// how long it lasted, how much data crossed it, and what went wrong,
+// for logging or accounting.
+type SessionSummary struct {
+	ID         uint64    // the session's number, counting from 1
+	Start, End time.Time // when the conn was handed out and closed
+	BytesIn    int64     // bytes read from the device
+	BytesOut   int64     // bytes written to the device
+	ReadErr    error     // first error from Read, other than a timeout
+	WriteErr   error     // first error from Write
+	Reason     CloseReason
+}
+
+// Duration returns how long the session lasted.
+func (s SessionSummary) Duration() time.Duration { return s.End.Sub(s.Start) }
+
+// CloseReason says why a session ended, so an application can tell a
+// peer hanging up from a session cut short by the listener or dialer,
+// and decide whether to retry or alert.
+type CloseReason int
+
+const (
+	// ReasonNone means the conn is still open.
+	ReasonNone CloseReason = iota
+
+	// ReasonLocal means Close was called on the conn.
+	ReasonLocal
+
+	// ReasonPeerEOF means the device reported end of file, e.g. the
+	// far end of a socket hung up, before the conn was closed.
+	ReasonPeerEOF
+
+	// ReasonIdle means WithWatchdog closed the conn after it went
+	// quiet.
+	ReasonIdle
+
+	// ReasonPreempted means a higher-priority dial took over the slot
+	// (see WithPreemption).
+	ReasonPreempted
+
+	// ReasonShutdown means the listener or dialer ended the session:
+	// it was reconfigured with closeActive set, or its WithMaxLifetime
+	// ran out.
+	ReasonShutdown
+
+	// ReasonContext means the context the conn was bound to, by
+	// AcceptContext or DialConnContext, was done.
+	ReasonContext
+
+	// ReasonLinked means the conn was closed because the conn it was
+	// linked to by a CloseLinker closed.
+	ReasonLinked
+
+	// ReasonPeerRestarted means the peer started a new session over
+	// this one; see WithSessionToken.
+	ReasonPeerRestarted
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case ReasonNone:
+		return "open"
+	case ReasonLocal:
+		return "closed locally"
+	case ReasonPeerEOF:
+		return "peer EOF"
+	case ReasonIdle:
+		return "idle"
+	case ReasonPreempted:
+		return "preempted"
+	case ReasonShutdown:
+		return "shutdown"
+	case ReasonContext:
+		return "context done"
+	case ReasonLinked:
+		return "linked conn closed"
+	case ReasonPeerRestarted:
+		return "peer restarted"
+	}
+	return fmt.Sprintf("CloseReason(%d)", int(r))
+}
+
+// CloseReason reports why c was closed, or ReasonNone if it is still
+// open. When several causes race, the first one recorded wins; in
+// particular, a conn closed after its device reported EOF reports
+// ReasonPeerEOF however it was then closed.
+func (c *Conn) CloseReason() CloseReason { return CloseReason(c.reason.Load()) }
+
+// WithOnClose calls fn with the summary of every session once its conn
+// has been closed. fn runs in the goroutine that called Close, before
+// the slot is handed on, so it should be quick.
+func WithOnClose(fn func(SessionSummary)) Option {
+	return func(o *options) {
+		o.onClose = fn
+	}
+}
+
+// WithSessionHistory keeps the summaries of the last n sessions, for
+// the listener's or dialer's Sessions method.
+func WithSessionHistory(n int) Option {
+	return func(o *options) {
+		o.history = n
+	}
+}
+
+// summary returns c's SessionSummary as of now.
+func (c *Conn) summary() SessionSummary {
+	s := SessionSummary{
+		ID:       c.id,
+		Start:    c.start,
+		End:      time.Now(),
+		BytesIn:  c.bytesIn.Load(),
+		BytesOut: c.bytesOut.Load(),
+		Reason:   c.CloseReason(),
+	}
+	if err := c.readErr.Load(); err != nil {
+		s.ReadErr = *err
+	}
+	if err := c.writeErr.Load(); err != nil {
+		s.WriteErr = *err
+	}
+	return s
+}
+
+// history is a ring buffer of the latest session summaries.
+type history struct {
+	mu   sync.Mutex
+	ring []SessionSummary
+	next int  // where the next summary goes
+	full bool // ring has wrapped
+}
+
+func newHistory(n int) *history {
+	if n <= 0 {
+		return nil
+	}
+	return &history{ring: make([]SessionSummary, n)}
+}
+
+func (h *history) add(s SessionSummary) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ring[h.next] = s
+	h.next++
+	if h.next == len(h.ring) {
+		h.next, h.full = 0, true
+	}
+}
+
+// list returns the summaries held, oldest first.
+func (h *history) list() []SessionSummary {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]SessionSummary(nil), h.ring[:h.next]...)
+	}
+	return append(append([]SessionSummary(nil), h.ring[h.next:]...), h.ring[:h.next]...)
+}
+
+// recordSession records the summary of c, which has just been closed.
+func (c *core) recordSession(rc *Conn) {
+	if c.opts.onClose == nil && c.history == nil && rc.span == nil && c.opts.journal == nil {
+		return
+	}
+	s := rc.summary()
+	c.opts.journal.sessionEnd(c.addr.String(), s)
+	if rc.span != nil {
+		rc.endSessionSpan(s)
+	}
+	c.history.add(s)
+	if c.opts.onClose != nil {
+		c.opts.onClose(s)
+	}
+}
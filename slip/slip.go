@@ -0,0 +1,197 @@
+// Package slip carries IP packets over a serial link with SLIP framing
+// (RFC 1055), the way slattach does, with the link managed by a
+// turnstile.ReopenDialer so it comes back by itself after the device
+// is unplugged or reset.
+//
+//	tun, err := slip.OpenTUN("sl0")
+//	...
+//	// Configure sl0 as usual, e.g. ip addr add 10.0.0.1 peer 10.0.0.2 dev sl0.
+//	err = slip.Attach(ctx, d, tun, slip.DefaultMTU)
+//
+// TUN devices are only supported on Linux; elsewhere Attach can be
+// given any packet-oriented io.ReadWriter.
+package slip
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/sparques/turnstile"
+)
+
+// SLIP special bytes.
+const (
+	end    = 0xc0
+	esc    = 0xdb
+	escEnd = 0xdc
+	escEsc = 0xdd
+)
+
+// DefaultMTU is the traditional SLIP MTU.
+const DefaultMTU = 1006
+
+// ErrTooLong is returned by ReadPacket for a packet longer than the
+// Reader's MTU. The rest of the packet is discarded.
+var ErrTooLong = errors.New("slip: packet too long")
+
+// Writer writes SLIP framed packets.
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WritePacket writes p as one packet. A leading END flushes any line
+// noise the receiver has picked up since the last packet.
+func (w *Writer) WritePacket(p []byte) error {
+	b := append(w.buf[:0], end)
+	for _, c := range p {
+		switch c {
+		case end:
+			b = append(b, esc, escEnd)
+		case esc:
+			b = append(b, esc, escEsc)
+		default:
+			b = append(b, c)
+		}
+	}
+	b = append(b, end)
+	w.buf = b
+	_, err := w.w.Write(b)
+	return err
+}
+
+// Reader reads SLIP framed packets.
+type Reader struct {
+	r   *bufio.Reader
+	mtu int
+}
+
+// NewReader returns a Reader reading from r that accepts packets of
+// up to mtu bytes.
+func NewReader(r io.Reader, mtu int) *Reader {
+	return &Reader{r: bufio.NewReader(r), mtu: mtu}
+}
+
+// ReadPacket returns the next non-empty packet.
+func (r *Reader) ReadPacket() ([]byte, error) {
+	var p []byte
+	tooLong := false
+	for {
+		c, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch c {
+		case end:
+			if tooLong {
+				return nil, ErrTooLong
+			}
+			if len(p) > 0 {
+				return p, nil
+			}
+			continue
+		case esc:
+			if c, err = r.r.ReadByte(); err != nil {
+				return nil, err
+			}
+			switch c {
+			case escEnd:
+				c = end
+			case escEsc:
+				c = esc
+			}
+		}
+		if len(p) >= r.mtu {
+			tooLong = true
+			continue
+		}
+		p = append(p, c)
+	}
+}
+
+// Attach forwards packets between tun, which must read and write one
+// packet per call as a TUN device does, and SLIP sessions dialed from
+// d, until ctx is cancelled or d is closed. When a session fails, it
+// dials a new one; packets from tun that arrive while the link is
+// down are dropped, as they would be on a real interface.
+func Attach(ctx context.Context, d *turnstile.ReopenDialer, tun io.ReadWriter, mtu int) error {
+	out := make(chan []byte, 64)
+	tunErr := make(chan error, 1)
+	go func() {
+		for {
+			buf := make([]byte, mtu)
+			n, err := tun.Read(buf)
+			if err != nil {
+				tunErr <- err
+				return
+			}
+			select {
+			case out <- buf[:n]:
+			default:
+				// Queue full; drop.
+			}
+		}
+	}()
+
+	for {
+		c, err := d.DialContext(ctx, "slip", "peer")
+		if err != nil {
+			return err
+		}
+		// Drop whatever queued up while the link was down.
+		for len(out) > 0 {
+			<-out
+		}
+
+		linkErr := make(chan error, 2)
+		go func() {
+			r := NewReader(c, mtu)
+			for {
+				p, err := r.ReadPacket()
+				if err == ErrTooLong {
+					continue
+				}
+				if err != nil {
+					linkErr <- err
+					return
+				}
+				tun.Write(p)
+			}
+		}()
+		done := make(chan struct{})
+		go func() {
+			w := NewWriter(c)
+			for {
+				select {
+				case p := <-out:
+					if err := w.WritePacket(p); err != nil {
+						linkErr <- err
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		var stop error
+		select {
+		case <-linkErr:
+		case stop = <-tunErr:
+		case <-ctx.Done():
+			stop = ctx.Err()
+		}
+		close(done)
+		c.Close()
+		if stop != nil {
+			return stop
+		}
+	}
+}
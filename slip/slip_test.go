@@ -0,0 +1,99 @@
+package slip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestEscaping(t *testing.T) {
+	var b bytes.Buffer
+	p := []byte{1, end, 2, esc, 3, esc, end, end}
+	if err := NewWriter(&b).WritePacket(p); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{end, 1, esc, escEnd, 2, esc, escEsc, 3, esc, escEsc, esc, escEnd, esc, escEnd, end}
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Fatalf("wrote % x, want % x", b.Bytes(), want)
+	}
+	got, err := NewReader(&b, DefaultMTU).ReadPacket()
+	if err != nil || !bytes.Equal(got, p) {
+		t.Fatalf("read % x, %v; want % x", got, err, p)
+	}
+}
+
+// TestTooLong reads an oversized packet: it is dropped whole, and the
+// packet after it still comes through.
+func TestTooLong(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	w.WritePacket(bytes.Repeat([]byte{end}, 9))
+	w.WritePacket([]byte("fits"))
+	r := NewReader(&b, 8)
+	if _, err := r.ReadPacket(); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("ReadPacket: %v, want ErrTooLong", err)
+	}
+	if p, err := r.ReadPacket(); err != nil || string(p) != "fits" {
+		t.Fatalf("ReadPacket: %q, %v", p, err)
+	}
+}
+
+// FuzzRoundTrip checks that packets a Writer sends, split from data at
+// each sep byte, are what a Reader reads back.
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte("hello"), byte(0))
+	f.Add([]byte{end, esc, escEnd, escEsc}, byte(end))
+	f.Add([]byte{esc, end, esc, esc, end, end}, byte(esc))
+	f.Fuzz(func(t *testing.T, data []byte, sep byte) {
+		var packets [][]byte
+		for p := range bytes.SplitSeq(data, []byte{sep}) {
+			if len(p) > 0 { // empty packets aren't sent
+				packets = append(packets, p)
+			}
+		}
+		var b bytes.Buffer
+		w := NewWriter(&b)
+		for _, p := range packets {
+			if err := w.WritePacket(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r := NewReader(&b, len(data))
+		for _, want := range packets {
+			got, err := r.ReadPacket()
+			if err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("read % x, %v; want % x", got, err, want)
+			}
+		}
+		if p, err := r.ReadPacket(); err != io.EOF {
+			t.Fatalf("read % x, %v after the last packet; want EOF", p, err)
+		}
+	})
+}
+
+// FuzzReader feeds a Reader line noise: it may reject packets but must
+// never return one over its MTU.
+func FuzzReader(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{end, esc})
+	f.Add([]byte{esc, esc, end, 0x41, esc})
+	f.Fuzz(func(t *testing.T, in []byte) {
+		r := NewReader(bytes.NewReader(in), 16)
+		for {
+			p, err := r.ReadPacket()
+			if err == io.EOF {
+				return
+			}
+			if errors.Is(err, ErrTooLong) {
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p) == 0 || len(p) > 16 {
+				t.Fatalf("read %d-byte packet", len(p))
+			}
+		}
+	})
+}
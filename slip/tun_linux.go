@@ -0,0 +1,50 @@
+package slip
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	iffTUN    = 0x0001
+	iffNoPI   = 0x1000
+	tunSetIff = 0x400454ca
+)
+
+// TUN is a Linux TUN device.
+type TUN struct {
+	*os.File
+	name string
+}
+
+// OpenTUN creates (or attaches to) the TUN device called name; an
+// empty name lets the kernel pick one. The device still has to be
+// configured and brought up, e.g. with ip(8).
+func OpenTUN(name string) (*TUN, error) {
+	fd, err := syscall.Open("/dev/net/tun", syscall.O_RDWR|syscall.O_CLOEXEC|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: "/dev/net/tun", Err: err}
+	}
+	var ifr struct {
+		name  [16]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:15], name)
+	ifr.flags = iffTUN | iffNoPI
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), tunSetIff, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("TUNSETIFF", errno)
+	}
+	n := 0
+	for n < len(ifr.name) && ifr.name[n] != 0 {
+		n++
+	}
+	// With O_NONBLOCK set, os.NewFile uses the runtime poller, so
+	// Close interrupts a pending Read.
+	return &TUN{File: os.NewFile(uintptr(fd), "/dev/net/tun"), name: string(ifr.name[:n])}, nil
+}
+
+// Name returns the name of the interface.
+func (t *TUN) Name() string { return t.name }
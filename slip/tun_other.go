@@ -0,0 +1,22 @@
+//go:build !linux
+
+package slip
+
+import (
+	"errors"
+	"os"
+)
+
+// TUN is a TUN device. It is only supported on Linux.
+type TUN struct {
+	*os.File
+	name string
+}
+
+// OpenTUN returns an error: TUN devices are only supported on Linux.
+func OpenTUN(name string) (*TUN, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Name returns the name of the interface.
+func (t *TUN) Name() string { return t.name }
@@ -0,0 +1,98 @@
+package turnstile
+
+import (
+	"io"
+	"syscall"
+)
+
+const (
+	spliceMove     = 0x1 // SPLICE_F_MOVE
+	spliceNonblock = 0x2 // SPLICE_F_NONBLOCK
+
+	// maxSpliceChunk keeps each transfer within the default pipe
+	// capacity so the intermediate pipe never fills up.
+	maxSpliceChunk = 64 << 10
+)
+
+// rawFD gives splice access to a file descriptor. Descriptors behind
+// a syscall.Conn go through the runtime poller; ones only exposing
+// Fd() are polled for readiness by fdRawConn whenever a splice would
+// block, giving up once closed reports true.
+type rawFD interface {
+	Read(func(fd uintptr) bool) error
+	Write(func(fd uintptr) bool) error
+}
+
+func rawFDOf(v any, closed func() bool) rawFD {
+	switch x := v.(type) {
+	case syscall.Conn:
+		if rc, err := x.SyscallConn(); err == nil {
+			return rc
+		}
+	case interface{ Fd() uintptr }:
+		return fdRawConn{x.Fd(), closed}
+	}
+	return nil
+}
+
+// splice copies from src to dst through a kernel pipe, without the
+// data passing through user space. It reports false if either side
+// isn't backed by a file descriptor, or the kernel can't splice them
+// and nothing was copied yet, in which case the caller should fall back
+// to an ordinary copy. Waits on descriptors the runtime poller doesn't
+// know end with net.ErrClosed once closed reports true.
+func splice(dst io.Writer, src io.Reader, closed func() bool) (written int64, handled bool, err error) {
+	rsrc, rdst := rawFDOf(src, closed), rawFDOf(dst, closed)
+	if rsrc == nil || rdst == nil {
+		return 0, false, nil
+	}
+
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return 0, false, nil
+	}
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+
+	for {
+		// Source into the pipe. Splice returns an int64 on some
+		// platforms and an int on others, hence the conversions.
+		var n int
+		var serr error
+		if err := rsrc.Read(func(fd uintptr) bool {
+			k, e := syscall.Splice(int(fd), nil, p[1], nil, maxSpliceChunk, spliceMove|spliceNonblock)
+			n, serr = int(k), e
+			return serr != syscall.EAGAIN
+		}); err != nil {
+			return written, true, err
+		}
+		if serr != nil {
+			if written == 0 && (serr == syscall.EINVAL || serr == syscall.ENOSYS) {
+				return 0, false, nil
+			}
+			return written, true, serr
+		}
+		if n == 0 {
+			return written, true, nil // EOF
+		}
+
+		// Drain the pipe into the destination.
+		for n > 0 {
+			var m int
+			if err := rdst.Write(func(fd uintptr) bool {
+				k, e := syscall.Splice(p[0], nil, int(fd), nil, n, spliceMove|spliceNonblock)
+				m, serr = int(k), e
+				return serr != syscall.EAGAIN
+			}); err != nil {
+				return written, true, err
+			}
+			if serr != nil {
+				// The data is stuck in the pipe; there's no
+				// falling back now.
+				return written, true, serr
+			}
+			n -= m
+			written += int64(m)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package turnstile
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fdOnlyRWC is a device exposing its descriptor only through Fd(), so
+// the runtime poller doesn't know it.
+type fdOnlyRWC struct{ fd int }
+
+func (f fdOnlyRWC) Read(p []byte) (int, error)  { return syscall.Read(f.fd, p) }
+func (f fdOnlyRWC) Write(p []byte) (int, error) { return syscall.Write(f.fd, p) }
+func (f fdOnlyRWC) Close() error                { return syscall.Close(f.fd) }
+func (f fdOnlyRWC) Fd() uintptr                 { return uintptr(f.fd) }
+
+// TestSpliceFdOnlyClose parks a splice waiting on an idle Fd()-only
+// device, then closes the conn: closing the descriptor doesn't wake the
+// wait, but the splice must still give up with net.ErrClosed.
+func TestSpliceFdOnlyClose(t *testing.T) {
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(p[1])
+	l := NewReopenListener(func() (io.ReadWriteCloser, error) {
+		return fdOnlyRWC{p[0]}, nil
+	}, "test")
+	defer l.Close()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.(io.WriterTo).WriteTo(pw)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("WriteTo: %v, want net.ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("splice still waiting after Close")
+	}
+}
@@ -0,0 +1,65 @@
+package turnstile
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Split joins a reader and a writer, such as the two ends of a pair of
+// named pipes, into one RWC. Closing it closes both, where they
+// implement io.Closer.
+func Split(r io.Reader, w io.Writer) io.ReadWriteCloser {
+	return &splitRWC{Reader: r, Writer: w}
+}
+
+type splitRWC struct {
+	io.Reader
+	io.Writer
+	once sync.Once
+	err  error
+}
+
+func (s *splitRWC) Close() error {
+	s.once.Do(func() {
+		var errs []error
+		for _, x := range []any{s.Writer, s.Reader} {
+			if c, ok := x.(io.Closer); ok {
+				errs = append(errs, c.Close())
+			}
+		}
+		s.err = errors.Join(errs...)
+	})
+	return s.err
+}
+
+// OpenSplit returns an OpenFunc for a transport with separate read and
+// write endpoints. Both are opened for every session and closed
+// together when the conn is; if either fails to open, the one already
+// open is closed and the open is retried as usual.
+func OpenSplit(openR func() (io.ReadCloser, error), openW func() (io.WriteCloser, error)) OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		r, err := openR()
+		if err != nil {
+			return nil, err
+		}
+		w, err := openW()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return Split(r, w), nil
+	}
+}
+
+// NewSplitListener is NewReopenListener for a transport with separate
+// read and write endpoints; see OpenSplit.
+func NewSplitListener(openR func() (io.ReadCloser, error), openW func() (io.WriteCloser, error), name string, opts ...Option) *ReopenListener {
+	return NewReopenListener(OpenSplit(openR, openW), name, opts...)
+}
+
+// NewSplitDialer is NewReopenDialer for a transport with separate read
+// and write endpoints; see OpenSplit.
+func NewSplitDialer(openR func() (io.ReadCloser, error), openW func() (io.WriteCloser, error), name string, opts ...Option) *ReopenDialer {
+	return NewReopenDialer(OpenSplit(openR, openW), name, opts...)
+}
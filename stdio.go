@@ -0,0 +1,64 @@
+package turnstile
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// WithRawTerminal makes a listener or dialer from NewStdioListener or
+// NewStdioDialer put the terminal on stdin into raw mode for the
+// duration of each session, restoring it when the conn is closed, so
+// keystrokes such as Ctrl-C pass straight through to the far end. It
+// does nothing if stdin isn't a terminal, and raw mode is only
+// supported on Linux and the BSDs, macOS included. Other constructors
+// ignore this option.
+func WithRawTerminal() Option {
+	return func(o *options) {
+		o.rawTerminal = true
+	}
+}
+
+// NewStdioListener serves the process's stdin and stdout as a
+// listener, for building interactive bridge tools. As with
+// NewReadWriterListener, every session shares the same streams; a Read
+// still waiting on stdin when a session ends picks up the next input,
+// which then goes to the old session.
+func NewStdioListener(opts ...Option) *ReopenListener {
+	l := NewReopenListener(stdioOpen(newOptions(opts).rawTerminal), "stdio", opts...)
+	l.noopReopen = true
+	return l
+}
+
+// NewStdioDialer is like NewStdioListener, for the dialing side.
+func NewStdioDialer(opts ...Option) *ReopenDialer {
+	return NewReopenDialer(stdioOpen(newOptions(opts).rawTerminal), "stdio", opts...)
+}
+
+func stdioOpen(raw bool) OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		s := &stdio{restore: func() error { return nil }}
+		if raw {
+			if restore, err := makeRaw(os.Stdin); err == nil {
+				s.restore = restore
+			}
+		}
+		return s, nil
+	}
+}
+
+// stdio is the RWC for a session on stdin and stdout. Closing it
+// restores the terminal but leaves the streams open.
+type stdio struct {
+	restore func() error
+	once    sync.Once
+}
+
+func (s *stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (s *stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+
+func (s *stdio) Close() error {
+	var err error
+	s.once.Do(func() { err = s.restore() })
+	return err
+}
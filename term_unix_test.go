@@ -0,0 +1,39 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package turnstile
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestMakeRawNonblocking checks that makeRaw leaves the file in
+// non-blocking mode, as the runtime poller needs. A pipe isn't a
+// terminal, so makeRaw itself fails.
+func TestMakeRawNonblocking(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if _, err := makeRaw(r); err == nil {
+		t.Fatal("makeRaw on a pipe succeeded")
+	}
+	rc, err := r.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flags uintptr
+	var errno syscall.Errno
+	rc.Control(func(fd uintptr) {
+		flags, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+	})
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if flags&syscall.O_NONBLOCK == 0 {
+		t.Fatal("makeRaw put the file in blocking mode")
+	}
+}
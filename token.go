@@ -0,0 +1,239 @@
+package turnstile
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// ErrPeerRestarted is returned by Read on a conn created
+// WithSessionToken when the peer starts a new session over the current
+// one, e.g. because it rebooted. The conn is closed with
+// ReasonPeerRestarted.
+var ErrPeerRestarted = errors.New("turnstile: peer restarted")
+
+// ErrTokenTimeout is the open error when the peer doesn't complete
+// the session-start exchange of WithSessionToken in time.
+var ErrTokenTimeout = errors.New("turnstile: timed out waiting for peer's session token")
+
+// SessionTokenKey is the metadata key under which a conn created
+// WithSessionToken records its SessionToken.
+const SessionTokenKey metaKey = "session token"
+
+// SessionToken holds the random tokens the two ends of a session
+// exchanged when it started, e.g. for correlating logs on both sides.
+type SessionToken struct {
+	Local, Peer uint64
+}
+
+// WithSessionToken makes every session start with an exchange of
+// random tokens, so each end can tell when the other restarts mid-
+// session instead of silently talking across a reboot. Both ends must
+// use it; a listener and a dialer, or two of either, work alike. Each
+// end sends a token and waits, for up to timeout (default 5s), for the
+// peer's token and its acknowledgement of ours; an open that runs out
+// of time fails with ErrTokenTimeout and is retried with backoff. Once
+// running, a session that sees the peer start over closes with
+// ReasonPeerRestarted, its Read returning ErrPeerRestarted, and the
+// next session exchanges fresh tokens. The tokens are recorded under
+// SessionTokenKey.
+//
+// The exchange is in-band: a token frame is a 4-byte magic, a kind,
+// and the token, 13 bytes in all. Session data that happens to contain
+// one could be mistaken for a restart.
+func WithSessionToken(timeout time.Duration) Option {
+	return func(o *options) {
+		o.sessionToken = true
+		o.tokenTimeout = timeout
+	}
+}
+
+const (
+	tokenMagic    = "\x00TSK"
+	tokenFrameLen = len(tokenMagic) + 1 + 8
+
+	tokenHello = 'H'
+	tokenAck   = 'A'
+)
+
+func tokenFrame(kind byte, tok uint64) []byte {
+	b := append([]byte(tokenMagic), kind)
+	return binary.BigEndian.AppendUint64(b, tok)
+}
+
+// findTokenFrame returns the index of the first token frame in b, or
+// -1. If the frame is cut short by the end of b, ok is false.
+func findTokenFrame(b []byte) (i int, kind byte, tok uint64, ok bool) {
+	i = bytes.Index(b, []byte(tokenMagic))
+	if i < 0 || len(b)-i < tokenFrameLen {
+		return i, 0, 0, false
+	}
+	f := b[i+len(tokenMagic):]
+	return i, f[0], binary.BigEndian.Uint64(f[1:9]), true
+}
+
+// exchangeTokens runs the session-start exchange on rwc and returns an
+// RWC that watches for the peer restarting. On failure, rwc is closed.
+//
+// Each end sends a hello with its token. On a hello with a token it
+// hasn't seen, an end acknowledges it and, unless its own hello has
+// been acknowledged already, sends that again, since the peer may not
+// have been listening the first time. The exchange is over once the
+// peer's hello has arrived and ours has been acknowledged.
+func (c *core) exchangeTokens(ctx context.Context, rwc io.ReadWriteCloser, vals *values) (io.ReadWriteCloser, error) {
+	own := rand.Uint64()
+	if _, err := rwc.Write(tokenFrame(tokenHello, own)); err != nil {
+		c.policy.closeRWC(rwc)
+		return nil, err
+	}
+
+	timeout := c.opts.tokenTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	var (
+		peer           uint64
+		hasPeer, acked bool
+	)
+	back := c.takeStray()
+	buf, ch := back.b, back.pending
+	for {
+		if ch == nil {
+			ch = readAsync(rwc)
+		}
+		var r readResult
+		select {
+		case r = <-ch:
+			ch = nil
+		case <-t.C:
+			c.abandon(rwc, putBack{b: buf, pending: ch})
+			return nil, ErrTokenTimeout
+		case <-ctx.Done():
+			c.abandon(rwc, putBack{b: buf, pending: ch})
+			return nil, ctx.Err()
+		case <-c.gate.done:
+			c.abandon(rwc, putBack{b: buf, pending: ch})
+			return nil, net.ErrClosed
+		}
+		buf = append(buf, r.b...)
+		for {
+			i, kind, tok, ok := findTokenFrame(buf)
+			if !ok {
+				if i < 0 {
+					// Keep only what could be the start of a frame.
+					buf = buf[max(0, len(buf)-len(tokenMagic)+1):]
+				} else {
+					buf = buf[i:]
+				}
+				break
+			}
+			buf = buf[i+tokenFrameLen:]
+			switch {
+			case kind == tokenAck && tok == own:
+				acked = true
+			case kind == tokenHello && (!hasPeer || tok != peer):
+				peer, hasPeer = tok, true
+				reply := tokenFrame(tokenAck, tok)
+				if !acked {
+					reply = append(reply, tokenFrame(tokenHello, own)...)
+				}
+				if _, err := rwc.Write(reply); err != nil {
+					c.policy.closeRWC(rwc)
+					return nil, err
+				}
+			}
+			if hasPeer && acked {
+				vals.SetValue(SessionTokenKey, SessionToken{Local: own, Peer: peer})
+				return &tokenRWC{ReadWriteCloser: rwc, own: own, peer: peer, pending: buf, rerr: r.err, leading: true}, nil
+			}
+		}
+		if r.err != nil {
+			c.policy.closeRWC(rwc)
+			return nil, r.err
+		}
+	}
+}
+
+// tokenRWC is an RWC past the session-start exchange. It drops the
+// duplicate exchange frames that may trail it, and watches the data for
+// a hello with a new token, which means the peer has started over.
+type tokenRWC struct {
+	io.ReadWriteCloser
+	own, peer uint64
+	pending   []byte // read ahead, not yet returned
+	rerr      error  // error from the read that filled pending
+	leading   bool   // no session data seen yet
+	tail      []byte // the end of what was last read, for frames split across Reads
+	restarted bool
+}
+
+func (t *tokenRWC) Read(p []byte) (int, error) {
+	if t.restarted {
+		return 0, ErrPeerRestarted
+	}
+	if t.leading {
+		t.skipLeading()
+	}
+	var n int
+	var err error
+	switch {
+	case len(t.pending) > 0:
+		n = copy(p, t.pending)
+		t.pending = t.pending[n:]
+	case t.rerr != nil:
+		err, t.rerr = t.rerr, nil
+	default:
+		n, err = t.ReadWriteCloser.Read(p)
+	}
+
+	// Look for a new hello, including one that started in the
+	// previous Read.
+	w := append(t.tail, p[:n]...)
+	for off := 0; ; {
+		i, kind, tok, ok := findTokenFrame(w[off:])
+		if !ok {
+			break
+		}
+		if kind == tokenHello && tok != t.peer {
+			t.restarted = true
+			// Hide the frame, if it started in this Read.
+			return max(0, off+i-len(t.tail)), ErrPeerRestarted
+		}
+		off += i + tokenFrameLen
+	}
+	t.tail = append(t.tail[:0], w[max(0, len(w)-tokenFrameLen+1):]...)
+	return n, err
+}
+
+// skipLeading drops the duplicate exchange frames at the start of the
+// session data, reading more if one is cut short.
+func (t *tokenRWC) skipLeading() {
+	for t.leading {
+		i, kind, tok, ok := findTokenFrame(t.pending)
+		switch {
+		case len(t.pending) == 0 || i == 0 && !ok:
+			if t.rerr != nil {
+				t.leading = false
+				break
+			}
+			b := make([]byte, 512)
+			n, err := t.ReadWriteCloser.Read(b)
+			t.pending = append(t.pending, b[:n]...)
+			t.rerr = err
+		case i == 0 && (kind == tokenHello && tok == t.peer || kind == tokenAck && tok == t.own):
+			t.pending = t.pending[tokenFrameLen:]
+		default:
+			t.leading = false
+		}
+	}
+}
+
+func (t *tokenRWC) Unwrap() io.ReadWriteCloser { return t.ReadWriteCloser }
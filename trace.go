@@ -0,0 +1,73 @@
+package turnstile
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Tracer starts spans, for tracing opens and sessions with OpenTelemetry
+// or a similar system without turnstile depending on it. Attributes are
+// given as slog.Attrs, which map directly onto OpenTelemetry's.
+type Tracer interface {
+	// Start starts a span named name as a child of any span in ctx,
+	// returning a context carrying the new span.
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttributes(attrs ...slog.Attr)
+	RecordError(err error)
+	End()
+}
+
+// WithTracer traces a listener's or dialer's work with t:
+//
+//   - a "turnstile.open" span for every attempt to open the device,
+//     with the device name, the attempt number, the error if it
+//     failed, and the backoff before the next attempt;
+//   - a "turnstile.session" span for every session, from the moment
+//     its conn is handed out until it is closed, with the device name,
+//     session ID, bytes in and out, any read or write error, and the
+//     close reason.
+//
+// Both are children of any span in the context passed to AcceptContext
+// or DialContext, and a session's span is also in its conn's Context,
+// so work done on behalf of the session joins the trace.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// startSpan starts a span with the configured tracer, if any.
+func (o options) startSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	if o.tracer == nil {
+		return ctx, noSpan{}
+	}
+	return o.tracer.Start(ctx, name, attrs...)
+}
+
+// noSpan is the Span used without a tracer.
+type noSpan struct{}
+
+func (noSpan) SetAttributes(...slog.Attr) {}
+func (noSpan) RecordError(error)          {}
+func (noSpan) End()                       {}
+
+// endSessionSpan ends c's session span, describing the session with s.
+func (c *Conn) endSessionSpan(s SessionSummary) {
+	attrs := []slog.Attr{
+		slog.Int64("bytes.in", s.BytesIn),
+		slog.Int64("bytes.out", s.BytesOut),
+		slog.String("close.reason", s.Reason.String()),
+	}
+	if s.ReadErr != nil {
+		attrs = append(attrs, slog.String("read.error", s.ReadErr.Error()))
+	}
+	if s.WriteErr != nil {
+		attrs = append(attrs, slog.String("write.error", s.WriteErr.Error()))
+	}
+	c.span.SetAttributes(attrs...)
+	c.span.End()
+}
@@ -0,0 +1,207 @@
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparques/turnstile"
+)
+
+// Step is one exchange of a replayed session: once the application has
+// written Expect (which may be empty), the device waits Delay and then
+// sends Response.
+type Step struct {
+	Expect   []byte
+	Delay    time.Duration
+	Response []byte
+}
+
+// Script is a recorded session, to be replayed by a device standing in
+// for the real one.
+type Script []Step
+
+// ReadScript parses a transcript, as written by a Recorder, into a
+// Script. Data written to the device becomes what the replay expects,
+// data read from it becomes the responses, and the gaps between them
+// become the delays.
+func ReadScript(r io.Reader) (Script, error) {
+	var s Script
+	var cur Step
+	var last time.Time
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		ts, rest, ok1 := strings.Cut(sc.Text(), " ")
+		dir, data, ok2 := strings.Cut(rest, " ")
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if !ok1 || !ok2 || err != nil {
+			return nil, fmt.Errorf("transcript: line %d: malformed", n)
+		}
+		if dir == "#" {
+			if last.IsZero() {
+				last = t
+			}
+			continue
+		}
+		b, err := strconv.Unquote(data)
+		if err != nil {
+			return nil, fmt.Errorf("transcript: line %d: %w", n, err)
+		}
+		if last.IsZero() {
+			last = t
+		}
+		switch dir {
+		case ">":
+			if len(cur.Response) > 0 {
+				s = append(s, cur)
+				cur = Step{}
+			}
+			cur.Expect = append(cur.Expect, b...)
+		case "<":
+			if len(cur.Response) > 0 {
+				s = append(s, cur)
+				cur = Step{}
+			}
+			cur.Delay = t.Sub(last)
+			cur.Response = []byte(b)
+		default:
+			return nil, fmt.Errorf("transcript: line %d: unknown direction %q", n, dir)
+		}
+		last = t
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(cur.Expect) > 0 || len(cur.Response) > 0 {
+		s = append(s, cur)
+	}
+	return s, nil
+}
+
+// ReadScriptFile reads a Script from a transcript file, gunzipping it
+// if its name ends in ".gz".
+func ReadScriptFile(name string) (Script, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return ReadScript(r)
+}
+
+// Open returns an OpenFunc whose devices replay s from the start each
+// time they are opened. Reads return io.EOF once the script has run
+// out, as if the device hung up. Writes are matched against each step's
+// Expect wherever it appears in what has been written since the last
+// match, so extra bytes (say, a retried command) don't derail the
+// replay; a step whose Expect never arrives waits until the device is
+// closed.
+func (s Script) Open() turnstile.OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		d := &replayDevice{done: make(chan struct{}), wrote: make(chan struct{}, 1)}
+		var pw *io.PipeWriter
+		d.pr, pw = io.Pipe()
+		go d.run(s, pw)
+		return d, nil
+	}
+}
+
+// NewReplayListener returns a listener whose device replays s, so an
+// application's integration tests can run against a recorded device
+// without hardware, through the full listener path.
+func NewReplayListener(s Script, name string, opts ...turnstile.Option) *turnstile.ReopenListener {
+	return turnstile.NewReopenListener(s.Open(), name, opts...)
+}
+
+// replayDevice is a device replaying a Script.
+type replayDevice struct {
+	pr    *io.PipeReader
+	done  chan struct{}
+	wrote chan struct{} // signalled by Write
+
+	mu     sync.Mutex
+	in     []byte // written and not yet matched
+	closed bool
+}
+
+func (d *replayDevice) run(s Script, pw *io.PipeWriter) {
+	for _, step := range s {
+		if !d.expect(step.Expect) {
+			return
+		}
+		t := time.NewTimer(step.Delay)
+		select {
+		case <-t.C:
+		case <-d.done:
+			t.Stop()
+			return
+		}
+		if _, err := pw.Write(step.Response); err != nil {
+			return
+		}
+	}
+	pw.Close()
+}
+
+// expect waits until b has been written, consuming everything up to
+// the end of it. It returns false if the device was closed first.
+func (d *replayDevice) expect(b []byte) bool {
+	for {
+		d.mu.Lock()
+		if i := bytes.Index(d.in, b); i >= 0 {
+			d.in = d.in[i+len(b):]
+			d.mu.Unlock()
+			return true
+		}
+		d.mu.Unlock()
+		select {
+		case <-d.wrote:
+		case <-d.done:
+			return false
+		}
+	}
+}
+
+func (d *replayDevice) Read(p []byte) (int, error) { return d.pr.Read(p) }
+
+func (d *replayDevice) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return 0, io.ErrClosedPipe
+	}
+	d.in = append(d.in, p...)
+	select {
+	case d.wrote <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+func (d *replayDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closed {
+		d.closed = true
+		close(d.done)
+		d.pr.Close()
+	}
+	return nil
+}
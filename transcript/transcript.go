@@ -0,0 +1,236 @@
+// Package transcript records the traffic of turnstile sessions to
+// files, for audit trails on maintenance consoles.
+//
+//	rec := &transcript.Recorder{Dir: "/var/log/console", MaxSize: 10 << 20, Compress: true}
+//	l := turnstile.NewReopenListener(open, "/dev/ttyS0",
+//		turnstile.WithConnMiddleware(rec.Middleware()))
+//
+// Each session gets its own ID and its own files, named after the time
+// the session started and its ID, e.g.
+// "20261016T150405Z-0001.0.log.gz". Every line of a transcript holds a
+// timestamp, a direction ("<" for data read from the device, ">" for
+// data written to it), and the data as a Go quoted string.
+//
+// A transcript can be replayed, so tests can talk to a recorded device
+// instead of the real one:
+//
+//	script, err := transcript.ReadScriptFile("20261016T150405Z-0001.0.log.gz")
+//	l := transcript.NewReplayListener(script, "replay")
+package transcript
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sparques/turnstile"
+)
+
+// metaKey is the type of the metadata keys defined by this package.
+type metaKey string
+
+// IDKey is the metadata key under which a *turnstile.Conn records its
+// transcript's session ID.
+const IDKey metaKey = "transcript ID"
+
+// Recorder writes session transcripts to Dir. Its fields must not be
+// changed once it is in use.
+type Recorder struct {
+	// Dir is where transcripts are written. It must exist.
+	Dir string
+
+	// MaxSize rotates a session's transcript to a new file once the
+	// current one holds this many bytes (before compression). Zero
+	// means no limit.
+	MaxSize int64
+
+	// MaxAge rotates a session's transcript to a new file once the
+	// current one is this old. Zero means no limit.
+	MaxAge time.Duration
+
+	// Compress gzips transcript files.
+	Compress bool
+
+	// OnError, if set, is called when a transcript can't be written.
+	// The session carries on either way, unrecorded from then on.
+	OnError func(error)
+
+	mu  sync.Mutex
+	seq int
+}
+
+// Middleware returns a turnstile.ConnMiddleware that records every
+// session it wraps.
+func (r *Recorder) Middleware() turnstile.ConnMiddleware {
+	return r.Wrap
+}
+
+// Wrap returns c with its traffic recorded under a new session ID.
+func (r *Recorder) Wrap(c net.Conn) net.Conn {
+	r.mu.Lock()
+	r.seq++
+	seq := r.seq
+	r.mu.Unlock()
+
+	start := time.Now().UTC()
+	id := fmt.Sprintf("%s-%04d", start.Format("20060102T150405Z"), seq)
+	turnstile.SetValue(c, IDKey, id)
+	rc := &conn{Conn: c, r: r, id: id}
+	rc.mu.Lock()
+	rc.note(fmt.Sprintf("session %s %s <-> %s", id, c.LocalAddr(), c.RemoteAddr()))
+	rc.mu.Unlock()
+	return rc
+}
+
+func (r *Recorder) fail(err error) {
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+}
+
+// conn records the traffic passing through it.
+type conn struct {
+	net.Conn
+	r  *Recorder
+	id string
+
+	mu     sync.Mutex
+	part   int
+	f      *os.File
+	gz     *gzip.Writer
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+	failed bool
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record('<', p[:n])
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.record('>', p[:n])
+	}
+	return n, err
+}
+
+// Close closes the conn and finishes its transcript.
+func (c *conn) Close() error {
+	err := c.Conn.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w != nil {
+		c.note("closed")
+		c.closeFile()
+	}
+	c.failed = true // nothing more to record
+	return err
+}
+
+// Unwrap returns the conn being recorded.
+func (c *conn) Unwrap() net.Conn { return c.Conn }
+
+func (c *conn) record(dir byte, p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.line(string(dir), strconv.Quote(string(p)))
+}
+
+// note writes an annotation line. c.mu must be held.
+func (c *conn) note(s string) {
+	c.line("#", s)
+}
+
+// line writes a transcript line, rotating first if needed. c.mu must
+// be held.
+func (c *conn) line(dir, s string) {
+	if c.failed {
+		return
+	}
+	now := time.Now().UTC()
+	if c.w != nil && ((c.r.MaxSize > 0 && c.size >= c.r.MaxSize) || (c.r.MaxAge > 0 && now.Sub(c.opened) >= c.r.MaxAge)) {
+		if !c.write(now, "#", "rotated") || !c.closeFile() {
+			return
+		}
+		c.part++
+	}
+	if c.w == nil && !c.openFile(now) {
+		return
+	}
+	c.write(now, dir, s)
+}
+
+// write writes a line to the current file.
+func (c *conn) write(now time.Time, dir, s string) bool {
+	n, err := fmt.Fprintf(c.w, "%s %s %s\n", now.Format(time.RFC3339Nano), dir, s)
+	c.size += int64(n)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		c.abort(err)
+		return false
+	}
+	return true
+}
+
+func (c *conn) openFile(now time.Time) bool {
+	name := fmt.Sprintf("%s.%d.log", c.id, c.part)
+	if c.r.Compress {
+		name += ".gz"
+	}
+	f, err := os.OpenFile(filepath.Join(c.r.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		c.abort(err)
+		return false
+	}
+	c.f, c.size, c.opened = f, 0, now
+	var w io.Writer = f
+	if c.r.Compress {
+		c.gz = gzip.NewWriter(f)
+		w = c.gz
+	}
+	c.w = bufio.NewWriter(w)
+	return true
+}
+
+func (c *conn) closeFile() bool {
+	err := c.w.Flush()
+	if c.gz != nil {
+		if gzErr := c.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if fErr := c.f.Close(); err == nil {
+		err = fErr
+	}
+	c.f, c.gz, c.w = nil, nil, nil
+	if err != nil {
+		c.abort(err)
+		return false
+	}
+	return true
+}
+
+// abort gives up recording after err.
+func (c *conn) abort(err error) {
+	if c.f != nil {
+		c.f.Close()
+		c.f, c.gz, c.w = nil, nil, nil
+	}
+	c.failed = true
+	c.r.fail(fmt.Errorf("transcript %s: %w", c.id, err))
+}
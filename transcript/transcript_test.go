@@ -0,0 +1,105 @@
+package transcript
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// login talks to a console: it waits for the prompt, logs in, and
+// reads the greeting.
+func login(t *testing.T, c net.Conn) {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	for _, want := range []string{"login: ", "welcome\n"} {
+		n, err := c.Read(buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Fatalf("read %q, %v; want %q", buf[:n], err, want)
+		}
+		if want == "login: " {
+			if _, err := io.WriteString(c, "root\n"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// record runs fn on a conn recorded to a new directory and returns the
+// transcript read back as a Script.
+func record(t *testing.T, c net.Conn, fn func(net.Conn)) Script {
+	t.Helper()
+	dir := t.TempDir()
+	rec := &Recorder{Dir: dir, Compress: true, OnError: func(err error) { t.Error(err) }}
+	rc := rec.Wrap(c)
+	fn(rc)
+	rc.Close()
+	files, err := filepath.Glob(filepath.Join(dir, "*.log.gz"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("transcripts: %v, %v", files, err)
+	}
+	s, err := ReadScriptFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// sameData reports whether two steps expect and respond with the same
+// data, whatever their delays.
+func sameData(a, b Step) bool {
+	return bytes.Equal(a.Expect, b.Expect) && bytes.Equal(a.Response, b.Response)
+}
+
+// TestRecordReplay records a session with a device, replays the
+// transcript, and records the replay: both transcripts must hold the
+// same script.
+func TestRecordReplay(t *testing.T) {
+	const pause = 30 * time.Millisecond
+	a, b := net.Pipe()
+	go func() {
+		defer b.Close()
+		buf := make([]byte, 64)
+		io.WriteString(b, "login: ")
+		if _, err := b.Read(buf); err != nil {
+			return
+		}
+		time.Sleep(pause)
+		io.WriteString(b, "welcome\n")
+	}()
+	s := record(t, a, func(c net.Conn) { login(t, c) })
+
+	want := Script{
+		{Response: []byte("login: ")},
+		{Expect: []byte("root\n"), Response: []byte("welcome\n")},
+	}
+	if !slices.EqualFunc(s, want, sameData) {
+		t.Fatalf("recorded %q, want %q", s, want)
+	}
+	if s[1].Delay < pause {
+		t.Errorf("delay %v, want at least %v", s[1].Delay, pause)
+	}
+
+	l := NewReplayListener(s, "replay")
+	defer l.Close()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := record(t, c, func(c net.Conn) {
+		login(t, c)
+		if n, err := c.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("read after script: %d, %v; want EOF", n, err)
+		}
+	})
+	if !slices.EqualFunc(replayed, want, sameData) {
+		t.Fatalf("replayed %q, want %q", replayed, want)
+	}
+	if replayed[1].Delay < pause {
+		t.Errorf("replayed delay %v, want at least %v", replayed[1].Delay, pause)
+	}
+}
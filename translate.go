@@ -0,0 +1,181 @@
+package turnstile
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+	"sync/atomic"
+)
+
+// ErrParity is returned, along with the data read, by a Translator's
+// Read when a character's parity is wrong and StrictParity is set.
+var ErrParity = errors.New("turnstile: parity error")
+
+// Table maps every byte value to another, for translating between
+// character sets.
+type Table [256]byte
+
+// Inverse returns the table undoing t, which must map every byte to a
+// different one.
+func (t *Table) Inverse() *Table {
+	var inv Table
+	for i, b := range t {
+		inv[b] = byte(i)
+	}
+	return &inv
+}
+
+// EBCDICToLatin1 translates EBCDIC code page 037 (US/Canada) to
+// ISO 8859-1; its Inverse goes the other way.
+var EBCDICToLatin1 = &Table{
+	0x00, 0x01, 0x02, 0x03, 0x9c, 0x09, 0x86, 0x7f, 0x97, 0x8d, 0x8e, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	0x10, 0x11, 0x12, 0x13, 0x9d, 0x85, 0x08, 0x87, 0x18, 0x19, 0x92, 0x8f, 0x1c, 0x1d, 0x1e, 0x1f,
+	0x80, 0x81, 0x82, 0x83, 0x84, 0x0a, 0x17, 0x1b, 0x88, 0x89, 0x8a, 0x8b, 0x8c, 0x05, 0x06, 0x07,
+	0x90, 0x91, 0x16, 0x93, 0x94, 0x95, 0x96, 0x04, 0x98, 0x99, 0x9a, 0x9b, 0x14, 0x15, 0x9e, 0x1a,
+	0x20, 0xa0, 0xe2, 0xe4, 0xe0, 0xe1, 0xe3, 0xe5, 0xe7, 0xf1, 0xa2, 0x2e, 0x3c, 0x28, 0x2b, 0x7c,
+	0x26, 0xe9, 0xea, 0xeb, 0xe8, 0xed, 0xee, 0xef, 0xec, 0xdf, 0x21, 0x24, 0x2a, 0x29, 0x3b, 0xac,
+	0x2d, 0x2f, 0xc2, 0xc4, 0xc0, 0xc1, 0xc3, 0xc5, 0xc7, 0xd1, 0xa6, 0x2c, 0x25, 0x5f, 0x3e, 0x3f,
+	0xf8, 0xc9, 0xca, 0xcb, 0xc8, 0xcd, 0xce, 0xcf, 0xcc, 0x60, 0x3a, 0x23, 0x40, 0x27, 0x3d, 0x22,
+	0xd8, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0xab, 0xbb, 0xf0, 0xfd, 0xfe, 0xb1,
+	0xb0, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0xaa, 0xba, 0xe6, 0xb8, 0xc6, 0xa4,
+	0xb5, 0x7e, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0xa1, 0xbf, 0xd0, 0xdd, 0xde, 0xae,
+	0x5e, 0xa3, 0xa5, 0xb7, 0xa9, 0xa7, 0xb6, 0xbc, 0xbd, 0xbe, 0x5b, 0x5d, 0xaf, 0xa8, 0xb4, 0xd7,
+	0x7b, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0xad, 0xf4, 0xf6, 0xf2, 0xf3, 0xf5,
+	0x7d, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f, 0x50, 0x51, 0x52, 0xb9, 0xfb, 0xfc, 0xf9, 0xfa, 0xff,
+	0x5c, 0xf7, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0xb2, 0xd4, 0xd6, 0xd2, 0xd3, 0xd5,
+	0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0xb3, 0xdb, 0xdc, 0xd9, 0xda, 0x9f,
+}
+
+// Parity selects the parity bit a Translator emulates.
+type Parity int
+
+const (
+	ParityNone  Parity = iota // 8-bit characters, passed through
+	ParityEven                // bit 7 makes the number of set bits even
+	ParityOdd                 // bit 7 makes the number of set bits odd
+	ParityMark                // bit 7 always set
+	ParitySpace               // bit 7 always clear
+)
+
+// bit returns the parity bit for the 7-bit character c, in bit 7.
+func (p Parity) bit(c byte) byte {
+	switch p {
+	case ParityEven:
+		return byte(bits.OnesCount8(c)&1) << 7
+	case ParityOdd:
+		return byte(^bits.OnesCount8(c)&1) << 7
+	case ParityMark:
+		return 0x80
+	}
+	return 0
+}
+
+// Translation configures a Translator.
+type Translation struct {
+	// Read and Write translate bytes coming from and going to the
+	// device. Nil leaves them as they are.
+	Read, Write *Table
+
+	// Parity emulates 7-bit characters with a parity bit in software,
+	// for equipment speaking e.g. 7E1 on a port that can only do 8N1:
+	// each byte written gets the parity bit in bit 7, after the Write
+	// table, and each byte read has it checked and cleared, before the
+	// Read table.
+	Parity Parity
+
+	// StrictParity makes Read return ErrParity along with the data when
+	// a character's parity is wrong. Otherwise such characters are
+	// passed on like the rest, and only counted.
+	StrictParity bool
+}
+
+// Translator wraps an RWC and translates the bytes passing through it,
+// for legacy equipment using another character set or a parity the
+// port can't do.
+type Translator struct {
+	rwc     io.ReadWriteCloser
+	t       Translation
+	parErrs atomic.Uint64
+}
+
+// NewTranslator returns rwc with its data translated as t says.
+func NewTranslator(rwc io.ReadWriteCloser, t Translation) *Translator {
+	return &Translator{rwc: rwc, t: t}
+}
+
+// Translate returns an OpenFunc that wraps every RWC opened by open
+// in a Translator.
+func Translate(open OpenFunc, t Translation) OpenFunc {
+	return func() (io.ReadWriteCloser, error) {
+		rwc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return NewTranslator(rwc, t), nil
+	}
+}
+
+func (t *Translator) Read(p []byte) (int, error) {
+	n, err := t.rwc.Read(p)
+	bad := false
+	for i, c := range p[:n] {
+		if t.t.Parity != ParityNone {
+			c7 := c & 0x7f
+			if c&0x80 != t.t.Parity.bit(c7) {
+				t.parErrs.Add(1)
+				bad = true
+			}
+			c = c7
+		}
+		if t.t.Read != nil {
+			c = t.t.Read[c]
+		}
+		p[i] = c
+	}
+	if bad && t.t.StrictParity && err == nil {
+		err = ErrParity
+	}
+	return n, err
+}
+
+// Write translates p, without modifying it, and writes the result.
+func (t *Translator) Write(p []byte) (int, error) {
+	if t.t.Write == nil && t.t.Parity == ParityNone {
+		return t.rwc.Write(p)
+	}
+	b := getBuf()
+	defer putBuf(b)
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), len(*b))]
+		out := (*b)[:len(chunk)]
+		for i, c := range chunk {
+			if t.t.Write != nil {
+				c = t.t.Write[c]
+			}
+			if t.t.Parity != ParityNone {
+				c = c&0x7f | t.t.Parity.bit(c&0x7f)
+			}
+			out[i] = c
+		}
+		n, err := t.rwc.Write(out)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n < len(out) {
+			return written, io.ErrShortWrite
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (t *Translator) Close() error { return t.rwc.Close() }
+
+// ParityErrors returns how many characters with the wrong parity have
+// been read.
+func (t *Translator) ParityErrors() uint64 { return t.parErrs.Load() }
+
+// Unwrap returns the underlying RWC.
+func (t *Translator) Unwrap() io.ReadWriteCloser { return t.rwc }
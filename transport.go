@@ -0,0 +1,112 @@
+package turnstile
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Transport is an http.RoundTripper that sends requests over a single
+// serial link, one at a time. Concurrent requests queue up in order
+// rather than dialing conns the link can't provide, and the one conn is
+// kept alive between requests. A request holds the link until its
+// response body has been read to EOF or closed, so always close bodies,
+// and don't start a request while holding an unread body.
+type Transport struct {
+	// Timeout, if non-zero, bounds each request from the moment its
+	// turn comes until its response body has been read; the wait in
+	// the queue is bounded by the request's own context. A request
+	// that times out breaks the conn, which is re-dialed for the next.
+	// It must be set before the Transport is used.
+	Timeout time.Duration
+
+	d    *ReopenDialer
+	t    *http.Transport
+	turn chan struct{} // held by the request in progress
+	last string        // scheme and host of the previous request
+}
+
+// NewTransport returns a Transport that reaches the HTTP server on the
+// device opened by open. opts configure the underlying ReopenDialer.
+func NewTransport(open OpenFunc, name string, opts ...Option) *Transport {
+	d := NewReopenDialer(open, name, opts...)
+	return &Transport{
+		d: d,
+		t: &http.Transport{
+			DialContext:         d.DialContext,
+			MaxConnsPerHost:     1,
+			MaxIdleConnsPerHost: 1,
+		},
+		turn: make(chan struct{}, 1),
+	}
+}
+
+// Dialer returns the ReopenDialer the Transport dials with, e.g. for
+// its State.
+func (t *Transport) Dialer() *ReopenDialer { return t.d }
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	select {
+	case t.turn <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// The link has room for one conn, so a conn kept alive for another
+	// host would block the dial for this one.
+	if key := req.URL.Scheme + "://" + req.URL.Host; key != t.last {
+		t.t.CloseIdleConnections()
+		t.last = key
+	}
+	cancel := func() {}
+	if t.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		req = req.WithContext(ctx)
+	}
+	resp, err := t.t.RoundTrip(req)
+	if err != nil {
+		cancel()
+		<-t.turn
+		return nil, err
+	}
+	resp.Body = &turnBody{ReadCloser: resp.Body, done: func() {
+		cancel()
+		<-t.turn
+	}}
+	return resp, nil
+}
+
+// CloseIdleConnections closes the kept-alive conn, if it is idle,
+// ending its session so the device is free for others.
+func (t *Transport) CloseIdleConnections() { t.t.CloseIdleConnections() }
+
+// Close closes the idle conn and the dialer; requests fail from then on.
+func (t *Transport) Close() error {
+	t.t.CloseIdleConnections()
+	return t.d.Close()
+}
+
+// turnBody passes the link on to the next request once the response
+// body has been read or closed.
+type turnBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *turnBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.done)
+	}
+	return n, err
+}
+
+func (b *turnBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
@@ -0,0 +1,86 @@
+package turnstile
+
+import (
+	"io"
+	"sync"
+)
+
+// metaKey is the type of the metadata keys defined by this package.
+type metaKey string
+
+// BaudRateKey is the metadata key under which a conn records the baud
+// rate of an RWC that reports one, such as those opened by AutoBaud.
+const BaudRateKey metaKey = "baud rate"
+
+// values is a conn's metadata store. Keys follow the same rules as
+// context keys: any comparable value, ideally of an unexported type.
+type values struct {
+	mu sync.Mutex
+	m  map[any]any
+}
+
+func (v *values) Value(key any) any {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.m[key]
+}
+
+func (v *values) SetValue(key, val any) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.m == nil {
+		v.m = make(map[any]any)
+	}
+	v.m[key] = val
+}
+
+// Value returns the metadata stored on c under key, or nil.
+func (c *Conn) Value(key any) any { return c.vals.Value(key) }
+
+// SetValue stores val on c under key, for use by downstream handlers.
+func (c *Conn) SetValue(key, val any) { c.vals.SetValue(key, val) }
+
+// SetValue records metadata for the conn that rw will become, for hooks
+// such as health checks that get to see the RWC before it is handed
+// out:
+//
+//	func check(rw io.ReadWriter) error {
+//		ver, err := queryFirmware(rw)
+//		if err != nil {
+//			return err
+//		}
+//		turnstile.SetValue(rw, firmwareKey, ver)
+//		return nil
+//	}
+//
+// It also works on a *Conn. It reports false if rw has nowhere to keep
+// metadata.
+func SetValue(rw io.ReadWriter, key, val any) bool {
+	s, ok := rw.(interface{ SetValue(key, val any) })
+	if ok {
+		s.SetValue(key, val)
+	}
+	return ok
+}
+
+// valueRW is what hooks see: the RWC plus the metadata store of the
+// conn it will become.
+type valueRW struct {
+	io.ReadWriter
+	*values
+}
+
+// unread and reclaim pass input put back by an Expecter on to the RWC,
+// if it takes it.
+func (v valueRW) unread(pb putBack) {
+	if s, ok := v.ReadWriter.(pushbacker); ok {
+		s.unread(pb)
+	}
+}
+
+func (v valueRW) reclaim() (putBack, io.Reader) {
+	if s, ok := v.ReadWriter.(pushbacker); ok {
+		return s.reclaim()
+	}
+	return putBack{}, v.ReadWriter
+}
@@ -0,0 +1,69 @@
+package turnstile
+
+import "time"
+
+// watchdog is the configuration for WithWatchdog.
+type watchdog struct {
+	idle  time.Duration
+	probe []byte
+	grace time.Duration
+}
+
+// WithWatchdog closes a conn whose device has gone quiet, for USB
+// serial chips that sometimes wedge silently and only recover when
+// reopened. If Read has returned no data for idle, probe is written to
+// the conn; if still nothing arrives within grace, the conn is closed,
+// so the next Accept/Dial reopens the device. A nil probe closes the
+// conn as soon as it has been idle for idle.
+//
+// The probe should be something the device answers harmlessly, such as
+// "\r" to a console or "AT\r" to a modem; the answer is returned by
+// Read like any other data. Only data actually read counts, so the
+// watchdog suits sessions that keep a Read outstanding. Combine it
+// with WithSerializedWrites if the probe mustn't land in the middle of
+// another write.
+func WithWatchdog(idle time.Duration, probe []byte, grace time.Duration) Option {
+	return func(o *options) {
+		o.watchdog = &watchdog{idle: idle, probe: probe, grace: grace}
+	}
+}
+
+// watch runs w on c until c is closed.
+func (c *Conn) watch(w *watchdog) {
+	c.lastRead.Store(time.Now().UnixNano())
+	stop := make(chan struct{})
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.stops = append(c.stops, func() bool { close(stop); return true })
+	c.mu.Unlock()
+
+	go func() {
+		t := time.NewTimer(w.idle)
+		defer t.Stop()
+		probed := false
+		for {
+			select {
+			case <-t.C:
+			case <-stop:
+				return
+			}
+			quiet := time.Since(time.Unix(0, c.lastRead.Load()))
+			switch {
+			case quiet < w.idle:
+				// Data came in; check again once it could be idle.
+				probed = false
+				t.Reset(w.idle - quiet)
+			case w.probe != nil && !probed:
+				probed = true
+				c.Write(w.probe)
+				t.Reset(w.grace)
+			default:
+				c.closeFor(ReasonIdle)
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,253 @@
+package turnstile
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// WithWriteQueue gives each conn a write queue of size bytes, sent to
+// the device in the background, so Write returns as soon as its data is
+// queued. A Write that doesn't fit blocks until there is room, or until
+// the conn's write deadline passes, when it fails with
+// os.ErrDeadlineExceeded having queued what it could. A failed
+// background write fails every later Write with its error.
+//
+// Producers such as telemetry feeds can watch the queue to degrade
+// gracefully on a slow link instead of piling up data: see
+// Conn.Backpressure and Conn.Backlog. Data still queued when the conn
+// is closed is dropped, unless WithDrainOnClose is set too, which
+// waits for the queue to empty.
+func WithWriteQueue(size int) Option {
+	return func(o *options) {
+		o.writeQueue = size
+	}
+}
+
+// Backlog describes a conn's write queue.
+type Backlog struct {
+	Queued int // bytes written to the conn but not yet to the device
+	Size   int // the queue's capacity
+
+	// DrainIn estimates how long the queued data will take to send,
+	// from the device's baud rate at 10 bits a byte, or is zero if the
+	// device doesn't report one.
+	DrainIn time.Duration
+}
+
+// Backlog reports the state of the conn's write queue. It is zero
+// unless the conn was created WithWriteQueue.
+func (c *Conn) Backlog() Backlog {
+	if q, ok := device[*writeQueue](c); ok {
+		return q.backlog()
+	}
+	return Backlog{}
+}
+
+// Backpressure returns a channel that is closed once the conn's write
+// queue is three quarters full, e.g. for a producer to select on and
+// start dropping or coarsening its data. Once the queue has drained
+// below a quarter full, Backpressure returns a fresh channel for the
+// next time. Without WithWriteQueue, the channel is nil, which never
+// becomes ready.
+func (c *Conn) Backpressure() <-chan struct{} {
+	if q, ok := device[*writeQueue](c); ok {
+		return q.backpressure()
+	}
+	return nil
+}
+
+// writeQueue is an RWC whose writes are queued and sent by a
+// background goroutine.
+type writeQueue struct {
+	rwc  io.ReadWriteCloser
+	size int
+	baud int
+
+	mu        sync.Mutex
+	buf       []byte // queued, not yet handed to rwc
+	spare     []byte // the other buffer, swapped with buf by run
+	inflight  int    // bytes run is writing
+	err       error  // from the background write; sticky
+	closed    bool
+	deadline  time.Time
+	changed   chan struct{} // closed and replaced whenever the state changes
+	pressure  chan struct{} // for Backpressure; closed while saturated
+	saturated bool
+}
+
+func newWriteQueue(rwc io.ReadWriteCloser, size int) *writeQueue {
+	q := &writeQueue{rwc: rwc, size: size, changed: make(chan struct{})}
+	if b, ok := unwrapTo[interface{ BaudRate() int }](rwc); ok {
+		q.baud = b.BaudRate()
+	}
+	go q.run()
+	return q
+}
+
+// changedLocked wakes everyone waiting on the queue and updates the
+// backpressure state.
+func (q *writeQueue) changedLocked() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+	n := len(q.buf) + q.inflight
+	switch {
+	case !q.saturated && n >= q.size*3/4:
+		q.saturated = true
+		if q.pressure == nil {
+			q.pressure = make(chan struct{})
+		}
+		close(q.pressure)
+	case q.saturated && n < q.size/4:
+		q.saturated = false
+		q.pressure = nil
+	}
+}
+
+func (q *writeQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.buf) == 0 && !q.closed {
+			ch := q.changed
+			q.mu.Unlock()
+			<-ch
+			q.mu.Lock()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		b := q.buf
+		q.buf, q.spare = q.spare[:0], nil
+		q.inflight = len(b)
+		q.mu.Unlock()
+
+		_, err := q.rwc.Write(b)
+
+		q.mu.Lock()
+		q.inflight = 0
+		q.spare = b[:0]
+		if err != nil && q.err == nil {
+			q.err = err
+		}
+		q.changedLocked()
+		q.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (q *writeQueue) Read(p []byte) (int, error) { return q.rwc.Read(p) }
+
+func (q *writeQueue) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return n, net.ErrClosed
+		}
+		if q.err != nil {
+			err := q.err
+			q.mu.Unlock()
+			return n, err
+		}
+		if room := q.size - len(q.buf) - q.inflight; room > 0 {
+			m := min(room, len(p))
+			q.buf = append(q.buf, p[:m]...)
+			p = p[m:]
+			n += m
+			q.changedLocked()
+			q.mu.Unlock()
+			continue
+		}
+		ch, deadline := q.changed, q.deadline
+		q.mu.Unlock()
+		if err := waitChange(ch, deadline); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// waitChange waits for ch to be closed or deadline, if set, to pass.
+func waitChange(ch <-chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-t.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// SetWriteDeadline sets the deadline for Writes waiting for room in
+// the queue.
+func (q *writeQueue) SetWriteDeadline(t time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deadline = t
+	q.changedLocked()
+	return nil
+}
+
+// Drain waits for the queue to empty, then drains the RWC if it is a
+// Drainer.
+func (q *writeQueue) Drain() error {
+	q.mu.Lock()
+	for len(q.buf)+q.inflight > 0 && q.err == nil && !q.closed {
+		ch := q.changed
+		q.mu.Unlock()
+		<-ch
+		q.mu.Lock()
+	}
+	err := q.err
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if d, ok := q.rwc.(Drainer); ok {
+		return d.Drain()
+	}
+	return nil
+}
+
+// Close drops whatever is still queued and closes the RWC.
+func (q *writeQueue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.buf = nil
+	q.changedLocked()
+	q.mu.Unlock()
+	return q.rwc.Close()
+}
+
+func (q *writeQueue) backlog() Backlog {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	b := Backlog{Queued: len(q.buf) + q.inflight, Size: q.size}
+	if q.baud > 0 {
+		b.DrainIn = time.Duration(b.Queued) * 10 * time.Second / time.Duration(q.baud)
+	}
+	return b
+}
+
+func (q *writeQueue) backpressure() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pressure == nil {
+		q.pressure = make(chan struct{})
+	}
+	return q.pressure
+}
+
+// Unwrap returns the underlying RWC.
+func (q *writeQueue) Unwrap() io.ReadWriteCloser { return q.rwc }
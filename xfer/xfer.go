@@ -0,0 +1,201 @@
+// Package xfer transfers files over a turnstile session with XMODEM
+// (CRC and 1K variants) and YMODEM, the protocols most bootloaders and
+// terminal programs speak, and receives files sent with ZMODEM (sz).
+//
+//	err := l.Exclusive(ctx, func(rwc io.ReadWriteCloser) error {
+//		return xfer.XmodemSend(rwc, firmware, &xfer.Options{Use1K: true})
+//	})
+//
+// Transfers read in the background so they can time out on streams
+// that don't support deadlines. A read may therefore still be
+// outstanding when a transfer returns, and whatever it reads is
+// discarded; start the transfer on a fresh session, or pass a conn
+// created WithReadTimeout, if the data that follows matters.
+package xfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Protocol bytes.
+const (
+	soh = 0x01
+	stx = 0x02
+	eot = 0x04
+	ack = 0x06
+	nak = 0x15
+	can = 0x18
+	crc = 'C'
+	sub = 0x1a
+)
+
+var (
+	// ErrCancelled is returned when the peer cancels the transfer.
+	ErrCancelled = errors.New("xfer: cancelled by peer")
+
+	// ErrTimeout is returned when the peer stops responding.
+	ErrTimeout = errors.New("xfer: timed out")
+
+	// ErrTooManyRetries is returned when a block can't be
+	// transferred intact.
+	ErrTooManyRetries = errors.New("xfer: too many retries")
+)
+
+// Options tunes a transfer. A nil *Options uses the defaults.
+type Options struct {
+	// Use1K sends 1024-byte blocks (XMODEM-1K). YMODEM always does.
+	Use1K bool
+
+	// Timeout bounds each wait for the peer. Default 10s.
+	Timeout time.Duration
+
+	// Retries is how many times a block is retried. Default 10.
+	Retries int
+
+	// Progress, if set, is called after each block with the name of
+	// the file (empty for XMODEM), the bytes transferred so far, and
+	// the file's size, or -1 if unknown.
+	Progress func(name string, done, size int64)
+}
+
+func (o *Options) withDefaults() Options {
+	var c Options
+	if o != nil {
+		c = *o
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.Retries <= 0 {
+		c.Retries = 10
+	}
+	return c
+}
+
+func (o *Options) progress(name string, done, size int64) {
+	if o.Progress != nil {
+		o.Progress(name, done, size)
+	}
+}
+
+// port reads from a stream with timeouts, keeping at most one read
+// outstanding.
+type port struct {
+	rw      io.ReadWriter
+	buf     []byte
+	results chan readResult
+	pending bool
+}
+
+type readResult struct {
+	b   []byte
+	err error
+}
+
+func newPort(rw io.ReadWriter) *port {
+	return &port{rw: rw, results: make(chan readResult, 1)}
+}
+
+func (p *port) write(b ...byte) error {
+	_, err := p.rw.Write(b)
+	return err
+}
+
+// fill waits up to timeout for more input.
+func (p *port) fill(timeout time.Duration) error {
+	if !p.pending {
+		p.pending = true
+		go func() {
+			b := make([]byte, 1100)
+			n, err := p.rw.Read(b)
+			p.results <- readResult{b[:n], err}
+		}()
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-p.results:
+		p.pending = false
+		p.buf = append(p.buf, r.b...)
+		var ne net.Error
+		if r.err != nil && len(r.b) == 0 && !(errors.As(r.err, &ne) && ne.Timeout()) {
+			return r.err
+		}
+		return nil
+	case <-t.C:
+		return ErrTimeout
+	}
+}
+
+// readByte returns the next input byte.
+func (p *port) readByte(timeout time.Duration) (byte, error) {
+	b, err := p.readN(1, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readN returns the next n input bytes.
+func (p *port) readN(n int, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for len(p.buf) < n {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, ErrTimeout
+		}
+		if err := p.fill(left); err != nil {
+			return nil, err
+		}
+	}
+	b := p.buf[:n:n]
+	p.buf = p.buf[n:]
+	return b, nil
+}
+
+// purge discards input until the line has been quiet for a second, so
+// a retry starts in sync.
+func (p *port) purge() {
+	p.buf = nil
+	for p.fill(time.Second) == nil {
+		p.buf = nil
+	}
+}
+
+// cancel aborts the transfer on the peer's side.
+func (p *port) cancel() {
+	p.write(can, can, can, can, can)
+}
+
+// crc16 is the CRC-16/XMODEM of b.
+func crc16(b []byte) uint16 {
+	var c uint16
+	for _, x := range b {
+		c ^= uint16(x) << 8
+		for range 8 {
+			if c&0x8000 != 0 {
+				c = c<<1 ^ 0x1021
+			} else {
+				c <<= 1
+			}
+		}
+	}
+	return c
+}
+
+func checksum(b []byte) byte {
+	var s byte
+	for _, x := range b {
+		s += x
+	}
+	return s
+}
+
+// blockError describes a block the peer didn't accept.
+func blockError(seq byte, err error) error {
+	return fmt.Errorf("block %d: %w", seq, err)
+}
@@ -0,0 +1,218 @@
+package xfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// testData returns n bytes that cover every byte value, protocol bytes
+// included.
+func testData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7 + i/256)
+	}
+	return b
+}
+
+// filterConn passes what is written to it through fn first, dropping
+// the write if fn returns nothing.
+type filterConn struct {
+	net.Conn
+	fn func([]byte) []byte
+}
+
+func (c *filterConn) Write(p []byte) (int, error) {
+	if q := c.fn(bytes.Clone(p)); len(q) > 0 {
+		if _, err := c.Conn.Write(q); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// pipe returns both ends of a net.Pipe, closed when the test ends.
+func pipe(t *testing.T) (a, b net.Conn) {
+	t.Helper()
+	a, b = net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// nopWC is a WriteCloser whose Close does nothing.
+type nopWC struct{ io.Writer }
+
+func (nopWC) Close() error { return nil }
+
+// xmodem sends data from one end of a with XmodemSend and receives it
+// on b, returning what was received.
+func xmodem(t *testing.T, a, b net.Conn, data []byte, o *Options) []byte {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() { errc <- XmodemSend(a, bytes.NewReader(data), o) }()
+	var got bytes.Buffer
+	n, err := XmodemReceive(b, &got, o)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if n != int64(got.Len()) {
+		t.Errorf("received %d bytes, wrote %d", n, got.Len())
+	}
+	return got.Bytes()
+}
+
+// checkPadded checks that got is want padded with SUB to a whole
+// number of blocks of size bs.
+func checkPadded(t *testing.T, got, want []byte, bs int) {
+	t.Helper()
+	if len(got)%bs != 0 || len(got) < len(want) || len(got)-len(want) >= bs {
+		t.Fatalf("received %d bytes for %d in %d-byte blocks", len(got), len(want), bs)
+	}
+	if !bytes.Equal(got[:len(want)], want) {
+		t.Fatal("data mismatch")
+	}
+	if pad := got[len(want):]; len(bytes.Trim(pad, "\x1a")) != 0 {
+		t.Fatalf("padding %q", pad)
+	}
+}
+
+func TestXmodem(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		o    *Options
+		bs   int
+	}{
+		{"CRC", nil, 128},
+		{"1K", &Options{Use1K: true}, 1024},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := pipe(t)
+			data := testData(3000)
+			checkPadded(t, xmodem(t, a, b, data, tc.o), data, tc.bs)
+		})
+	}
+}
+
+// TestXmodemChecksumFallback has a sender that never hears the
+// receiver's CRC requests: the receiver must fall back to asking for
+// checksum mode, and the two must agree on it.
+func TestXmodemChecksumFallback(t *testing.T) {
+	a, b := pipe(t)
+	var sawNAK bool
+	rx := &filterConn{Conn: b, fn: func(p []byte) []byte {
+		if bytes.Equal(p, []byte{crc}) {
+			return nil
+		}
+		sawNAK = sawNAK || bytes.Equal(p, []byte{nak})
+		return p
+	}}
+	o := &Options{Timeout: 200 * time.Millisecond, Retries: 4}
+	data := testData(500)
+	checkPadded(t, xmodem(t, a, rx, data, o), data, 128)
+	if !sawNAK {
+		t.Fatal("receiver never asked for checksum mode")
+	}
+}
+
+// TestXmodemRetransmit corrupts a block on its way to the receiver,
+// which must NAK it and get it again.
+func TestXmodemRetransmit(t *testing.T) {
+	a, b := pipe(t)
+	blocks := 0
+	tx := &filterConn{Conn: a, fn: func(p []byte) []byte {
+		if p[0] != soh {
+			return p
+		}
+		if blocks++; blocks == 2 {
+			p[10] ^= 0xff
+		}
+		return p
+	}}
+	data := testData(300)
+	checkPadded(t, xmodem(t, tx, b, data, nil), data, 128)
+	if blocks != 4 {
+		t.Fatalf("sent %d blocks, want 4 with one resent", blocks)
+	}
+}
+
+// TestXmodemCancel has the peer cancel the transfer, on either side.
+func TestXmodemCancel(t *testing.T) {
+	t.Run("Receive", func(t *testing.T) {
+		a, b := pipe(t)
+		go func() {
+			a.Read(make([]byte, 1)) // the start request
+			a.Write([]byte{can, can})
+		}()
+		if _, err := XmodemReceive(b, io.Discard, nil); !errors.Is(err, ErrCancelled) {
+			t.Fatalf("XmodemReceive: %v, want ErrCancelled", err)
+		}
+	})
+	t.Run("Send", func(t *testing.T) {
+		a, b := pipe(t)
+		go func() {
+			b.Write([]byte{crc})
+			io.ReadFull(b, make([]byte, 3+128+2)) // the first block
+			b.Write([]byte{can, can})
+		}()
+		if err := XmodemSend(a, bytes.NewReader(testData(1000)), nil); !errors.Is(err, ErrCancelled) {
+			t.Fatalf("XmodemSend: %v, want ErrCancelled", err)
+		}
+	})
+}
+
+// TestYmodem sends a batch and checks each file's header made it
+// across, and that files of known size lose their padding.
+func TestYmodem(t *testing.T) {
+	mod := time.Unix(1700000000, 0)
+	files := []File{
+		{Name: "small.txt", Size: 200, ModTime: mod, Data: bytes.NewReader(testData(200))},
+		{Name: "big.bin", Size: 2500, Data: bytes.NewReader(testData(2500))},
+		{Name: "unsized", Size: -1, Data: bytes.NewReader(testData(100))},
+	}
+	type received struct {
+		name    string
+		size    int64
+		modTime time.Time
+		data    bytes.Buffer
+	}
+	var got []*received
+
+	a, b := pipe(t)
+	errc := make(chan error, 1)
+	go func() { errc <- YmodemSend(a, files, nil) }()
+	err := YmodemReceive(b, func(name string, size int64, modTime time.Time) (io.WriteCloser, error) {
+		r := &received{name: name, size: size, modTime: modTime}
+		got = append(got, r)
+		return nopWC{&r.data}, nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	if len(got) != len(files) {
+		t.Fatalf("received %d files, want %d", len(got), len(files))
+	}
+	for i, f := range files {
+		r := got[i]
+		if r.name != f.Name || r.size != f.Size || !r.modTime.Equal(f.ModTime) {
+			t.Errorf("file %d: header %q %d %v, want %q %d %v", i, r.name, r.size, r.modTime, f.Name, f.Size, f.ModTime)
+		}
+	}
+	if !bytes.Equal(got[0].data.Bytes(), testData(200)) || !bytes.Equal(got[1].data.Bytes(), testData(2500)) {
+		t.Error("sized files not cut to size")
+	}
+	checkPadded(t, got[2].data.Bytes(), testData(100), 128)
+}
@@ -0,0 +1,304 @@
+package xfer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// startPoll is how often a receiver re-sends its start request.
+const startPoll = 3 * time.Second
+
+// XmodemSend sends everything read from r to an XMODEM receiver on rw,
+// using CRC or checksum mode as the receiver asks.
+func XmodemSend(rw io.ReadWriter, r io.Reader, o *Options) error {
+	s := &sender{p: newPort(rw), o: o.withDefaults()}
+	if err := s.waitStart(); err != nil {
+		return err
+	}
+	return s.sendData(r, "", -1, s.o.Use1K)
+}
+
+// XmodemReceive receives a file from an XMODEM sender on rw and writes
+// it to w, returning the number of bytes written. XMODEM pads the last
+// block, so the padding (usually 0x1a bytes) ends up in w too.
+func XmodemReceive(rw io.ReadWriter, w io.Writer, o *Options) (int64, error) {
+	r := &receiver{p: newPort(rw), o: o.withDefaults(), useCRC: true, fallback: true}
+	hdr, err := r.start()
+	if err != nil {
+		return 0, err
+	}
+	return r.recvData(w, "", -1, hdr)
+}
+
+type sender struct {
+	p      *port
+	o      Options
+	useCRC bool
+}
+
+// waitStart waits for the receiver to ask for CRC ('C') or checksum
+// (NAK) mode.
+func (s *sender) waitStart() error {
+	for range s.o.Retries {
+		b, err := s.p.readByte(s.o.Timeout)
+		if err == ErrTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch b {
+		case crc:
+			s.useCRC = true
+			return nil
+		case nak:
+			s.useCRC = false
+			return nil
+		case can:
+			if s.cancelled() {
+				return ErrCancelled
+			}
+		}
+	}
+	return ErrTimeout
+}
+
+// cancelled reports whether a CAN just read is followed by another, as
+// a real cancellation is.
+func (s *sender) cancelled() bool {
+	b, err := s.p.readByte(time.Second)
+	return err == nil && b == can
+}
+
+// sendBlock sends one block of size bytes, data padded with pad, and
+// waits for it to be acknowledged.
+func (s *sender) sendBlock(seq byte, data []byte, size int, pad byte) error {
+	hdr := byte(soh)
+	if size == 1024 {
+		hdr = stx
+	}
+	frame := make([]byte, 0, 3+size+2)
+	frame = append(frame, hdr, seq, ^seq)
+	frame = append(frame, data...)
+	for len(frame) < 3+size {
+		frame = append(frame, pad)
+	}
+	if s.useCRC {
+		frame = binary.BigEndian.AppendUint16(frame, crc16(frame[3:]))
+	} else {
+		frame = append(frame, checksum(frame[3:]))
+	}
+
+	for range s.o.Retries {
+		if _, err := s.p.rw.Write(frame); err != nil {
+			return err
+		}
+		b, err := s.p.readByte(s.o.Timeout)
+		if err == ErrTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch b {
+		case ack:
+			return nil
+		case can:
+			if s.cancelled() {
+				return ErrCancelled
+			}
+		}
+		// NAK or line noise: send it again.
+	}
+	s.p.cancel()
+	return blockError(seq, ErrTooManyRetries)
+}
+
+// sendData sends r as numbered blocks starting from 1, then ends the
+// file with EOT.
+func (s *sender) sendData(r io.Reader, name string, size int64, use1K bool) error {
+	buf := make([]byte, 128)
+	if use1K {
+		buf = make([]byte, 1024)
+	}
+	seq := byte(1)
+	var done int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			bs := 128
+			if n > 128 {
+				bs = 1024
+			}
+			if err := s.sendBlock(seq, buf[:n], bs, sub); err != nil {
+				return err
+			}
+			seq++
+			done += int64(n)
+			s.o.progress(name, done, size)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			s.p.cancel()
+			return err
+		}
+	}
+
+	for range s.o.Retries {
+		if err := s.p.write(eot); err != nil {
+			return err
+		}
+		b, err := s.p.readByte(s.o.Timeout)
+		if err == ErrTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if b == ack {
+			return nil
+		}
+	}
+	return fmt.Errorf("EOT: %w", ErrTooManyRetries)
+}
+
+type receiver struct {
+	p        *port
+	o        Options
+	useCRC   bool
+	fallback bool // drop to checksum mode if CRC requests go unanswered
+}
+
+// start asks the sender to begin and returns the first byte of its
+// reply: a block header, EOT, or CAN.
+func (r *receiver) start() (byte, error) {
+	for try := range r.o.Retries {
+		if r.fallback && try == r.o.Retries/2 {
+			r.useCRC = false
+		}
+		req := byte(nak)
+		if r.useCRC {
+			req = crc
+		}
+		if err := r.p.write(req); err != nil {
+			return 0, err
+		}
+		b, err := r.p.readByte(min(r.o.Timeout, startPoll))
+		if err == ErrTimeout {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case soh, stx, eot, can:
+			return b, nil
+		}
+	}
+	return 0, ErrTimeout
+}
+
+// readBlock reads the rest of a block whose header byte hdr has been
+// read. ok is false if the block was damaged.
+func (r *receiver) readBlock(hdr byte) (seq byte, data []byte, ok bool, err error) {
+	size := 128
+	if hdr == stx {
+		size = 1024
+	}
+	n := 2 + size + 1
+	if r.useCRC {
+		n++
+	}
+	b, err := r.p.readN(n, r.o.Timeout)
+	if err == ErrTimeout {
+		return 0, nil, false, nil
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if b[0] != ^b[1] {
+		return 0, nil, false, nil
+	}
+	data = b[2 : 2+size]
+	if r.useCRC {
+		ok = binary.BigEndian.Uint16(b[2+size:]) == crc16(data)
+	} else {
+		ok = b[2+size] == checksum(data)
+	}
+	return b[0], data, ok, nil
+}
+
+// recvData receives blocks numbered from 1 into w until EOT. hdr, if
+// non-zero, is the first byte of the transfer, already read. If size
+// is not negative, output is cut off after size bytes, dropping the
+// padding.
+func (r *receiver) recvData(w io.Writer, name string, size int64, hdr byte) (int64, error) {
+	expect := byte(1)
+	var done int64
+	errs := 0
+	for {
+		if hdr == 0 {
+			b, err := r.p.readByte(r.o.Timeout)
+			if err != nil && err != ErrTimeout {
+				return done, err
+			}
+			if err == ErrTimeout {
+				if errs++; errs > r.o.Retries {
+					r.p.cancel()
+					return done, ErrTimeout
+				}
+				r.p.write(nak)
+				continue
+			}
+			hdr = b
+		}
+		switch hdr {
+		case soh, stx:
+			seq, data, ok, err := r.readBlock(hdr)
+			if err != nil {
+				return done, err
+			}
+			if !ok {
+				if errs++; errs > r.o.Retries {
+					r.p.cancel()
+					return done, blockError(expect, ErrTooManyRetries)
+				}
+				r.p.purge()
+				r.p.write(nak)
+				break
+			}
+			if seq == expect-1 {
+				// Our ACK was lost and the sender repeated the block.
+				r.p.write(ack)
+				break
+			}
+			if seq != expect {
+				r.p.cancel()
+				return done, fmt.Errorf("xfer: got block %d, want %d", seq, expect)
+			}
+			if size >= 0 {
+				data = data[:min(int64(len(data)), size-done)]
+			}
+			if _, err := w.Write(data); err != nil {
+				r.p.cancel()
+				return done, err
+			}
+			done += int64(len(data))
+			expect++
+			errs = 0
+			r.p.write(ack)
+			r.o.progress(name, done, size)
+		case eot:
+			return done, r.p.write(ack)
+		case can:
+			if b, err := r.p.readByte(time.Second); err == nil && b == can {
+				return done, ErrCancelled
+			}
+		}
+		hdr = 0
+	}
+}
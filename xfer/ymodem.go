@@ -0,0 +1,152 @@
+package xfer
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is a file to send with YmodemSend.
+type File struct {
+	Name    string
+	Size    int64 // -1 if unknown
+	ModTime time.Time
+	Data    io.Reader
+}
+
+// YmodemSend sends files to a YMODEM receiver on rw, in 1K blocks.
+func YmodemSend(rw io.ReadWriter, files []File, o *Options) error {
+	s := &sender{p: newPort(rw), o: o.withDefaults()}
+	for _, f := range files {
+		if err := s.waitStart(); err != nil {
+			return err
+		}
+		if err := s.sendHeader(ymodemHeader(f)); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if err := s.waitStart(); err != nil {
+			return err
+		}
+		if err := s.sendData(f.Data, f.Name, f.Size, true); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	// An empty header ends the batch.
+	if err := s.waitStart(); err != nil {
+		return err
+	}
+	return s.sendHeader(nil)
+}
+
+// sendHeader sends a block 0 holding hdr.
+func (s *sender) sendHeader(hdr []byte) error {
+	size := 128
+	if len(hdr) > 128 {
+		size = 1024
+	}
+	return s.sendBlock(0, hdr, size, 0)
+}
+
+// ymodemHeader encodes f's block 0: its name, then its size and
+// modification time (octal), separated by spaces.
+func ymodemHeader(f File) []byte {
+	b := append([]byte(f.Name), 0)
+	if f.Size >= 0 {
+		b = strconv.AppendInt(b, f.Size, 10)
+		if !f.ModTime.IsZero() {
+			b = append(b, ' ')
+			b = strconv.AppendInt(b, f.ModTime.Unix(), 8)
+		}
+	}
+	return b[:min(len(b), 1024)]
+}
+
+// YmodemReceive receives a batch of files from a YMODEM sender on rw.
+// For each file, create is called with its name, size (-1 if the
+// sender didn't say), and modification time (zero if unknown), and the
+// file's data is written to the WriteCloser it returns, which is then
+// closed. An error from create cancels the transfer.
+func YmodemReceive(rw io.ReadWriter, create func(name string, size int64, modTime time.Time) (io.WriteCloser, error), o *Options) error {
+	r := &receiver{p: newPort(rw), o: o.withDefaults(), useCRC: true}
+	for {
+		name, size, modTime, err := r.recvHeader()
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return nil
+		}
+		wc, err := create(name, size, modTime)
+		if err != nil {
+			r.p.cancel()
+			return err
+		}
+		hdr, err := r.start()
+		if err == nil {
+			_, err = r.recvData(wc, name, size, hdr)
+		}
+		if cerr := wc.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+// recvHeader receives a block 0, returning an empty name at the end of
+// the batch.
+func (r *receiver) recvHeader() (name string, size int64, modTime time.Time, err error) {
+	for range r.o.Retries {
+		hdr, err := r.start()
+		if err != nil {
+			return "", 0, time.Time{}, err
+		}
+		switch hdr {
+		case can:
+			if b, err := r.p.readByte(time.Second); err == nil && b == can {
+				return "", 0, time.Time{}, ErrCancelled
+			}
+			continue
+		case eot:
+			// A repeated EOT from the previous file.
+			r.p.write(ack)
+			continue
+		}
+		seq, data, ok, err := r.readBlock(hdr)
+		if err != nil {
+			return "", 0, time.Time{}, err
+		}
+		if !ok || seq != 0 {
+			r.p.purge()
+			continue
+		}
+		r.p.write(ack)
+		name, size, modTime = parseHeader(data)
+		return name, size, modTime, nil
+	}
+	r.p.cancel()
+	return "", 0, time.Time{}, fmt.Errorf("xfer: no YMODEM header: %w", ErrTooManyRetries)
+}
+
+func parseHeader(data []byte) (name string, size int64, modTime time.Time) {
+	name, rest, _ := strings.Cut(string(data), "\x00")
+	if i := strings.IndexByte(rest, 0); i >= 0 {
+		rest = rest[:i]
+	}
+	size = -1
+	fields := strings.Fields(rest)
+	if len(fields) > 0 {
+		if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			size = n
+		}
+	}
+	if len(fields) > 1 {
+		if t, err := strconv.ParseInt(fields[1], 8, 64); err == nil && t > 0 {
+			modTime = time.Unix(t, 0)
+		}
+	}
+	return name, size, modTime
+}
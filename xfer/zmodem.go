@@ -0,0 +1,495 @@
+package xfer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ZMODEM framing bytes.
+const (
+	zpad  = '*'
+	zdle  = 0x18
+	zbin  = 'A'
+	zhex  = 'B'
+	zbin3 = 'C'
+
+	zcrce = 'h' // end of frame, header follows
+	zcrcg = 'i' // frame continues
+	zcrcq = 'j' // frame continues, ZACK expected
+	zcrcw = 'k' // end of frame, ZACK expected
+	zrub0 = 'l' // escaped 0x7f
+	zrub1 = 'm' // escaped 0xff
+)
+
+// ZMODEM frame types.
+const (
+	zrqinit = 0
+	zrinit  = 1
+	zsinit  = 2
+	zack    = 3
+	zfile   = 4
+	zskip   = 5
+	znak    = 6
+	zabort  = 7
+	zfin    = 8
+	zrpos   = 9
+	zdata   = 10
+	zeof    = 11
+	zferr   = 12
+	zcan    = 16
+)
+
+// ZRINIT capability flags.
+const (
+	canFDX  = 0x01
+	canOVIO = 0x02
+	canFC32 = 0x20
+)
+
+// maxSubpacket bounds a ZMODEM data subpacket; real ones are 1K or less.
+const maxSubpacket = 8192
+
+// ErrSkip may be returned by the create func of ZmodemReceive to skip
+// a file and carry on with the rest of the batch.
+var ErrSkip = errors.New("xfer: skip file")
+
+var errBadFrame = errors.New("xfer: damaged ZMODEM frame")
+
+// ZmodemReceive receives a batch of files from a ZMODEM sender (such
+// as sz) on rw. For each file, create is called with its name, size
+// (-1 if the sender didn't say), and modification time (zero if
+// unknown), and the data is written to the WriteCloser it returns,
+// which is then closed. If create returns ErrSkip the file is skipped;
+// any other error cancels the transfer.
+func ZmodemReceive(rw io.ReadWriter, create func(name string, size int64, modTime time.Time) (io.WriteCloser, error), o *Options) error {
+	z := &zreceiver{p: newPort(rw), o: o.withDefaults(), create: create}
+	return z.run()
+}
+
+type zreceiver struct {
+	p      *port
+	o      Options
+	create func(string, int64, time.Time) (io.WriteCloser, error)
+
+	// The file being received.
+	name string
+	size int64
+	wc   io.WriteCloser
+	pos  uint32
+}
+
+func (z *zreceiver) run() error {
+	defer z.closeFile()
+	errs := 0
+	z.sendHex(zrinit, [4]byte{0, 0, 0, canFDX | canOVIO | canFC32})
+	for {
+		typ, hdr, wide, err := z.readHeader()
+		if err != nil {
+			if err == ErrCancelled {
+				return err
+			}
+			if err != errBadFrame && err != ErrTimeout {
+				return err
+			}
+			if errs++; errs > z.o.Retries {
+				z.p.cancel()
+				return ErrTooManyRetries
+			}
+			// Nudge the sender with whatever it is waiting for.
+			if z.wc != nil {
+				z.sendPos(zrpos)
+			} else {
+				z.sendHex(zrinit, [4]byte{0, 0, 0, canFDX | canOVIO | canFC32})
+			}
+			continue
+		}
+		errs = 0
+
+		switch typ {
+		case zrqinit:
+			z.sendHex(zrinit, [4]byte{0, 0, 0, canFDX | canOVIO | canFC32})
+		case zsinit:
+			// The attention string is of no use to us.
+			if _, _, err := z.readSubpacket(wide); err != nil {
+				z.sendHex(znak, [4]byte{})
+				continue
+			}
+			z.sendHex(zack, [4]byte{})
+		case zfile:
+			info, _, err := z.readSubpacket(wide)
+			if err != nil {
+				z.sendHex(znak, [4]byte{})
+				continue
+			}
+			if err := z.openFile(info); err == ErrSkip {
+				z.sendHex(zskip, [4]byte{})
+				continue
+			} else if err != nil {
+				z.p.cancel()
+				return err
+			}
+			z.sendPos(zrpos)
+		case zdata:
+			if z.wc == nil {
+				z.sendHex(zrinit, [4]byte{0, 0, 0, canFDX | canOVIO | canFC32})
+				continue
+			}
+			if binary.LittleEndian.Uint32(hdr[:]) != z.pos {
+				z.sendPos(zrpos)
+				continue
+			}
+			if err := z.readData(wide); err != nil {
+				if err == ErrCancelled {
+					return err
+				}
+				if err != errBadFrame && err != ErrTimeout {
+					z.p.cancel()
+					return err
+				}
+				z.sendPos(zrpos)
+			}
+		case zeof:
+			if z.wc == nil || binary.LittleEndian.Uint32(hdr[:]) != z.pos {
+				// Stale; the sender will follow up.
+				continue
+			}
+			if err := z.closeFile(); err != nil {
+				z.p.cancel()
+				return fmt.Errorf("%s: %w", z.name, err)
+			}
+			z.sendHex(zrinit, [4]byte{0, 0, 0, canFDX | canOVIO | canFC32})
+		case zfin:
+			z.sendHex(zfin, [4]byte{})
+			// The sender signs off with "OO"; don't leave it for the
+			// next reader.
+			z.p.readN(2, time.Second)
+			return nil
+		case zcan, zabort, zferr:
+			return ErrCancelled
+		}
+	}
+}
+
+// openFile starts receiving the file described by a ZFILE subpacket.
+func (z *zreceiver) openFile(info []byte) error {
+	name, size, modTime := parseHeader(info)
+	if name == "" {
+		return ErrSkip
+	}
+	z.closeFile()
+	wc, err := z.create(name, size, modTime)
+	if err != nil {
+		return err
+	}
+	z.name, z.size, z.wc, z.pos = name, size, wc, 0
+	return nil
+}
+
+func (z *zreceiver) closeFile() error {
+	if z.wc == nil {
+		return nil
+	}
+	err := z.wc.Close()
+	z.wc = nil
+	return err
+}
+
+// readData receives the subpackets of a ZDATA frame.
+func (z *zreceiver) readData(wide bool) error {
+	for {
+		data, end, err := z.readSubpacket(wide)
+		if err != nil {
+			return err
+		}
+		if _, err := z.wc.Write(data); err != nil {
+			return err
+		}
+		z.pos += uint32(len(data))
+		z.o.progress(z.name, int64(z.pos), z.size)
+		switch end {
+		case zcrce:
+			return nil
+		case zcrcq:
+			z.sendPos(zack)
+		case zcrcw:
+			z.sendPos(zack)
+			return nil
+		}
+	}
+}
+
+// sendPos sends a header of type typ carrying the current position.
+func (z *zreceiver) sendPos(typ byte) {
+	var hdr [4]byte
+	binary.LittleEndian.PutUint32(hdr[:], z.pos)
+	z.sendHex(typ, hdr)
+}
+
+// sendHex sends a hex header.
+func (z *zreceiver) sendHex(typ byte, hdr [4]byte) error {
+	raw := append([]byte{typ}, hdr[:]...)
+	raw = binary.BigEndian.AppendUint16(raw, crc16(raw))
+	b := []byte{zpad, zpad, zdle, zhex}
+	b = append(b, hex.EncodeToString(raw)...)
+	b = append(b, '\r', 0x8a)
+	if typ != zfin && typ != zack {
+		b = append(b, 0x11) // XON
+	}
+	_, err := z.p.rw.Write(b)
+	return err
+}
+
+// readHeader waits for the next frame header. wide reports whether the
+// frame uses 32-bit CRCs.
+func (z *zreceiver) readHeader() (typ byte, hdr [4]byte, wide bool, err error) {
+	// Find ZPAD [ZPAD] ZDLE, skipping line noise and stray data.
+	garbage := 0
+	for {
+		c, err := z.p.readByte(z.o.Timeout)
+		if err != nil {
+			return 0, hdr, false, err
+		}
+		if c&0x7f == zpad {
+			break
+		}
+		if garbage++; garbage > 2*maxSubpacket {
+			return 0, hdr, false, errBadFrame
+		}
+	}
+	var c byte
+	for {
+		if c, err = z.p.readByte(z.o.Timeout); err != nil {
+			return 0, hdr, false, err
+		}
+		if c&0x7f != zpad {
+			break
+		}
+	}
+	if c != zdle {
+		return 0, hdr, false, errBadFrame
+	}
+	kind, err := z.p.readByte(z.o.Timeout)
+	if err != nil {
+		return 0, hdr, false, err
+	}
+
+	var raw []byte
+	switch kind & 0x7f {
+	case zhex:
+		h, err := z.p.readN(14, z.o.Timeout)
+		if err != nil {
+			return 0, hdr, false, err
+		}
+		raw, err = hex.DecodeString(strings.ToLower(string(h)))
+		if err != nil || binary.BigEndian.Uint16(raw[5:]) != crc16(raw[:5]) {
+			return 0, hdr, false, errBadFrame
+		}
+		// Line end and XON; some senders omit parts of it.
+		z.p.readN(min(2, len(z.p.buf)), 0)
+	case zbin:
+		if raw, err = z.readEscaped(7); err != nil {
+			return 0, hdr, false, err
+		}
+		if binary.BigEndian.Uint16(raw[5:]) != crc16(raw[:5]) {
+			return 0, hdr, false, errBadFrame
+		}
+	case zbin3:
+		if raw, err = z.readEscaped(9); err != nil {
+			return 0, hdr, false, err
+		}
+		if binary.LittleEndian.Uint32(raw[5:]) != crc32.ChecksumIEEE(raw[:5]) {
+			return 0, hdr, false, errBadFrame
+		}
+		wide = true
+	default:
+		return 0, hdr, false, errBadFrame
+	}
+	copy(hdr[:], raw[1:5])
+	return raw[0], hdr, wide, nil
+}
+
+// readEscaped reads n ZDLE-escaped bytes.
+func (z *zreceiver) readEscaped(n int) ([]byte, error) {
+	b := make([]byte, 0, n)
+	for len(b) < n {
+		c, end, err := z.zdlRead()
+		if err != nil {
+			return nil, err
+		}
+		if end != 0 {
+			return nil, errBadFrame
+		}
+		b = append(b, c)
+	}
+	return b, nil
+}
+
+// readSubpacket reads a data subpacket, returning its data and the
+// frame end that terminated it.
+func (z *zreceiver) readSubpacket(wide bool) ([]byte, byte, error) {
+	var data []byte
+	for {
+		c, end, err := z.zdlRead()
+		if err != nil {
+			return nil, 0, err
+		}
+		if end == 0 {
+			if len(data) >= maxSubpacket {
+				return nil, 0, errBadFrame
+			}
+			data = append(data, c)
+			continue
+		}
+		n := 2
+		if wide {
+			n = 4
+		}
+		sum, err := z.readEscaped(n)
+		if err != nil {
+			return nil, 0, err
+		}
+		covered := append(data, end)
+		if wide {
+			if binary.LittleEndian.Uint32(sum) != crc32.ChecksumIEEE(covered) {
+				return nil, 0, errBadFrame
+			}
+		} else if binary.BigEndian.Uint16(sum) != crc16(covered) {
+			return nil, 0, errBadFrame
+		}
+		return data, end, nil
+	}
+}
+
+// zdlRead reads a byte, undoing ZDLE escaping. If the byte is a frame
+// end marker, it is returned as end instead. Unescaped XON/XOFF are
+// flow control and are dropped.
+func (z *zreceiver) zdlRead() (c byte, end byte, err error) {
+	for {
+		if c, err = z.p.readByte(z.o.Timeout); err != nil {
+			return 0, 0, err
+		}
+		switch c {
+		case 0x11, 0x91, 0x13, 0x93:
+			continue
+		case zdle:
+		default:
+			return c, 0, nil
+		}
+
+		// Escaped; five CANs in a row cancel the transfer.
+		cans := 1
+		for {
+			if c, err = z.p.readByte(z.o.Timeout); err != nil {
+				return 0, 0, err
+			}
+			switch c {
+			case 0x11, 0x91, 0x13, 0x93:
+				continue
+			case zdle:
+				if cans++; cans >= 5 {
+					return 0, 0, ErrCancelled
+				}
+				continue
+			}
+			break
+		}
+		switch c {
+		case zcrce, zcrcg, zcrcq, zcrcw:
+			return 0, c, nil
+		case zrub0:
+			return 0x7f, 0, nil
+		case zrub1:
+			return 0xff, 0, nil
+		}
+		if c&0x60 == 0x40 {
+			return c ^ 0x40, 0, nil
+		}
+		return 0, 0, errBadFrame
+	}
+}
+
+// zmodemStart is how a ZMODEM sender announces itself: a ZRQINIT hex
+// header.
+var zmodemStart = []byte{zpad, zpad, zdle, zhex, '0', '0'}
+
+// ZmodemDetect returns c wrapped to watch the data read from it for a
+// ZMODEM sender starting up, e.g. someone running sz on the device's
+// console. When one does, the wrapper takes the stream over and
+// receives the files as ZmodemReceive would, then hands the stream
+// back; the Read that spotted the transfer returns only the data
+// before it. done, if set, is called with the outcome of each
+// transfer. While a transfer runs, nothing else may write to c.
+func ZmodemDetect(c net.Conn, create func(name string, size int64, modTime time.Time) (io.WriteCloser, error), o *Options, done func(error)) net.Conn {
+	return &zdetectConn{Conn: c, create: create, o: o, done: done}
+}
+
+type zdetectConn struct {
+	net.Conn
+	create func(string, int64, time.Time) (io.WriteCloser, error)
+	o      *Options
+	done   func(error)
+
+	mu   sync.Mutex
+	tail []byte // end of the previous read, for matches split across reads
+	left *port  // the last transfer's port, until its input is drained
+}
+
+func (d *zdetectConn) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		// Input the last transfer read past its end comes first.
+		if d.left != nil {
+			if len(d.left.buf) == 0 && d.left.pending {
+				r := <-d.left.results
+				d.left.pending = false
+				d.left.buf = append(d.left.buf, r.b...)
+				if len(d.left.buf) == 0 {
+					d.left = nil
+					return 0, r.err
+				}
+			}
+			if len(d.left.buf) > 0 {
+				n := copy(p, d.left.buf)
+				d.left.buf = d.left.buf[n:]
+				return n, nil
+			}
+			d.left = nil
+		}
+
+		n, err := d.Conn.Read(p)
+		if n == 0 {
+			return n, err
+		}
+		seen := append(d.tail, p[:n]...)
+		i := bytes.Index(seen, zmodemStart)
+		if i < 0 {
+			d.tail = append(d.tail[:0], seen[max(0, len(seen)-len(zmodemStart)+1):]...)
+			return n, err
+		}
+		d.tail = d.tail[:0]
+
+		// Hand the stream over, starting at the ZRQINIT.
+		z := &zreceiver{p: newPort(d.Conn), o: d.o.withDefaults(), create: d.create}
+		z.p.buf = append(z.p.buf, seen[i:]...)
+		zerr := z.run()
+		if d.done != nil {
+			d.done(zerr)
+		}
+		d.left = z.p
+
+		// Only return what came before the transfer.
+		if before := i - (len(seen) - n); before > 0 || err != nil {
+			return max(before, 0), err
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package xfer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// zescape ZDLE-escapes b as a sender must: ZDLE and the flow control
+// bytes by flipping bit 6, and 0x7f and 0xff with ZRUB0 and ZRUB1.
+func zescape(b []byte) []byte {
+	var out []byte
+	for _, c := range b {
+		switch c {
+		case zdle, 0x10, 0x90, 0x11, 0x91, 0x13, 0x93:
+			out = append(out, zdle, c^0x40)
+		case 0x7f:
+			out = append(out, zdle, zrub0)
+		case 0xff:
+			out = append(out, zdle, zrub1)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// line returns both ends of a loopback TCP connection, closed when the
+// test ends. ZMODEM needs a line that buffers, as a serial one does:
+// unlike XMODEM, both sides write without waiting their turn.
+func line(t *testing.T) (a, b net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if a, err = net.Dial("tcp", l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = l.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// zsender plays a ZMODEM sender, as sz would, reading the receiver's
+// headers with the receiver's own parser.
+type zsender struct {
+	t    *testing.T
+	rw   io.ReadWriter
+	z    *zreceiver
+	wide bool // send CRC-32 frames
+}
+
+func newZsender(t *testing.T, rw io.ReadWriter, wide bool) *zsender {
+	return &zsender{t: t, rw: rw, z: &zreceiver{p: newPort(rw), o: (&Options{Timeout: 2 * time.Second}).withDefaults()}, wide: wide}
+}
+
+func (s *zsender) write(b []byte) {
+	if _, err := s.rw.Write(b); err != nil {
+		s.t.Error(err)
+	}
+}
+
+// header sends a binary header.
+func (s *zsender) header(typ byte, arg uint32) {
+	raw := binary.LittleEndian.AppendUint32([]byte{typ}, arg)
+	kind := byte(zbin)
+	if s.wide {
+		kind = zbin3
+		raw = binary.LittleEndian.AppendUint32(raw, crc32.ChecksumIEEE(raw))
+	} else {
+		raw = binary.BigEndian.AppendUint16(raw, crc16(raw))
+	}
+	s.write(append([]byte{zpad, zdle, kind}, zescape(raw)...))
+}
+
+// subpacket encodes a data subpacket ending with end.
+func (s *zsender) subpacket(data []byte, end byte) []byte {
+	out := append(zescape(data), zdle, end)
+	covered := append(bytes.Clone(data), end)
+	if s.wide {
+		return append(out, zescape(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(covered)))...)
+	}
+	return append(out, zescape(binary.BigEndian.AppendUint16(nil, crc16(covered)))...)
+}
+
+// expect reads headers until one of type want, returning its argument.
+func (s *zsender) expect(want byte) uint32 {
+	for {
+		typ, hdr, _, err := s.z.readHeader()
+		if err != nil {
+			s.t.Errorf("sender waiting for header %d: %v", want, err)
+			return 0
+		}
+		if typ == want {
+			return binary.LittleEndian.Uint32(hdr[:])
+		}
+	}
+}
+
+// start announces the sender, as sz does after telling the terminal to
+// run rz.
+func (s *zsender) start() {
+	s.write([]byte("rz\r**\x18B00000000000000\r\x8a\x11"))
+	s.expect(zrinit)
+}
+
+// file offers a file and sends data from wherever the receiver asks.
+// corrupt, if set, may damage a subpacket the first time it is sent.
+func (s *zsender) file(name string, data []byte, corrupt func(off int, sub []byte)) {
+	s.header(zfile, 0)
+	s.write(s.subpacket([]byte(name+"\x00"+strconv.Itoa(len(data))+" 0"), zcrcw))
+	pos := s.expect(zrpos)
+	for {
+		s.header(zdata, pos)
+		var frame []byte
+		for off := int(pos); off < len(data); off += 1024 {
+			end := byte(zcrcg)
+			if off+1024 >= len(data) {
+				end = zcrce
+			}
+			sub := s.subpacket(data[off:min(off+1024, len(data))], end)
+			if corrupt != nil {
+				corrupt(off, sub)
+			}
+			frame = append(frame, sub...)
+		}
+		s.write(frame)
+		s.header(zeof, uint32(len(data)))
+		typ, hdr, _, err := s.z.readHeader()
+		if err != nil {
+			s.t.Errorf("sender waiting after ZEOF: %v", err)
+			return
+		}
+		switch typ {
+		case zrinit:
+			return
+		case zrpos:
+			pos = binary.LittleEndian.Uint32(hdr[:])
+		default:
+			s.t.Errorf("sender got header %d after ZEOF", typ)
+			return
+		}
+	}
+}
+
+// finish ends the session.
+func (s *zsender) finish() {
+	s.header(zfin, 0)
+	s.expect(zfin)
+	s.write([]byte("OO"))
+}
+
+// zreceive runs ZmodemReceive on rw, returning the files received.
+func zreceive(t *testing.T, rw io.ReadWriter) (map[string][]byte, error) {
+	t.Helper()
+	files := map[string]*bytes.Buffer{}
+	err := ZmodemReceive(rw, func(name string, size int64, modTime time.Time) (io.WriteCloser, error) {
+		files[name] = &bytes.Buffer{}
+		return nopWC{files[name]}, nil
+	}, &Options{Timeout: 2 * time.Second})
+	got := map[string][]byte{}
+	for name, b := range files {
+		got[name] = b.Bytes()
+	}
+	return got, err
+}
+
+// TestZmodem receives a batch sent with CRC-16 and CRC-32 frames. The
+// data covers every byte value, so every ZDLE escape is exercised.
+func TestZmodem(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		wide bool
+	}{
+		{"CRC16", false},
+		{"CRC32", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := line(t)
+			want := map[string][]byte{"one.bin": testData(5000), "two.bin": testData(300)}
+			go func() {
+				s := newZsender(t, a, tc.wide)
+				s.start()
+				s.file("one.bin", want["one.bin"], nil)
+				s.file("two.bin", want["two.bin"], nil)
+				s.finish()
+			}()
+			got, err := zreceive(t, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("received %d files, want %d", len(got), len(want))
+			}
+			for name, data := range want {
+				if !bytes.Equal(got[name], data) {
+					t.Errorf("%s: received %d bytes, mismatched", name, len(got[name]))
+				}
+			}
+		})
+	}
+}
+
+// TestZmodemResend damages a subpacket's CRC-32: the receiver must ask
+// for the data again from the end of the last good subpacket.
+func TestZmodemResend(t *testing.T) {
+	a, b := line(t)
+	data := testData(2048)
+	damaged := false
+	go func() {
+		s := newZsender(t, a, true)
+		s.start()
+		s.file("f", data, func(off int, sub []byte) {
+			if off == 1024 && !damaged {
+				damaged = true
+				sub[len(sub)-1] ^= 0x01
+			}
+		})
+		s.finish()
+	}()
+	got, err := zreceive(t, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !damaged || !bytes.Equal(got["f"], data) {
+		t.Fatalf("received %d bytes, mismatched", len(got["f"]))
+	}
+}
+
+// TestZmodemCancel cancels a transfer part way through a subpacket,
+// as sz does when interrupted.
+func TestZmodemCancel(t *testing.T) {
+	a, b := line(t)
+	go func() {
+		s := newZsender(t, a, true)
+		s.start()
+		s.header(zfile, 0)
+		s.write(s.subpacket([]byte("f\x001000 0"), zcrcw))
+		s.expect(zrpos)
+		s.header(zdata, 0)
+		s.write(append(zescape(testData(100)), bytes.Repeat([]byte{can}, 8)...))
+	}()
+	if _, err := zreceive(t, b); !errors.Is(err, ErrCancelled) {
+		t.Fatalf("ZmodemReceive: %v, want ErrCancelled", err)
+	}
+}
+
+// TestZmodemDetect starts sz part way through a console session: the
+// files must be received and the console data around them kept.
+func TestZmodemDetect(t *testing.T) {
+	a, b := line(t)
+	data := testData(3000)
+	go func() {
+		a.Write([]byte("$ sz f.bin\r\n"))
+		s := newZsender(t, a, true)
+		s.start()
+		s.file("f.bin", data, nil)
+		s.finish()
+		a.Write([]byte("$ "))
+		a.Close()
+	}()
+	var got bytes.Buffer
+	zerr := errors.New("no transfer")
+	c := ZmodemDetect(b, func(name string, size int64, modTime time.Time) (io.WriteCloser, error) {
+		return nopWC{&got}, nil
+	}, nil, func(err error) { zerr = err })
+	out, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zerr != nil || !bytes.Equal(got.Bytes(), data) {
+		t.Fatalf("transfer: %v, received %d bytes", zerr, got.Len())
+	}
+	// sz's "rz\r" comes before the ZRQINIT, so it is console data.
+	if string(out) != "$ sz f.bin\r\nrz\r$ " {
+		t.Fatalf("console got %q", out)
+	}
+}